@@ -2,7 +2,13 @@ package amf
 
 import (
 	"encoding/binary"
-	"io"
+	"fmt"
+	"maps"
+	"math"
+	"reflect"
+	"slices"
+	"time"
+	"unsafe"
 )
 
 type AMFVersion uint8
@@ -14,19 +20,32 @@ const (
 
 type ECMAArray map[string]interface{}
 
+// Undefined is the ActionScript undefined value. Decoders produce it only with
+// KeepUndefined set and decode undefined as nil otherwise.
+type Undefined struct{}
+
+type TypedObject struct {
+	Class   string
+	Members map[string]interface{}
+}
+
 const (
-	amf0Number    byte = 0x00
-	amf0Boolean        = 0x01
-	amf0String         = 0x02
-	amf0Object         = 0x03
-	amf0Null           = 0x05
-	amf0Undefined      = 0x06
-	amf0Reference      = 0x07
-	amf0Array          = 0x08
-	amf0ObjectEnd      = 0x09
-	amf0StrictArr      = 0x0a
-	amf0Date           = 0x0b
-	amf0StringExt      = 0x0c
+	amf0Number      byte = 0x00
+	amf0Boolean          = 0x01
+	amf0String           = 0x02
+	amf0Object           = 0x03
+	amf0Null             = 0x05
+	amf0Undefined        = 0x06
+	amf0Reference        = 0x07
+	amf0Array            = 0x08
+	amf0ObjectEnd        = 0x09
+	amf0StrictArr        = 0x0a
+	amf0Date             = 0x0b
+	amf0StringExt        = 0x0c
+	amf0Unsupported      = 0x0d
+	amf0XMLDocument      = 0x0f
+	amf0TypedObject      = 0x10
+	amf0AVMPlus          = 0x11
 )
 
 const (
@@ -37,23 +56,407 @@ const (
 	amf3Integer           = 0x04
 	amf3Double            = 0x05
 	amf3String            = 0x06
+	amf3XMLDocument       = 0x07
 	amf3Date              = 0x08
 	amf3Array             = 0x09
 	amf3Object            = 0x0a
+	amf3XML               = 0x0b
 	amf3ByteArray         = 0x0c
 	amf3VectorInt         = 0x0d
-	amf3VectorUint        = 0x0d
-	amf3VectorDouble      = 0x0d
-	amf3VectorObject      = 0x0d
+	amf3VectorUint        = 0x0e
+	amf3VectorDouble      = 0x0f
+	amf3VectorObject      = 0x10
+	amf3Dictionary        = 0x11
 )
 
-func writeBytes(n int, w io.Writer, data []byte) (int, error) {
-	newn, err := w.Write(data)
-	return n + newn, err
+// Decoder holds the reference tables shared by consecutive values of a
+// single message or file body.
+type Decoder struct {
+	// Ordered makes objects and ECMA arrays decode to OrderedObject and
+	// OrderedECMAArray, preserving the wire order of their members.
+	Ordered bool
+	// KeepUndefined decodes undefined as Undefined rather than nil.
+	KeepUndefined bool
+	// Location is the location of decoded dates, time.Local if nil.
+	Location *time.Location
+	// Strict rejects data that is accepted otherwise: bytes after the value
+	// passed to DecodeAMF0, DecodeAMF3 or Unmarshal, an ECMA array count
+	// other than its number of members, a non-zero AMF0 date timezone and
+	// booleans other than 0 and 1.
+	Strict bool
+	// Lenient accepts those and also objects missing their end marker,
+	// recording a warning for each. Strict takes precedence.
+	Lenient bool
+
+	objects0 []interface{}
+	strings3 []string
+	objects3 []interface{}
+	traits3  []traits3
+	// raw is the value decoded by RawValue.Decode, resolving lazyRef entries.
+	raw      *RawValue
+	warnings []string
+}
+
+func NewDecoder() *Decoder {
+	return &Decoder{}
+}
+
+func (d *Decoder) Reset() {
+	d.objects0 = nil
+	d.strings3 = nil
+	d.objects3 = nil
+	d.traits3 = nil
+	d.raw = nil
+	d.warnings = nil
+}
+
+// Warnings returns what a Lenient decoder accepted since it was created or
+// Reset.
+func (d *Decoder) Warnings() []string {
+	return d.warnings
+}
+
+// deviation reports malformed data accepted by default: an error when
+// Strict, and a warning when Lenient.
+func (d *Decoder) deviation(format string, args ...interface{}) error {
+	if d.Strict {
+		return fmt.Errorf(format, args...)
+	}
+	if d.Lenient {
+		d.warnings = append(d.warnings, fmt.Sprintf(format, args...))
+	}
+	return nil
+}
+
+// lenient reports whether malformed data rejected by default is accepted,
+// recording a warning.
+func (d *Decoder) lenient(format string, args ...interface{}) bool {
+	if !d.Lenient || d.Strict {
+		return false
+	}
+	d.warnings = append(d.warnings, fmt.Sprintf(format, args...))
+	return true
+}
+
+func (d *Decoder) trailing(v []byte, n int) error {
+	if n < len(v) {
+		return d.deviation("%d trailing bytes", len(v)-n)
+	}
+	return nil
+}
+
+func (d *Decoder) ecmaCount(num, n int) error {
+	if num != n {
+		return d.deviation("ECMA array count %d for %d members", num, n)
+	}
+	return nil
+}
+
+// Encoder is the encoding counterpart of Decoder. Reusing an Encoder, with
+// Reset between unrelated messages, avoids allocations in the Append methods.
+type Encoder struct {
+	// KeyOrder, when set, orders the keys of maps instead of sort.Strings.
+	// OrderedObject and OrderedECMAArray always keep their own order.
+	KeyOrder func(a, b string) int
+	// ExactIntegers makes integers beyond ±2^53, which a Number cannot hold
+	// exactly, an error instead of being rounded.
+	ExactIntegers bool
+	// NoReferences writes repeated pointers, maps and slices inline instead
+	// of as object references, making a cycle an error.
+	NoReferences bool
+	// SubMillisecond keeps the fraction of a millisecond of dates, which
+	// are otherwise rounded down to whole milliseconds as Flash Player has.
+	SubMillisecond bool
+	// Collection is the AMF3 form of Go slices and arrays other than []byte
+	// and []interface{}. AMF0 always writes them as strict arrays.
+	Collection Collection
+
+	strings3 map[string]int
+	traits3  map[string]int
+	objects0 map[objectKey]objectRef
+	objects3 map[objectKey]objectRef
+	// The sizes of the tables, which may exceed the maps when raw values
+	// repeat a string or traits inline, or objects have no identity.
+	nstrings3, ntraits3, nobjects0, nobjects3 int
+	// pending holds the keys of the values being encoded that wait for the
+	// object their encoding starts with, and encoding those of the values
+	// being encoded that a reference can't stop, as in encodeShared.
+	pending  []objectKey
+	encoding map[objectKey]bool
+	keys     []string
+	buf      []byte
+}
+
+// Collection selects how an Encoder writes Go slices and arrays in AMF3.
+type Collection int
+
+const (
+	// Array writes an array, which Flash Player reads as an Array.
+	Array Collection = iota
+	// Vector writes the vector of the element type, such as Vector.<int>
+	// for []int32, Vector.<Number> for []float64 and Vector.<String> for
+	// []string. Slices of registered structs are vectors of their class.
+	Vector
+	// ArrayCollection writes an array wrapped in the externalizable
+	// flex.messaging.io.ArrayCollection of Flex.
+	ArrayCollection
+)
+
+// arrayCollectionClass is the externalizable class whose content is the
+// array it wraps.
+const arrayCollectionClass = "flex.messaging.io.ArrayCollection"
+
+func NewEncoder() *Encoder {
+	return &Encoder{}
+}
+
+func (e *Encoder) Reset() {
+	clear(e.strings3)
+	clear(e.traits3)
+	clear(e.objects0)
+	clear(e.objects3)
+	e.nstrings3, e.ntraits3, e.nobjects0, e.nobjects3 = 0, 0, 0, 0
+}
+
+// tableSizes are the sizes of the reference tables of an Encoder.
+type tableSizes struct {
+	strings3, traits3, objects0, objects3 int
+}
+
+func (e *Encoder) sizes() tableSizes {
+	return tableSizes{e.nstrings3, e.ntraits3, e.nobjects0, e.nobjects3}
+}
+
+// rollback removes the table entries added since the tables had sizes s, so
+// that a value that fails to encode leaves no entries the decoder won't have.
+func (e *Encoder) rollback(s tableSizes) {
+	maps.DeleteFunc(e.strings3, func(_ string, i int) bool { return i >= s.strings3 })
+	maps.DeleteFunc(e.traits3, func(_ string, i int) bool { return i >= s.traits3 })
+	maps.DeleteFunc(e.objects0, func(_ objectKey, r objectRef) bool { return r.index >= s.objects0 })
+	maps.DeleteFunc(e.objects3, func(_ objectKey, r objectRef) bool { return r.index >= s.objects3 })
+	e.nstrings3, e.ntraits3, e.nobjects0, e.nobjects3 = s.strings3, s.traits3, s.objects0, s.objects3
+	e.pending = e.pending[:0]
+}
+
+// objectKey identifies a pointer, map or slice, which is encoded as a
+// reference when repeated. AMF3 dates are identified by the bits of their
+// value. Holding ptr keeps the values alive, so that a value encoded later
+// can't take the address of one in the tables.
+type objectKey struct {
+	typ  reflect.Type
+	ptr  unsafe.Pointer
+	len  int
+	date uint64
+}
+
+type objectRef struct {
+	index  int
+	marker byte
+}
+
+var (
+	mapType              = reflect.TypeFor[map[string]interface{}]()
+	ecmaArrayType        = reflect.TypeFor[ECMAArray]()
+	typedObjectType      = reflect.TypeFor[TypedObject]()
+	sliceType            = reflect.TypeFor[[]interface{}]()
+	orderedObjectType    = reflect.TypeFor[*OrderedObject]()
+	orderedECMAArrayType = reflect.TypeFor[*OrderedECMAArray]()
+)
+
+// Identity returns a comparable key for the values the encoders write as a
+// reference when they meet them again: maps, typed objects, pointers and
+// slices with a capacity. Decoders return the same value for a reference as
+// for its target, so values with the same key are one object on the wire.
+func Identity(v interface{}) (interface{}, bool) {
+	return objectKeyOf(v)
+}
+
+// objectKeyOf returns the key of v if it has an identity. Slices have one if
+// they have a capacity, as decoded empty arrays do, since those without one
+// may all share the same address.
+func objectKeyOf(v interface{}) (objectKey, bool) {
+	switch v := v.(type) {
+	case nil, float64, int, bool, string, Undefined, time.Time:
+		return objectKey{}, false
+	case map[string]interface{}:
+		return mapKey(mapType, v)
+	case ECMAArray:
+		return mapKey(ecmaArrayType, v)
+	case TypedObject:
+		return mapKey(typedObjectType, v.Members)
+	case []interface{}:
+		return objectKey{typ: sliceType, ptr: unsafe.Pointer(unsafe.SliceData(v)), len: len(v)}, cap(v) > 0
+	case *OrderedObject:
+		return objectKey{typ: orderedObjectType, ptr: unsafe.Pointer(v)}, v != nil
+	case *OrderedECMAArray:
+		return objectKey{typ: orderedECMAArrayType, ptr: unsafe.Pointer(v)}, v != nil
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Pointer, reflect.Map:
+		if !rv.IsNil() {
+			return objectKey{typ: rv.Type(), ptr: rv.UnsafePointer()}, true
+		}
+	case reflect.Slice:
+		if rv.Cap() > 0 {
+			return objectKey{typ: rv.Type(), ptr: rv.UnsafePointer(), len: rv.Len()}, true
+		}
+	}
+	return objectKey{}, false
+}
+
+func mapKey(typ reflect.Type, v map[string]interface{}) (objectKey, bool) {
+	if v == nil {
+		return objectKey{}, false
+	}
+	return objectKey{typ: typ, ptr: reflect.ValueOf(v).UnsafePointer()}, true
+}
+
+// encodeShared encodes v, a value with an identity, as a reference when it
+// was encoded before. Otherwise its key is pending until addObject numbers
+// the object its encoding starts with, before the members that may refer to
+// it.
+func (e *Encoder) encodeShared(b []byte, version AMFVersion, key objectKey, v interface{}, encode func([]byte, interface{}) ([]byte, error)) ([]byte, error) {
+	if !e.NoReferences {
+		if version == AMF0 {
+			if ref, ok := e.objects0[key]; ok && ref.index <= 0xffff {
+				b = append(b, amf0Reference)
+				return binary.BigEndian.AppendUint16(b, uint16(ref.index)), nil
+			}
+		} else if ref, ok := e.objects3[key]; ok {
+			b = append(b, ref.marker)
+			return encodeU29(b, ref.index<<1), nil
+		}
+	}
+	// A value met again while it is encoded is found above once addObject
+	// numbered it, and in pending until then. Only without references, or
+	// past the 65535 references of AMF0, is it tracked in encoding.
+	if e.encoding[key] || slices.Contains(e.pending, key) {
+		return b, fmt.Errorf("cycle through %s", key.typ)
+	}
+	track := e.NoReferences || version == AMF0 && e.nobjects0 > 0xffff
+	if track {
+		if e.encoding == nil {
+			e.encoding = make(map[objectKey]bool)
+		}
+		e.encoding[key] = true
+	}
+	mark := len(e.pending)
+	e.pending = append(e.pending, key)
+	b, err := encode(b, v)
+	if len(e.pending) > mark {
+		e.pending = e.pending[:mark]
+	}
+	if track {
+		delete(e.encoding, key)
+	}
+	return b, err
+}
+
+// addObject numbers an object written with marker, as decoders add it to
+// their reference table.
+func (e *Encoder) addObject(version AMFVersion, marker byte) {
+	objects, n := &e.objects3, &e.nobjects3
+	if version == AMF0 {
+		objects, n = &e.objects0, &e.nobjects0
+	}
+	if len(e.pending) > 0 && *objects == nil {
+		*objects = make(map[objectKey]objectRef)
+	}
+	for _, key := range e.pending {
+		(*objects)[key] = objectRef{index: *n, marker: marker}
+	}
+	e.pending = e.pending[:0]
+	*n++
+}
+
+// sortedKeys returns the sorted keys of v in the encoder's scratch space,
+// which is reclaimed by releaseKeys once the caller is done with them.
+func (e *Encoder) sortedKeys(v map[string]interface{}) ([]string, int) {
+	mark := len(e.keys)
+	for k := range v {
+		e.keys = append(e.keys, k)
+	}
+	keys := e.keys[mark:]
+	if e.KeyOrder != nil {
+		slices.SortFunc(keys, e.KeyOrder)
+	} else {
+		slices.Sort(keys)
+	}
+	return keys, mark
+}
+
+func (e *Encoder) releaseKeys(mark int) {
+	clear(e.keys[mark:])
+	e.keys = e.keys[:mark]
+}
+
+// newArray allocates the items of a decoded array. Empty arrays get a capacity
+// too, giving them an identity so that references to them are kept.
+func newArray(n int) []interface{} {
+	return make([]interface{}, n, max(n, 1))
+}
+
+// msToTime converts the milliseconds of an AMF Date. Whole milliseconds are
+// kept apart from the fraction, as multiplying by 1e6 first loses precision.
+func msToTime(ms float64) time.Time {
+	whole, frac := math.Modf(ms)
+	return time.UnixMilli(int64(whole)).Add(time.Duration(math.Round(frac * 1e6)))
+}
+
+func (d *Decoder) date(ms float64) time.Time {
+	t := msToTime(ms)
+	if d.Location != nil {
+		t = t.In(d.Location)
+	}
+	return t
+}
+
+// timeToMs is the inverse of msToTime. UnixMilli rounds down, also before
+// 1970, and unlike UnixNano holds any year Date can.
+func (e *Encoder) timeToMs(t time.Time) float64 {
+	ms := float64(t.UnixMilli())
+	if e.SubMillisecond {
+		ms += float64(t.Nanosecond()%1e6) / 1e6
+	}
+	return ms
+}
+
+const maxExactInteger = 1 << 53
+
+type number struct {
+	kind reflect.Kind // reflect.Int64, reflect.Uint64 or reflect.Float64
+	i    int64
+	u    uint64
+	f    float64
+}
+
+// toNumber converts any Go numeric value, including named numeric types.
+func toNumber(v interface{}) (number, bool) {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return number{kind: reflect.Int64, i: rv.Int()}, true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return number{kind: reflect.Uint64, u: rv.Uint()}, true
+	case reflect.Float32, reflect.Float64:
+		return number{kind: reflect.Float64, f: rv.Float()}, true
+	}
+	return number{}, false
 }
 
-func writeData(n int, w io.Writer, order binary.ByteOrder, data interface{}) (int, error) {
-	err := binary.Write(w, order, data)
-	newn := binary.Size(data)
-	return n + newn, err
+func (e *Encoder) float(n number) (float64, error) {
+	switch n.kind {
+	case reflect.Int64:
+		if e.ExactIntegers && (n.i > maxExactInteger || n.i < -maxExactInteger) {
+			return 0, fmt.Errorf("integer %d cannot be represented exactly", n.i)
+		}
+		return float64(n.i), nil
+	case reflect.Uint64:
+		if e.ExactIntegers && n.u > maxExactInteger {
+			return 0, fmt.Errorf("integer %d cannot be represented exactly", n.u)
+		}
+		return float64(n.u), nil
+	}
+	return n.f, nil
 }
@@ -3,63 +3,150 @@ package amf
 import (
 	"encoding/binary"
 	"fmt"
+	"iter"
 	"math"
 	"time"
 )
 
 func DecodeAMF0(v []byte) (interface{}, int, error) {
-	result, length, err := decodeAMF0(v)
+	result, length, err := NewDecoder().DecodeAMF0(v)
 	return result, length, err
 }
 
-func decodeAMF0(v []byte) (interface{}, int, error) {
+func (d *Decoder) DecodeAMF0(v []byte) (interface{}, int, error) {
+	value, n, err := d.decodeAMF0(v)
+	if err == nil {
+		err = d.trailing(v, n)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	return value, n, nil
+}
+
+// DecodeAllAMF0 decodes consecutive AMF0 values, such as the arguments of an
+// RTMP command. The values share one reference table, while each switch to
+// AMF3 starts with empty AMF3 tables.
+func DecodeAllAMF0(v []byte) ([]interface{}, error) {
+	var result []interface{}
+	for value, err := range ValuesAMF0(v) {
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, value)
+	}
+	return result, nil
+}
+
+// ValuesAMF0 is the iterator form of DecodeAllAMF0.
+func ValuesAMF0(v []byte) iter.Seq2[interface{}, error] {
+	return func(yield func(interface{}, error) bool) {
+		NewDecoder().ValuesAMF0(v)(yield)
+	}
+}
+
+// ValuesAMF0 decodes consecutive AMF0 values with the reference tables of d,
+// which they share with the values d decodes before and after them.
+func (d *Decoder) ValuesAMF0(v []byte) iter.Seq2[interface{}, error] {
+	return func(yield func(interface{}, error) bool) {
+		for offset := 0; offset < len(v); {
+			value, n, err := d.decodeAMF0(v[offset:])
+			if err != nil {
+				yield(nil, fmt.Errorf("offset %d: %s", offset, err))
+				return
+			}
+			offset += n
+			if !yield(value, nil) {
+				return
+			}
+		}
+	}
+}
+
+func (d *Decoder) decodeAMF0(v []byte) (interface{}, int, error) {
+	if len(v) == 0 {
+		return nil, 0, fmt.Errorf("EOF")
+	}
 	switch v[0] {
 	case amf0Number:
 		return decodeNumber(v)
 	case amf0Boolean:
-		return decodeBoolean(v)
+		return d.decodeBoolean(v)
 	case amf0String, amf0StringExt:
 		return decodeString(v)
 	case amf0Object:
-		return decodeObject(v)
+		return d.decodeObject(v)
+	case amf0TypedObject:
+		return d.decodeTypedObject(v)
 	case amf0Null:
 		return nil, 1, nil
 	case amf0Undefined:
+		if d.KeepUndefined {
+			return Undefined{}, 1, nil
+		}
 		return nil, 1, nil
+	case amf0Reference:
+		return d.decodeReference(v)
 	case amf0Array:
-		return decodeECMAArray(v)
+		return d.decodeECMAArray(v)
 	case amf0StrictArr:
-		return decodeStrictArray(v)
+		return d.decodeStrictArray(v)
 	case amf0Date:
-		return decodeDate(v)
+		return d.decodeDate(v)
+	case amf0AVMPlus:
+		return d.decodeAVMPlus(v)
 	}
 	return nil, 0, fmt.Errorf("unsupported type 0x%0X", v[0])
 }
 
 func decodeNumber(v []byte) (float64, int, error) {
+	if len(v) < 9 {
+		return 0, 0, fmt.Errorf("EOF")
+	}
 	return math.Float64frombits(binary.BigEndian.Uint64(v[1:9])), 9, nil
 }
 
-func decodeBoolean(v []byte) (bool, int, error) {
-	if v[1] == 0x1 {
-		return true, 2, nil
-	} else {
-		return false, 2, nil
+func (d *Decoder) decodeBoolean(v []byte) (bool, int, error) {
+	if len(v) < 2 {
+		return false, 0, fmt.Errorf("EOF")
+	}
+	// The specification makes any non-zero byte true, though writers
+	// should use 1.
+	if v[1] > 1 {
+		if err := d.deviation("invalid boolean 0x%02x", v[1]); err != nil {
+			return false, 0, err
+		}
 	}
+	return v[1] != 0x0, 2, nil
 }
 
-func decodeUTF8(v []byte) (string, int) {
+func decodeUTF8(v []byte) (string, int, error) {
+	if len(v) < 2 {
+		return "", 0, fmt.Errorf("EOF")
+	}
 	strlen := int(binary.BigEndian.Uint16(v[:2]))
+	if len(v) < 2+strlen {
+		return "", 0, fmt.Errorf("EOF")
+	}
 	s := string(v[2 : 2+strlen])
-	return s, 2 + strlen
+	return s, 2 + strlen, nil
 }
 
 func decodeString(v []byte) (string, int, error) {
 	if v[0] == amf0String {
-		s, n := decodeUTF8(v[1:])
+		s, n, err := decodeUTF8(v[1:])
+		if err != nil {
+			return "", 0, err
+		}
 		return s, 1 + n, nil
 	} else if v[0] == amf0StringExt {
+		if len(v) < 5 {
+			return "", 0, fmt.Errorf("EOF")
+		}
 		strlen := int(binary.BigEndian.Uint32(v[1:5]))
+		if strlen > len(v)-5 {
+			return "", 0, fmt.Errorf("EOF")
+		}
 		s := string(v[5 : 5+strlen])
 		return s, 5 + strlen, nil
 	} else {
@@ -67,66 +154,177 @@ func decodeString(v []byte) (string, int, error) {
 	}
 }
 
-func decodeECMAArray(v []byte) (ECMAArray, int, error) {
-	result := make(map[string]interface{})
-	num := binary.BigEndian.Uint32(v[1:5])
-	offset := 5
-	for i := uint32(0); i < num; i++ {
-		key, nkey := decodeUTF8(v[offset:])
-		offset += nkey
-		value, nvalue, err := decodeAMF0(v[offset:])
-		if err != nil {
-			return nil, 0, err
-		}
-		offset += nvalue
-		result[key] = value
+func (d *Decoder) decodeReference(v []byte) (interface{}, int, error) {
+	if len(v) < 3 {
+		return nil, 0, fmt.Errorf("EOF")
+	}
+	ref := int(binary.BigEndian.Uint16(v[1:3]))
+	if ref >= len(d.objects0) {
+		return nil, 0, fmt.Errorf("invalid reference %d", ref)
 	}
-	return ECMAArray(result), offset + 3, nil
+	obj, err := d.resolve(d.objects0, ref)
+	if err != nil {
+		return nil, 0, err
+	}
+	return obj, 3, nil
 }
 
-func decodeStrictArray(v []byte) ([]interface{}, int, error) {
-	result := make([]interface{}, 0)
-	num := binary.BigEndian.Uint32(v[1:5])
+func (d *Decoder) decodeAVMPlus(v []byte) (interface{}, int, error) {
+	d.strings3 = nil
+	d.objects3 = nil
+	d.traits3 = nil
+	value, n, err := d.decodeAMF3(v[1:])
+	if err != nil {
+		return nil, 0, err
+	}
+	return value, 1 + n, nil
+}
+
+func (d *Decoder) decodeECMAArray(v []byte) (interface{}, int, error) {
+	if len(v) < 5 {
+		return nil, 0, fmt.Errorf("EOF")
+	}
+	// The count is only a hint; members run up to the end marker.
+	num := int(binary.BigEndian.Uint32(v[1:5]))
+	result, set := d.newECMAArray0(num)
+	n := 0
+	offset, err := d.decodeProperties(v, 5, func(key string, value interface{}) {
+		set(key, value)
+		n++
+	})
+	if err == nil {
+		err = d.ecmaCount(num, n)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	return result, offset, nil
+}
+
+func (d *Decoder) decodeStrictArray(v []byte) ([]interface{}, int, error) {
+	if len(v) < 5 {
+		return nil, 0, fmt.Errorf("EOF")
+	}
+	num := int(binary.BigEndian.Uint32(v[1:5]))
 	offset := 5
-	for i := uint32(0); i < num; i++ {
-		value, nvalue, err := decodeAMF0(v[offset:])
+	if num > len(v)-offset {
+		return nil, 0, fmt.Errorf("EOF")
+	}
+	result := newArray(num)
+	d.objects0 = append(d.objects0, result)
+	for i := 0; i < num; i++ {
+		value, nvalue, err := d.decodeAMF0(v[offset:])
 		if err != nil {
 			return nil, 0, err
 		}
 		offset += nvalue
-		result = append(result, value)
+		result[i] = value
 	}
 	return result, offset, nil
 }
 
-func decodeDate(v []byte) (time.Time, int, error) {
-	t := int64(math.Float64frombits(binary.BigEndian.Uint64(v[1:9])) * 1000000)
-	if v[9] != 0x00 || v[10] != 0x00 {
-		return time.Unix(0, 0), 0, fmt.Errorf("invalid timezone")
+// decodeDate ignores the timezone, which the specification reserves and
+// some old servers fill with their UTC offset; the milliseconds are UTC.
+func (d *Decoder) decodeDate(v []byte) (time.Time, int, error) {
+	if len(v) < 11 {
+		return time.Time{}, 0, fmt.Errorf("EOF")
+	}
+	if err := d.timezone(binary.BigEndian.Uint16(v[9:11])); err != nil {
+		return time.Time{}, 0, err
+	}
+	return d.date(math.Float64frombits(binary.BigEndian.Uint64(v[1:9]))), 11, nil
+}
+
+func (d *Decoder) timezone(tz uint16) error {
+	if tz != 0 {
+		return d.deviation("date timezone %d", int16(tz))
+	}
+	return nil
+}
+
+func (d *Decoder) decodeObject(v []byte) (interface{}, int, error) {
+	return d.decodeObjectProperties(v, 1, "")
+}
+
+func (d *Decoder) decodeTypedObject(v []byte) (interface{}, int, error) {
+	class, offset, err := decodeUTF8(v[1:])
+	if err != nil {
+		return nil, 0, err
 	}
-	return time.Unix(0, t), 11, nil
+	return d.decodeObjectProperties(v, 1+offset, class)
 }
 
-func decodeObject(v []byte) (map[string]interface{}, int, error) {
-	result := make(map[string]interface{})
-	offset := 1
+func (d *Decoder) decodeObjectProperties(v []byte, offset int, class string) (interface{}, int, error) {
+	result, set := d.newObject0(class)
+	n, err := d.decodeProperties(v, offset, set)
+	if err != nil {
+		return nil, 0, err
+	}
+	return result, n, nil
+}
+
+// newObject0 creates an object, adds it to the reference table and returns
+// it with the function setting its members.
+func (d *Decoder) newObject0(class string) (interface{}, func(string, interface{})) {
+	if d.Ordered {
+		ordered := &OrderedObject{Class: class}
+		d.objects0 = append(d.objects0, ordered)
+		if class == "" {
+			return ordered, ordered.Set
+		}
+		// The members of an AMF0 typed object are all sealed.
+		return ordered, func(key string, value interface{}) {
+			ordered.Set(key, value)
+			ordered.Sealed = ordered.Len()
+		}
+	}
+	m := make(map[string]interface{})
+	var result interface{} = m
+	if class != "" {
+		result = TypedObject{Class: class, Members: m}
+	}
+	d.objects0 = append(d.objects0, result)
+	return result, func(key string, value interface{}) { m[key] = value }
+}
+
+// newECMAArray0 is the ECMA array counterpart of newObject0.
+func (d *Decoder) newECMAArray0(count int) (interface{}, func(string, interface{})) {
+	if d.Ordered {
+		ordered := &OrderedECMAArray{Count: count}
+		d.objects0 = append(d.objects0, ordered)
+		return ordered, ordered.Set
+	}
+	m := make(ECMAArray)
+	d.objects0 = append(d.objects0, m)
+	return m, func(key string, value interface{}) { m[key] = value }
+}
+
+func (d *Decoder) decodeProperties(v []byte, offset int, set func(string, interface{})) (int, error) {
 	for {
-		key, nkey := decodeUTF8(v[offset:])
+		key, nkey, err := decodeUTF8(v[offset:])
+		if err != nil {
+			if offset == len(v) && d.lenient("missing end of object") {
+				break
+			}
+			return 0, err
+		}
 		offset += nkey
 		if key == "" {
-			if v[offset] == byte(amf0ObjectEnd) {
+			if offset < len(v) && v[offset] == byte(amf0ObjectEnd) {
 				offset++
 				break
+			} else if d.lenient("missing end of object") {
+				break
 			} else {
-				return nil, 0, fmt.Errorf("invalid end of object")
+				return 0, fmt.Errorf("invalid end of object")
 			}
 		}
-		value, nvalue, err := decodeAMF0(v[offset:])
+		value, nvalue, err := d.decodeAMF0(v[offset:])
 		if err != nil {
-			return nil, 0, err
+			return 0, err
 		}
 		offset += nvalue
-		result[key] = value
+		set(key, value)
 	}
-	return result, offset, nil
+	return offset, nil
 }
@@ -2,175 +2,211 @@ package amf
 
 import (
 	"encoding/binary"
-	"fmt"
 	"io"
-	"sort"
+	"math"
+	"reflect"
 	"time"
 )
 
 func EncodeAMF0(w io.Writer, v interface{}) (int, error) {
-	return encodeAMF0(0, w, v)
+	return NewEncoder().EncodeAMF0(w, v)
 }
 
-func encodeAMF0(n int, w io.Writer, v interface{}) (int, error) {
+func (e *Encoder) EncodeAMF0(w io.Writer, v interface{}) (int, error) {
+	sizes := e.sizes()
+	b, err := e.encodeAMF0(e.buf[:0], v)
+	e.buf = b[:0]
+	if err != nil {
+		e.rollback(sizes)
+		return 0, err
+	}
+	return w.Write(b)
+}
+
+// AppendAMF0 appends the AMF0 encoding of v to dst. On error dst is returned
+// unchanged, and the reference tables of the Encoder are as before the call.
+func AppendAMF0(dst []byte, v interface{}) ([]byte, error) {
+	var e Encoder
+	return e.AppendAMF0(dst, v)
+}
+
+func (e *Encoder) AppendAMF0(dst []byte, v interface{}) ([]byte, error) {
+	sizes := e.sizes()
+	b, err := e.encodeAMF0(dst, v)
+	if err != nil {
+		e.rollback(sizes)
+		return dst, err
+	}
+	return b, nil
+}
+
+func (e *Encoder) encodeAMF0(b []byte, v interface{}) ([]byte, error) {
+	if key, ok := objectKeyOf(v); ok {
+		return e.encodeShared(b, AMF0, key, v, e.encodeValue0)
+	}
+	return e.encodeValue0(b, v)
+}
+
+func (e *Encoder) encodeValue0(b []byte, v interface{}) ([]byte, error) {
 	switch v.(type) {
 	case float64:
-		return encodeNumber(n, w, v.(float64))
+		return encodeNumber(b, v.(float64)), nil
 	case int:
-		return encodeNumber(n, w, float64(v.(int)))
+		return e.encodeInteger(b, int64(v.(int)))
 	case bool:
-		return encodeBoolean(n, w, v.(bool))
+		return encodeBoolean(b, v.(bool)), nil
 	case string:
-		return encodeString(n, w, v.(string))
+		return encodeString(b, v.(string)), nil
 	case nil:
-		return encodeNull(n, w)
+		return encodeNull(b), nil
+	case Undefined:
+		return append(b, amf0Undefined), nil
 	case map[string]interface{}:
-		return encodeObject(n, w, v.(map[string]interface{}))
+		return e.encodeObject(b, v.(map[string]interface{}))
+	case TypedObject:
+		return e.encodeTypedObject(b, v.(TypedObject))
 	case ECMAArray:
-		return encodeECMAArray(n, w, v.(ECMAArray))
+		return e.encodeECMAArray(b, v.(ECMAArray))
+	case *OrderedObject:
+		return e.encodeOrderedObject(b, v.(*OrderedObject))
+	case *OrderedECMAArray:
+		return e.encodeOrderedECMAArray(b, v.(*OrderedECMAArray))
 	case time.Time:
-		return encodeDate(n, w, v.(time.Time))
+		return encodeDate(b, e.timeToMs(v.(time.Time))), nil
 	case []interface{}:
-		return encodeStrictArray(n, w, v.([]interface{}))
-	}
-	return n, fmt.Errorf("type %T not supported", v)
+		return e.encodeStrictArray(b, v.([]interface{}))
+	case []byte:
+		// AMF0 has no byte array. Flash Player writes a ByteArray in an
+		// AMF0 message as an AMF3 one after an AVM+ marker, which the
+		// decoders read back as []byte, where a strict array of Numbers
+		// would come back as []interface{}.
+		b = append(b, amf0AVMPlus)
+		return encodeByteArray3(b, v.([]byte)), nil
+	case RawAMF0:
+		return e.encodeRaw0(b, v.(RawAMF0).RawValue)
+	case RawAMF3:
+		return e.encodeRaw0(b, v.(RawAMF3).RawValue)
+	}
+	return e.encodeOther(b, AMF0, v, e.encodeAMF0)
 }
 
-func encodeNumber(n int, w io.Writer, v float64) (int, error) {
-	n, err := writeBytes(n, w, []byte{amf0Number})
+func (e *Encoder) encodeInteger(b []byte, v int64) ([]byte, error) {
+	f, err := e.float(number{kind: reflect.Int64, i: v})
 	if err != nil {
-		return n, err
+		return b, err
 	}
-	return writeData(n, w, binary.BigEndian, v)
+	return encodeNumber(b, f), nil
+}
+
+func encodeNumber(b []byte, v float64) []byte {
+	b = append(b, amf0Number)
+	return binary.BigEndian.AppendUint64(b, math.Float64bits(v))
 }
 
-func encodeBoolean(n int, w io.Writer, v bool) (int, error) {
+func encodeBoolean(b []byte, v bool) []byte {
 	if v {
-		return writeBytes(n, w, []byte{amf0Boolean, 0x1})
+		return append(b, amf0Boolean, 0x1)
 	} else {
-		return writeBytes(n, w, []byte{amf0Boolean, 0x0})
+		return append(b, amf0Boolean, 0x0)
 	}
 }
 
-func encodeUTF8(n int, w io.Writer, v string) (int, error) {
-	var err error
-	n, err = writeData(n, w, binary.BigEndian, uint16(len(v)))
-	if err != nil {
-		return n, err
-	}
-	return writeBytes(n, w, []byte(v))
+func encodeUTF8(b []byte, v string) []byte {
+	b = binary.BigEndian.AppendUint16(b, uint16(len(v)))
+	return append(b, v...)
 }
 
-func encodeString(n int, w io.Writer, v string) (int, error) {
+func encodeString(b []byte, v string) []byte {
 	if len(v) < 0xffff {
-		n, err := writeBytes(n, w, []byte{amf0String})
-		if err != nil {
-			return n, err
-		}
-		return encodeUTF8(n, w, v)
+		b = append(b, amf0String)
+		return encodeUTF8(b, v)
 	} else {
-		n, err := writeBytes(n, w, []byte{amf0StringExt})
-		if err != nil {
-			return n, err
-		}
-		n, err = writeData(n, w, binary.BigEndian, uint32(len(v)))
-		if err != nil {
-			return n, err
-		}
-		return writeBytes(n, w, []byte(v))
+		b = append(b, amf0StringExt)
+		b = binary.BigEndian.AppendUint32(b, uint32(len(v)))
+		return append(b, v...)
 	}
 }
 
-func encodeObject(n int, w io.Writer, v map[string]interface{}) (int, error) {
-	n, err := writeBytes(n, w, []byte{amf0Object})
-	if err != nil {
-		return n, err
-	}
-	var keys []string
-	for k := range v {
-		keys = append(keys, k)
-	}
-	sort.Strings(keys)
-	for _, key := range keys {
-		value := v[key]
-		n, err = encodeUTF8(n, w, key)
-		if err != nil {
-			return n, err
-		}
-		n, err = encodeAMF0(n, w, value)
-		if err != nil {
-			return n, err
-		}
-	}
-	n, err = encodeUTF8(n, w, "")
-	if err != nil {
-		return n, err
-	}
-	return writeBytes(n, w, []byte{amf0ObjectEnd})
+func (e *Encoder) encodeObject(b []byte, v map[string]interface{}) ([]byte, error) {
+	b = append(b, amf0Object)
+	e.addObject(AMF0, amf0Object)
+	return e.encodeObjectProperties(b, v)
 }
 
-func encodeNull(n int, w io.Writer) (int, error) {
-	return writeBytes(n, w, []byte{amf0Null})
+func (e *Encoder) encodeTypedObject(b []byte, v TypedObject) ([]byte, error) {
+	b = append(b, amf0TypedObject)
+	e.addObject(AMF0, amf0TypedObject)
+	b = encodeUTF8(b, v.Class)
+	return e.encodeObjectProperties(b, v.Members)
 }
 
-func encodeECMAArray(n int, w io.Writer, v ECMAArray) (int, error) {
-	n, err := writeBytes(n, w, []byte{amf0Array})
-	if err != nil {
-		return n, err
-	}
-	var keys []string
-	for k := range v {
-		keys = append(keys, k)
-	}
-	sort.Strings(keys)
-	n, err = writeData(n, w, binary.BigEndian, uint32(len(keys)))
-	if err != nil {
-		return n, err
+func (e *Encoder) encodeOrderedObject(b []byte, v *OrderedObject) ([]byte, error) {
+	start := len(b)
+	if v.Class != "" {
+		b = append(b, amf0TypedObject)
+		b = encodeUTF8(b, v.Class)
+	} else {
+		b = append(b, amf0Object)
 	}
+	e.addObject(AMF0, b[start])
+	return e.encodeProperties(b, v.keys, v.values)
+}
+
+func (e *Encoder) encodeObjectProperties(b []byte, v map[string]interface{}) ([]byte, error) {
+	keys, mark := e.sortedKeys(v)
+	defer e.releaseKeys(mark)
+	return e.encodeProperties(b, keys, v)
+}
+
+func (e *Encoder) encodeProperties(b []byte, keys []string, v map[string]interface{}) ([]byte, error) {
+	var err error
 	for _, key := range keys {
-		value := v[key]
-		n, err = encodeUTF8(n, w, key)
-		if err != nil {
-			return n, err
-		}
-		n, err = encodeAMF0(n, w, value)
+		b = encodeUTF8(b, key)
+		b, err = e.encodeAMF0(b, v[key])
 		if err != nil {
-			return n, err
+			return b, err
 		}
 	}
-	n, err = encodeUTF8(n, w, "")
-	if err != nil {
-		return n, err
-	}
-	return writeBytes(n, w, []byte{amf0ObjectEnd})
+	b = encodeUTF8(b, "")
+	return append(b, amf0ObjectEnd), nil
 }
 
-func encodeDate(n int, w io.Writer, v time.Time) (int, error) {
-	n, err := writeBytes(n, w, []byte{amf0Date})
-	if err != nil {
-		return n, err
-	}
-	n, err = writeData(n, w, binary.BigEndian, float64(v.UnixNano()/1000000))
-	if err != nil {
-		return n, err
-	}
-	return writeBytes(n, w, []byte{0x00, 0x00})
+func encodeNull(b []byte) []byte {
+	return append(b, amf0Null)
 }
 
-func encodeStrictArray(n int, w io.Writer, v []interface{}) (int, error) {
-	n, err := writeBytes(n, w, []byte{amf0StrictArr})
-	if err != nil {
-		return n, err
-	}
-	n, err = writeData(n, w, binary.BigEndian, uint32(len(v)))
-	if err != nil {
-		return n, err
-	}
+func (e *Encoder) encodeECMAArray(b []byte, v ECMAArray) ([]byte, error) {
+	b = append(b, amf0Array)
+	e.addObject(AMF0, amf0Array)
+	keys, mark := e.sortedKeys(v)
+	defer e.releaseKeys(mark)
+	b = binary.BigEndian.AppendUint32(b, uint32(len(keys)))
+	return e.encodeProperties(b, keys, v)
+}
+
+func (e *Encoder) encodeOrderedECMAArray(b []byte, v *OrderedECMAArray) ([]byte, error) {
+	b = append(b, amf0Array)
+	e.addObject(AMF0, amf0Array)
+	b = binary.BigEndian.AppendUint32(b, uint32(v.count()))
+	return e.encodeProperties(b, v.keys, v.values)
+}
+
+func encodeDate(b []byte, ms float64) []byte {
+	b = append(b, amf0Date)
+	b = binary.BigEndian.AppendUint64(b, math.Float64bits(ms))
+	return append(b, 0x00, 0x00)
+}
+
+func (e *Encoder) encodeStrictArray(b []byte, v []interface{}) ([]byte, error) {
+	b = append(b, amf0StrictArr)
+	e.addObject(AMF0, amf0StrictArr)
+	b = binary.BigEndian.AppendUint32(b, uint32(len(v)))
+	var err error
 	for _, value := range v {
-		n, err = encodeAMF0(n, w, value)
+		b, err = e.encodeAMF0(b, value)
 		if err != nil {
-			return n, err
+			return b, err
 		}
 	}
-	return n, err
+	return b, nil
 }
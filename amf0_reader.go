@@ -0,0 +1,281 @@
+package amf
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+)
+
+// readChunk bounds the memory allocated ahead of the data: strings grow by at
+// most this many bytes as their data arrives, so a bogus length fails with
+// io.ErrUnexpectedEOF instead of a huge allocation.
+const readChunk = 64 << 10
+
+// ReadAMF0 decodes one AMF0 value from r, reading only the bytes of that
+// value so that r is left at the start of the next one. It returns io.EOF
+// when r is empty and io.ErrUnexpectedEOF when r ends inside the value.
+//
+// Markers and lengths are read with ReadByte when r is an io.ByteReader and
+// one byte at a time otherwise, so wrap a socket in a bufio.Reader.
+func ReadAMF0(r io.Reader) (interface{}, error) {
+	return NewDecoder().ReadAMF0(r)
+}
+
+// ReadAMF0 is the io.Reader form of DecodeAMF0. Consecutive values read with
+// the same Decoder share its reference table.
+func (d *Decoder) ReadAMF0(r io.Reader) (interface{}, error) {
+	rd := &amf0Reader{d: d}
+	if br, ok := r.(byteReader); ok {
+		rd.r = br
+	} else {
+		rd.r = &oneByteReader{Reader: r}
+	}
+	marker, err := rd.r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	return rd.valueOf(marker)
+}
+
+type byteReader interface {
+	io.Reader
+	io.ByteReader
+}
+
+// oneByteReader adds ReadByte to a reader without reading ahead of the value.
+type oneByteReader struct {
+	io.Reader
+	b [1]byte
+}
+
+func (r *oneByteReader) ReadByte() (byte, error) {
+	_, err := io.ReadFull(r.Reader, r.b[:])
+	return r.b[0], err
+}
+
+type amf0Reader struct {
+	d   *Decoder
+	r   byteReader
+	buf [8]byte
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+func (r *amf0Reader) byte() (byte, error) {
+	b, err := r.r.ReadByte()
+	return b, unexpectedEOF(err)
+}
+
+// read reads up to 8 bytes into the scratch buffer.
+func (r *amf0Reader) read(n int) ([]byte, error) {
+	if _, err := io.ReadFull(r.r, r.buf[:n]); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	return r.buf[:n], nil
+}
+
+func (r *amf0Reader) uint16() (int, error) {
+	b, err := r.read(2)
+	if err != nil {
+		return 0, err
+	}
+	return int(binary.BigEndian.Uint16(b)), nil
+}
+
+func (r *amf0Reader) uint32() (int, error) {
+	b, err := r.read(4)
+	if err != nil {
+		return 0, err
+	}
+	return int(binary.BigEndian.Uint32(b)), nil
+}
+
+func (r *amf0Reader) string(n int) (string, error) {
+	b := make([]byte, 0, min(n, readChunk))
+	for len(b) < n {
+		m := min(n-len(b), readChunk)
+		b = slices.Grow(b, m)
+		if _, err := io.ReadFull(r.r, b[len(b):len(b)+m]); err != nil {
+			return "", unexpectedEOF(err)
+		}
+		b = b[:len(b)+m]
+	}
+	return string(b), nil
+}
+
+func (r *amf0Reader) utf8() (string, error) {
+	n, err := r.uint16()
+	if err != nil {
+		return "", err
+	}
+	return r.string(n)
+}
+
+func (r *amf0Reader) value() (interface{}, error) {
+	marker, err := r.byte()
+	if err != nil {
+		return nil, err
+	}
+	return r.valueOf(marker)
+}
+
+func (r *amf0Reader) valueOf(marker byte) (interface{}, error) {
+	switch marker {
+	case amf0Number:
+		b, err := r.read(8)
+		if err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), nil
+	case amf0Boolean:
+		b, err := r.byte()
+		if err != nil {
+			return nil, err
+		}
+		if b > 1 {
+			if err := r.d.deviation("invalid boolean 0x%02x", b); err != nil {
+				return nil, err
+			}
+		}
+		return b != 0x0, nil
+	case amf0String:
+		return r.utf8()
+	case amf0StringExt:
+		n, err := r.uint32()
+		if err != nil {
+			return nil, err
+		}
+		return r.string(n)
+	case amf0Object:
+		return r.object("")
+	case amf0TypedObject:
+		class, err := r.utf8()
+		if err != nil {
+			return nil, err
+		}
+		return r.object(class)
+	case amf0Null:
+		return nil, nil
+	case amf0Undefined:
+		if r.d.KeepUndefined {
+			return Undefined{}, nil
+		}
+		return nil, nil
+	case amf0Reference:
+		ref, err := r.uint16()
+		if err != nil {
+			return nil, err
+		}
+		if ref >= len(r.d.objects0) {
+			return nil, fmt.Errorf("invalid reference %d", ref)
+		}
+		return r.d.objects0[ref], nil
+	case amf0Array:
+		// The count is only a hint; members run up to the end marker.
+		num, err := r.uint32()
+		if err != nil {
+			return nil, err
+		}
+		result, set := r.d.newECMAArray0(num)
+		n := 0
+		err = r.properties(func(key string, value interface{}) {
+			set(key, value)
+			n++
+		})
+		if err == nil {
+			err = r.d.ecmaCount(num, n)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return result, nil
+	case amf0StrictArr:
+		return r.strictArray()
+	case amf0Date:
+		b, err := r.read(8)
+		if err != nil {
+			return nil, err
+		}
+		t := r.d.date(math.Float64frombits(binary.BigEndian.Uint64(b)))
+		if tz, err := r.uint16(); err != nil {
+			return nil, err
+		} else if err := r.d.timezone(uint16(tz)); err != nil {
+			return nil, err
+		}
+		return t, nil
+	case amf0AVMPlus:
+		return r.avmPlus()
+	}
+	return nil, fmt.Errorf("unsupported type 0x%0X", marker)
+}
+
+// avmPlus reads an AMF3 value whole and decodes it with empty AMF3 tables, as
+// DecodeAMF0 does.
+func (r *amf0Reader) avmPlus() (interface{}, error) {
+	rd := &amf3Reader{r: r.r}
+	if err := rd.value(); err != nil {
+		return nil, err
+	}
+	r.d.strings3, r.d.objects3, r.d.traits3 = nil, nil, nil
+	value, _, err := r.d.decodeAMF3(rd.buf)
+	return value, err
+}
+
+func (r *amf0Reader) object(class string) (interface{}, error) {
+	result, set := r.d.newObject0(class)
+	if err := r.properties(set); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (r *amf0Reader) properties(set func(string, interface{})) error {
+	for {
+		key, err := r.utf8()
+		if err != nil {
+			return err
+		}
+		if key == "" {
+			if end, err := r.byte(); err != nil {
+				return err
+			} else if end != byte(amf0ObjectEnd) {
+				return fmt.Errorf("invalid end of object")
+			}
+			return nil
+		}
+		value, err := r.value()
+		if err != nil {
+			return err
+		}
+		set(key, value)
+	}
+}
+
+// strictArray grows as its items arrive rather than trusting the count, so a
+// bogus count costs nothing until the data follows. The reference table entry
+// is replaced as the array grows.
+func (r *amf0Reader) strictArray() ([]interface{}, error) {
+	num, err := r.uint32()
+	if err != nil {
+		return nil, err
+	}
+	result := newArray(0)
+	index := len(r.d.objects0)
+	r.d.objects0 = append(r.d.objects0, result)
+	for i := 0; i < num; i++ {
+		value, err := r.value()
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, value)
+		r.d.objects0[index] = result
+	}
+	return result, nil
+}
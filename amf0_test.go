@@ -1,7 +1,14 @@
 package amf
 
 import (
+	"bufio"
+	"bytes"
+	"io"
+	"reflect"
+	"runtime"
+	"slices"
 	"testing"
+	"testing/iotest"
 	"time"
 )
 
@@ -14,6 +21,7 @@ var encodeCases0 = []encodeTestCase{
 	{"foo", []byte{0x02, 0x00, 0x03, 0x66, 0x6f, 0x6f}},
 	{"", []byte{0x02, 0x00, 0x00}},
 	{nil, []byte{0x05}},
+	{Undefined{}, []byte{0x06}},
 	{map[string]interface{}{
 		"one":   1,
 		"two":   3.14,
@@ -55,18 +63,110 @@ var encodeCases0 = []encodeTestCase{
 		0x01, 0x01,
 		0x01, 0x00,
 		0x01, 0x01}},
+	{[]byte{0x01, 0x02}, []byte{0x11, 0x0c, 0x05, 0x01, 0x02}},
+	{TypedObject{"Foo", map[string]interface{}{"a": true}}, []byte{0x10,
+		0x00, 0x03, 0x46, 0x6f, 0x6f,
+		0x00, 0x01, 0x61, 0x01, 0x01,
+		0x00, 0x00, 0x09}},
+	{int8(-1), []byte{0x00, 0xbf, 0xf0, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}},
+	{uint8(200), []byte{0x00, 0x40, 0x69, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}},
+	{uintptr(1), []byte{0x00, 0x3f, 0xf0, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}},
+	{float32(0.5), []byte{0x00, 0x3f, 0xe0, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}},
+	{uint64(1 << 63), []byte{0x00, 0x43, 0xe0, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}},
+	{namedInt(-1), []byte{0x00, 0xbf, 0xf0, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}},
+	{namedFloat(0.5), []byte{0x00, 0x3f, 0xe0, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}},
 }
 
 func TestEncodeAMF0(t *testing.T) {
 	testEncode(t, encodeCases0, EncodeAMF0, "TestEncodeAMF0")
 }
 
+func TestEncodeAMF0ExactIntegers(t *testing.T) {
+	testExactIntegers(t, func(e *Encoder, v interface{}) ([]byte, error) {
+		return e.AppendAMF0(nil, v)
+	})
+}
+
+func TestAppendAMF0(t *testing.T) {
+	testEncode(t, encodeCases0, func(w io.Writer, v interface{}) (int, error) {
+		b, err := AppendAMF0([]byte{0xff}, v)
+		if err != nil {
+			return 0, err
+		}
+		if b[0] != 0xff {
+			t.Errorf("AppendAMF0(%#v) overwrote dst", v)
+		}
+		return w.Write(b[1:])
+	}, "TestAppendAMF0")
+	e := NewEncoder()
+	testAppendAllocs(t, benchValue, e.AppendAMF0, e.Reset, "AppendAMF0")
+}
+
+func TestAppendAMF0Rollback(t *testing.T) {
+	m := map[string]interface{}{}
+	testRollback(t, (*Encoder).AppendAMF0, []interface{}{m, m},
+		[]byte{0x0a, 0x00, 0x00, 0x00, 0x02, 0x03, 0x00, 0x00, 0x09, 0x07, 0x00, 0x01})
+}
+
+func TestDatesAMF0(t *testing.T) {
+	testDates(t, (*Encoder).AppendAMF0, (*Decoder).DecodeAMF0)
+	testDates(t, (*Encoder).AppendAMF0, func(d *Decoder, b []byte) (interface{}, int, error) {
+		v, err := d.ReadAMF0(bytes.NewReader(b))
+		return v, len(b), err
+	})
+}
+
+func TestEncodeAMF0References(t *testing.T) {
+	m := map[string]interface{}{"a": nil}
+	items := []interface{}{1.0}
+	testReferences(t, []referenceTestCase{
+		{cyclicNode(), []byte{0x03,
+			0x00, 0x04, 0x6e, 0x61, 0x6d, 0x65, 0x02, 0x00, 0x01, 0x61,
+			0x00, 0x04, 0x6e, 0x65, 0x78, 0x74, 0x07, 0x00, 0x00,
+			0x00, 0x00, 0x09}, nil},
+		{[]interface{}{m, m}, []byte{0x0a, 0x00, 0x00, 0x00, 0x02,
+			0x03, 0x00, 0x01, 0x61, 0x05, 0x00, 0x00, 0x09,
+			0x07, 0x00, 0x01,
+		}, []byte{0x0a, 0x00, 0x00, 0x00, 0x02,
+			0x03, 0x00, 0x01, 0x61, 0x05, 0x00, 0x00, 0x09,
+			0x03, 0x00, 0x01, 0x61, 0x05, 0x00, 0x00, 0x09}},
+		{[]interface{}{items, items}, []byte{0x0a, 0x00, 0x00, 0x00, 0x02,
+			0x0a, 0x00, 0x00, 0x00, 0x01, 0x00, 0x3f, 0xf0, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+			0x07, 0x00, 0x01,
+		}, []byte{0x0a, 0x00, 0x00, 0x00, 0x02,
+			0x0a, 0x00, 0x00, 0x00, 0x01, 0x00, 0x3f, 0xf0, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+			0x0a, 0x00, 0x00, 0x00, 0x01, 0x00, 0x3f, 0xf0, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}},
+	}, (*Encoder).AppendAMF0)
+}
+
+func TestSharedEmptyArrayAMF0(t *testing.T) {
+	// [[], <ref 1>]
+	data := []byte{0x0a, 0x00, 0x00, 0x00, 0x02, 0x0a, 0x00, 0x00, 0x00, 0x00, 0x07, 0x00, 0x01}
+	v, _, err := NewDecoder().DecodeAMF0(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, err := AppendAMF0(nil, v); err != nil || !bytes.Equal(b, data) {
+		t.Errorf("AppendAMF0 == % x, %v, want % x", b, err, data)
+	}
+}
+
+func BenchmarkEncodeAMF0(b *testing.B) {
+	benchmarkEncode(b, EncodeAMF0)
+}
+
+func BenchmarkAppendAMF0(b *testing.B) {
+	e := NewEncoder()
+	benchmarkAppend(b, e.AppendAMF0, e.Reset)
+}
+
 var decodeCases0 = []decodeTestCase{
 	{[]byte{0x00, 0x3f, 0xf0, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}, 9, 1.0},
 	{[]byte{0x00, 0x3f, 0xf0, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}, 9, float64(1)},
 	{[]byte{0x00, 0xbf, 0xf0, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}, 9, float64(-1)},
 	{[]byte{0x01, 0x01}, 2, true},
 	{[]byte{0x01, 0x00}, 2, false},
+	{[]byte{0x01, 0x02}, 2, true},
 	{[]byte{0x02, 0x00, 0x03, 0x66, 0x6f, 0x6f}, 6, "foo"},
 	{[]byte{0x02, 0x00, 0x00}, 3, ""},
 	{[]byte{0x05}, 1, nil},
@@ -110,12 +210,168 @@ var decodeCases0 = []decodeTestCase{
 		0x01, 0x01,
 		0x01, 0x00,
 		0x01, 0x01}, 11, []interface{}{true, false, true}},
+	{[]byte{0x10,
+		0x00, 0x03, 0x46, 0x6f, 0x6f,
+		0x00, 0x01, 0x61, 0x01, 0x01,
+		0x00, 0x00, 0x09}, 14, TypedObject{"Foo", map[string]interface{}{"a": true}}},
+	{[]byte{0x0a,
+		0x00, 0x00, 0x00, 0x02,
+		0x03, 0x00, 0x01, 0x61, 0x01, 0x01, 0x00, 0x00, 0x09,
+		0x07, 0x00, 0x01}, 17, []interface{}{
+		map[string]interface{}{"a": true},
+		map[string]interface{}{"a": true}}},
+	{[]byte{0x11, 0x04, 0x05}, 3, 5},
 }
 
 func TestDecodeAMF0(t *testing.T) {
-	testDecode(t, decodeCases0, decodeAMF0, "TestDecodeAMF0")
+	testDecode(t, decodeCases0, DecodeAMF0, "TestDecodeAMF0")
+	testDecode(t, []decodeTestCase{
+		{[]byte{0x06}, 1, Undefined{}},
+		{[]byte{0x05}, 1, nil},
+	}, (&Decoder{KeepUndefined: true}).DecodeAMF0, "TestDecodeAMF0KeepUndefined")
+}
+
+func TestDecodeAllAMF0(t *testing.T) {
+	testDecodeAll(t, []decodeAllTestCase{
+		{[]byte{}, nil},
+		{[]byte{0x02, 0x00, 0x07, 0x63, 0x6f, 0x6e, 0x6e, 0x65, 0x63, 0x74,
+			0x00, 0x3f, 0xf0, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+			0x05}, []interface{}{"connect", 1.0, nil}},
+		{[]byte{0x11, 0x06, 0x07, 0x66, 0x6f, 0x6f,
+			0x11, 0x06, 0x07, 0x66, 0x6f, 0x6f}, []interface{}{"foo", "foo"}},
+		{[]byte{0x11, 0x06, 0x07, 0x66, 0x6f, 0x6f,
+			0x11, 0x06, 0x00}, nil},
+		{[]byte{0x05, 0x02, 0x00}, nil},
+	}, DecodeAllAMF0, "TestDecodeAllAMF0")
+}
+
+func TestDecoderValuesAMF0(t *testing.T) {
+	d := &Decoder{Ordered: true}
+	if _, _, err := d.DecodeAMF0([]byte{0x03, 0x00, 0x00, 0x09}); err != nil {
+		t.Fatal(err)
+	}
+	var got []interface{}
+	// <ref 0>, <ref 1>: the values refer to the object decoded before them
+	// and to each other.
+	for value, err := range d.ValuesAMF0([]byte{0x07, 0x00, 0x00, 0x0a, 0x00, 0x00, 0x00, 0x00, 0x07, 0x00, 0x01}) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, value)
+	}
+	if want := []interface{}{&OrderedObject{}, []interface{}{}, []interface{}{}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Decoder.ValuesAMF0 == %#v, want %#v", got, want)
+	}
+}
+
+func TestDecodeAMF0ECMAArray(t *testing.T) {
+	decoders := map[string]func([]byte) (interface{}, error){
+		"DecodeAMF0": func(b []byte) (interface{}, error) {
+			v, _, err := (&Decoder{Ordered: true}).DecodeAMF0(b)
+			return v, err
+		},
+		"ReadAMF0": func(b []byte) (interface{}, error) {
+			return (&Decoder{Ordered: true}).ReadAMF0(bytes.NewReader(b))
+		},
+	}
+	for name, decode := range decoders {
+		// Count 5 for one member, which is written back.
+		in := []byte{0x08, 0x00, 0x00, 0x00, 0x05, 0x00, 0x01, 0x61, 0x05, 0x00, 0x00, 0x09}
+		v, err := decode(in)
+		if a, ok := v.(*OrderedECMAArray); err != nil || !ok || a.Count != 5 || a.Len() != 1 {
+			t.Errorf("%s == %#v, %v, want count 5 and one member", name, v, err)
+		}
+		if b, err := AppendAMF0(nil, v); err != nil || !bytes.Equal(b, in) {
+			t.Errorf("AppendAMF0(%s) == % x, %v, want % x", name, b, err, in)
+		}
+		for _, in := range [][]byte{
+			{0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x05},
+			{0x08, 0x00, 0x00, 0x00, 0x01, 0x00, 0x01, 0x61, 0x05, 0x00, 0x00},
+			{0x08, 0x00, 0x00, 0x00, 0x01, 0x00, 0x01, 0x61, 0x05},
+		} {
+			if v, err := decode(in); err == nil {
+				t.Errorf("%s(% x) == %#v, want error", name, in, v)
+			}
+		}
+	}
+}
+
+// readAMF0 adapts ReadAMF0 to testDecode, checking that the reader stops at
+// the end of the value.
+func readAMF0(wrap func(io.Reader) io.Reader) decodeFunc {
+	return func(v []byte) (interface{}, int, error) {
+		r := bytes.NewReader(append(v[:len(v):len(v)], 0xff))
+		value, err := NewDecoder().ReadAMF0(wrap(r))
+		return value, len(v) + 1 - r.Len(), err
+	}
+}
+
+func TestReadAMF0(t *testing.T) {
+	cases := slices.Clone(decodeCases0)
+	for _, c := range decodeCases3 {
+		cases = append(cases, decodeTestCase{append([]byte{amf0AVMPlus}, c.in...), 1 + c.blen, c.want})
+	}
+	testDecode(t, cases, readAMF0(func(r io.Reader) io.Reader { return r }), "TestReadAMF0")
+	testDecode(t, cases, readAMF0(iotest.OneByteReader), "TestReadAMF0OneByte")
+}
+
+func TestReadAMF0Values(t *testing.T) {
+	// An ECMA array with a zero count, as written by Flash, and a reference
+	// to it in the next value.
+	data := []byte{0x08, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x01, 0x61, 0x01, 0x01,
+		0x00, 0x00, 0x09,
+		0x07, 0x00, 0x00}
+	want := ECMAArray{"a": true}
+	d := NewDecoder()
+	r := bufio.NewReader(bytes.NewReader(data))
+	for i := 0; i < 2; i++ {
+		got, err := d.ReadAMF0(r)
+		if err != nil || !reflect.DeepEqual(got, want) {
+			t.Errorf("value %d == %#v, %v, want %#v", i, got, err, want)
+		}
+	}
+	if _, err := d.ReadAMF0(r); err != io.EOF {
+		t.Errorf("ReadAMF0 at the end: %v, want io.EOF", err)
+	}
+}
+
+func TestReadAMF0Allocs(t *testing.T) {
+	// Nested strict arrays claiming 4G items each, with no items.
+	in := bytes.Repeat([]byte{0x0a, 0xff, 0xff, 0xff, 0xff}, 200)
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	if _, err := ReadAMF0(bytes.NewReader(in)); err != io.ErrUnexpectedEOF {
+		t.Errorf("ReadAMF0 == %v, want io.ErrUnexpectedEOF", err)
+	}
+	runtime.ReadMemStats(&after)
+	if n := after.TotalAlloc - before.TotalAlloc; n > 64<<10 {
+		t.Errorf("ReadAMF0 of %d bytes allocated %d bytes", len(in), n)
+	}
 }
 
-// func TestExternAMF0(t *testing.T) {
-// 	testExtern(t, decodeCases0, "TestExternAMF0", 0)
-// }
+func TestReadAMF0Invalid(t *testing.T) {
+	for _, c := range []struct {
+		in   []byte
+		want error // nil for any other error
+	}{
+		{[]byte{}, io.EOF},
+		{[]byte{0x00, 0x3f, 0xf0}, io.ErrUnexpectedEOF},
+		{[]byte{0x02, 0x00, 0x03, 0x66}, io.ErrUnexpectedEOF},
+		// A long string claiming 4 GiB.
+		{[]byte{0x0c, 0xff, 0xff, 0xff, 0xff, 0x66, 0x6f, 0x6f}, io.ErrUnexpectedEOF},
+		{[]byte{0x0a, 0xff, 0xff, 0xff, 0xff, 0x05}, io.ErrUnexpectedEOF},
+		{[]byte{0x03, 0x00, 0x00, 0x05}, nil},
+		{[]byte{0x07, 0x00, 0x00}, nil},
+		{[]byte{0x11, 0x06, 0x07, 0x66}, io.ErrUnexpectedEOF},
+		{[]byte{0x11, 0x09, 0x05, 0x01, 0x04}, io.ErrUnexpectedEOF},
+		{[]byte{0x11, 0x0a, 0x01}, nil},
+		{[]byte{0x11, 0x0a, 0x07, 0x01}, nil},
+		{[]byte{0x0d}, nil},
+	} {
+		got, err := ReadAMF0(bytes.NewReader(c.in))
+		if err == nil || c.want != nil && err != c.want {
+			t.Errorf("ReadAMF0(%#v) == %#v, %v, want error %v", c.in, got, err, c.want)
+		}
+	}
+}
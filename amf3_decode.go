@@ -3,18 +3,87 @@ package amf
 import (
 	"encoding/binary"
 	"fmt"
+	"iter"
 	"math"
 	"time"
 )
 
+type traits3 struct {
+	class          string
+	sealed         []string
+	dynamic        bool
+	externalizable bool
+}
+
 func DecodeAMF3(v []byte) (interface{}, error) {
-	result, _, err := decodeAMF3(v)
+	result, _, err := NewDecoder().DecodeAMF3(v)
 	return result, err
 }
 
-func decodeAMF3(v []byte) (interface{}, int, error) {
+func (d *Decoder) DecodeAMF3(v []byte) (interface{}, int, error) {
+	value, n, err := d.decodeAMF3(v)
+	if err == nil {
+		err = d.trailing(v, n)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	return value, n, nil
+}
+
+// DecodeAllAMF3 decodes consecutive AMF3 values sharing the same reference
+// tables, as in the body of an AMF3 shared object.
+func DecodeAllAMF3(v []byte) ([]interface{}, error) {
+	var result []interface{}
+	for value, err := range ValuesAMF3(v) {
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, value)
+	}
+	return result, nil
+}
+
+// ValuesAMF3 is the iterator form of DecodeAllAMF3.
+func ValuesAMF3(v []byte) iter.Seq2[interface{}, error] {
+	return func(yield func(interface{}, error) bool) {
+		NewDecoder().ValuesAMF3(v)(yield)
+	}
+}
+
+// ValuesAMF3 decodes consecutive AMF3 values with the reference tables of d,
+// which they share with the values d decodes before and after them.
+func (d *Decoder) ValuesAMF3(v []byte) iter.Seq2[interface{}, error] {
+	return func(yield func(interface{}, error) bool) {
+		for offset := 0; offset < len(v); {
+			value, n, err := d.decodeAMF3(v[offset:])
+			if err != nil {
+				yield(nil, fmt.Errorf("offset %d: %s", offset, err))
+				return
+			}
+			offset += n
+			if !yield(value, nil) {
+				return
+			}
+		}
+	}
+}
+
+// DecodeString3 decodes a UTF-8-vr string outside of a value, sharing the
+// string reference table with the values.
+func (d *Decoder) DecodeString3(v []byte) (string, int, error) {
+	return d.decodeUTF8VR(v)
+}
+
+func (d *Decoder) decodeAMF3(v []byte) (interface{}, int, error) {
+	if len(v) == 0 {
+		return nil, 0, fmt.Errorf("EOF")
+	}
 	switch v[0] {
 	case amf3Undefined:
+		if d.KeepUndefined {
+			return Undefined{}, 1, nil
+		}
 		return nil, 1, nil
 	case amf3Null:
 		return nil, 1, nil
@@ -27,11 +96,17 @@ func decodeAMF3(v []byte) (interface{}, int, error) {
 	case amf3Double:
 		return decodeDouble3(v)
 	case amf3String:
-		return decodeString3(v)
+		return d.decodeString3(v)
 	case amf3Date:
-		return decodeDate3(v)
+		return d.decodeDate3(v)
 	case amf3Array:
-		return decodeArray3(v)
+		return d.decodeArray3(v)
+	case amf3Object:
+		return d.decodeObject3(v)
+	case amf3ByteArray:
+		return d.decodeByteArray3(v)
+	case amf3VectorInt, amf3VectorUint, amf3VectorDouble, amf3VectorObject:
+		return d.decodeVector3(v)
 	}
 	return nil, 0, fmt.Errorf("unsupported type 0x%0X", v[0])
 }
@@ -71,58 +146,110 @@ func decodeInteger3(v []byte) (int, int, error) {
 }
 
 func decodeDouble3(v []byte) (float64, int, error) {
+	if len(v) < 9 {
+		return 0, 0, fmt.Errorf("EOF")
+	}
 	return math.Float64frombits(binary.BigEndian.Uint64(v[1:9])), 9, nil
 }
 
-func decodeUTF8VR(v []byte) (string, int, error) {
-	strlen, l, err := decodeU29(v)
+func (d *Decoder) decodeUTF8VR(v []byte) (string, int, error) {
+	ref, l, err := decodeU29(v)
 	if err != nil {
 		return "", 0, err
 	}
-	if strlen&1 == 0 {
-		return "", 0, fmt.Errorf("unsupported string ref")
+	if ref&1 == 0 {
+		ref >>= 1
+		if ref >= len(d.strings3) {
+			return "", 0, fmt.Errorf("invalid string ref %d", ref)
+		}
+		return d.strings3[ref], l, nil
+	}
+	strlen := ref >> 1
+	if l+strlen > len(v) {
+		return "", 0, fmt.Errorf("EOF")
+	}
+	s := string(v[l : l+strlen])
+	if s != "" {
+		d.strings3 = append(d.strings3, s)
 	}
-	strlen >>= 1
-	return string(v[l : l+strlen]), l + strlen, nil
+	return s, l + strlen, nil
 }
 
-func decodeString3(v []byte) (string, int, error) {
-	str, nstr, err := decodeUTF8VR(v[1:])
+func (d *Decoder) decodeString3(v []byte) (string, int, error) {
+	str, nstr, err := d.decodeUTF8VR(v[1:])
 	if err != nil {
 		return "", 0, err
 	}
 	return str, 1 + nstr, nil
 }
 
-func decodeDate3(v []byte) (time.Time, int, error) {
-	if v[1] == 0 {
-		return time.Time{}, 10, fmt.Errorf("unsupported date ref")
+func (d *Decoder) objectRef3(ref int) (interface{}, error) {
+	ref >>= 1
+	if ref >= len(d.objects3) {
+		return nil, fmt.Errorf("invalid object ref %d", ref)
 	}
-	t := int64(math.Float64frombits(binary.BigEndian.Uint64(v[2:10])) * 1000000)
-	return time.Unix(0, t), 10, nil
+	return d.resolve(d.objects3, ref)
 }
 
-func decodeArray3(v []byte) (interface{}, int, error) {
+func (d *Decoder) decodeDate3(v []byte) (time.Time, int, error) {
+	ref, l, err := decodeU29(v[1:])
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+	if ref&1 == 0 {
+		obj, err := d.objectRef3(ref)
+		if err != nil {
+			return time.Time{}, 0, err
+		}
+		t, ok := obj.(time.Time)
+		if !ok {
+			return time.Time{}, 0, fmt.Errorf("invalid date ref")
+		}
+		return t, 1 + l, nil
+	}
+	offset := 1 + l
+	if offset+8 > len(v) {
+		return time.Time{}, 0, fmt.Errorf("EOF")
+	}
+	result := d.date(math.Float64frombits(binary.BigEndian.Uint64(v[offset : offset+8])))
+	d.objects3 = append(d.objects3, result)
+	return result, offset + 8, nil
+}
+
+func (d *Decoder) decodeArray3(v []byte) (interface{}, int, error) {
 	offset := 1
 	num, nnum, err := decodeU29(v[offset:])
 	if err != nil {
 		return nil, 0, err
 	}
+	offset += nnum
 	if num&1 == 0 {
-		return nil, 0, fmt.Errorf("invalid array ref")
+		obj, err := d.objectRef3(num)
+		return obj, offset, err
 	}
-	offset += nnum
-	if num == 1 {
-		return decodeAssociativeArray3(v, offset)
+	// An array with neither dense nor associative members, as an empty
+	// Array is written, is an empty strict array, which the encoder writes
+	// the same way.
+	if num == 1 && (offset >= len(v) || v[offset] != 0x01) {
+		return d.decodeAssociativeArray3(v, offset)
 	} else {
-		return decodeStrictArray3(v, offset, num>>1)
+		return d.decodeStrictArray3(v, offset, num>>1)
 	}
 }
 
-func decodeAssociativeArray3(v []byte, offset int) (ECMAArray, int, error) {
-	result := make(ECMAArray)
+func (d *Decoder) decodeAssociativeArray3(v []byte, offset int) (interface{}, int, error) {
+	var result interface{}
+	var set func(string, interface{})
+	if d.Ordered {
+		ordered := &OrderedECMAArray{}
+		result, set = ordered, ordered.Set
+	} else {
+		m := make(ECMAArray)
+		result, set = m, func(key string, value interface{}) { m[key] = value }
+	}
+	d.objects3 = append(d.objects3, result)
 	for {
-		key, nkey, err := decodeUTF8VR(v[offset:])
+		key, nkey, err := d.decodeUTF8VR(v[offset:])
 		if err != nil {
 			return nil, 0, err
 		}
@@ -130,29 +257,226 @@ func decodeAssociativeArray3(v []byte, offset int) (ECMAArray, int, error) {
 		if key == "" {
 			break
 		}
-		value, nvalue, err := decodeAMF3(v[offset:])
+		value, nvalue, err := d.decodeAMF3(v[offset:])
 		if err != nil {
 			return nil, 0, err
 		}
 		offset += nvalue
-		result[key] = value
+		set(key, value)
 	}
 	return result, offset, nil
 }
 
-func decodeStrictArray3(v []byte, offset, num int) ([]interface{}, int, error) {
-	if v[offset] != 0x01 {
+func (d *Decoder) decodeStrictArray3(v []byte, offset, num int) ([]interface{}, int, error) {
+	if offset >= len(v) || v[offset] != 0x01 {
 		return nil, 0, fmt.Errorf("invalid strict array")
 	}
-	offset++
-	result := make([]interface{}, 0, num)
+	return d.decodeItems3(v, offset+1, num)
+}
+
+// decodeItems3 decodes the num values at offset of a strict array or vector.
+func (d *Decoder) decodeItems3(v []byte, offset, num int) ([]interface{}, int, error) {
+	if num > len(v)-offset {
+		return nil, 0, fmt.Errorf("EOF")
+	}
+	result := newArray(num)
+	d.objects3 = append(d.objects3, result)
 	for i := 0; i < num; i++ {
-		value, nvalue, err := decodeAMF3(v[offset:])
+		value, nvalue, err := d.decodeAMF3(v[offset:])
 		if err != nil {
 			return nil, 0, err
 		}
 		offset += nvalue
-		result = append(result, value)
+		result[i] = value
 	}
 	return result, offset, nil
 }
+
+func (d *Decoder) decodeTraits3(v []byte, ref int) (traits3, int, error) {
+	if ref&2 == 0 {
+		ref >>= 2
+		if ref >= len(d.traits3) {
+			return traits3{}, 0, fmt.Errorf("invalid traits ref %d", ref)
+		}
+		return d.traits3[ref], 0, nil
+	}
+	class, offset, err := d.decodeUTF8VR(v)
+	if err != nil {
+		return traits3{}, 0, err
+	}
+	t := traits3{
+		class:          class,
+		dynamic:        ref&8 != 0,
+		externalizable: ref&4 != 0,
+	}
+	if !t.externalizable {
+		for i := 0; i < ref>>4; i++ {
+			name, nname, err := d.decodeUTF8VR(v[offset:])
+			if err != nil {
+				return traits3{}, 0, err
+			}
+			offset += nname
+			t.sealed = append(t.sealed, name)
+		}
+	}
+	d.traits3 = append(d.traits3, t)
+	return t, offset, nil
+}
+
+func (d *Decoder) decodeObject3(v []byte) (interface{}, int, error) {
+	offset := 1
+	ref, nref, err := decodeU29(v[offset:])
+	if err != nil {
+		return nil, 0, err
+	}
+	offset += nref
+	if ref&1 == 0 {
+		obj, err := d.objectRef3(ref)
+		return obj, offset, err
+	}
+	t, ntraits, err := d.decodeTraits3(v[offset:], ref)
+	if err != nil {
+		return nil, 0, err
+	}
+	offset += ntraits
+	if t.externalizable {
+		if t.class != arrayCollectionClass {
+			return nil, 0, fmt.Errorf("unsupported externalizable class %s", t.class)
+		}
+		return d.decodeArrayCollection3(v, offset)
+	}
+	var result interface{}
+	var set func(string, interface{})
+	if d.Ordered {
+		ordered := &OrderedObject{Class: t.class, Sealed: len(t.sealed), Dynamic: t.dynamic}
+		result, set = ordered, ordered.Set
+	} else {
+		members := make(map[string]interface{})
+		result, set = members, func(key string, value interface{}) { members[key] = value }
+		if t.class != "" {
+			result = TypedObject{Class: t.class, Members: members}
+		}
+	}
+	d.objects3 = append(d.objects3, result)
+	for _, name := range t.sealed {
+		value, nvalue, err := d.decodeAMF3(v[offset:])
+		if err != nil {
+			return nil, 0, err
+		}
+		offset += nvalue
+		set(name, value)
+	}
+	if t.dynamic {
+		for {
+			key, nkey, err := d.decodeUTF8VR(v[offset:])
+			if err != nil {
+				if offset == len(v) && d.lenient("missing end of object") {
+					break
+				}
+				return nil, 0, err
+			}
+			offset += nkey
+			if key == "" {
+				break
+			}
+			value, nvalue, err := d.decodeAMF3(v[offset:])
+			if err != nil {
+				return nil, 0, err
+			}
+			offset += nvalue
+			set(key, value)
+		}
+	}
+	return result, offset, nil
+}
+
+// decodeArrayCollection3 decodes an ArrayCollection as the array it wraps,
+// which references to the collection also stand for.
+func (d *Decoder) decodeArrayCollection3(v []byte, offset int) (interface{}, int, error) {
+	index := len(d.objects3)
+	d.objects3 = append(d.objects3, nil)
+	value, n, err := d.decodeAMF3(v[offset:])
+	if err != nil {
+		return nil, 0, err
+	}
+	d.objects3[index] = value
+	return value, offset + n, nil
+}
+
+// decodeVector3 decodes a vector as an array. Vector.<int> and Vector.<uint>
+// items are ints, and Vector.<Number> items float64s.
+func (d *Decoder) decodeVector3(v []byte) (interface{}, int, error) {
+	marker := v[0]
+	offset := 1
+	ref, nref, err := decodeU29(v[offset:])
+	if err != nil {
+		return nil, 0, err
+	}
+	offset += nref
+	if ref&1 == 0 {
+		obj, err := d.objectRef3(ref)
+		return obj, offset, err
+	}
+	num := ref >> 1
+	// The fixed-length flag is dropped.
+	if offset >= len(v) {
+		return nil, 0, fmt.Errorf("EOF")
+	}
+	offset++
+	if marker == amf3VectorObject {
+		_, nclass, err := d.decodeUTF8VR(v[offset:])
+		if err != nil {
+			return nil, 0, err
+		}
+		return d.decodeItems3(v, offset+nclass, num)
+	}
+	size := 4
+	if marker == amf3VectorDouble {
+		size = 8
+	}
+	if num > (len(v)-offset)/size {
+		return nil, 0, fmt.Errorf("EOF")
+	}
+	result := newArray(num)
+	d.objects3 = append(d.objects3, result)
+	for i := range result {
+		item := v[offset+i*size:]
+		switch marker {
+		case amf3VectorInt:
+			result[i] = int(int32(binary.BigEndian.Uint32(item)))
+		case amf3VectorUint:
+			result[i] = int(binary.BigEndian.Uint32(item))
+		default:
+			result[i] = math.Float64frombits(binary.BigEndian.Uint64(item))
+		}
+	}
+	return result, offset + num*size, nil
+}
+
+func (d *Decoder) decodeByteArray3(v []byte) ([]byte, int, error) {
+	offset := 1
+	ref, nref, err := decodeU29(v[offset:])
+	if err != nil {
+		return nil, 0, err
+	}
+	offset += nref
+	if ref&1 == 0 {
+		obj, err := d.objectRef3(ref)
+		if err != nil {
+			return nil, 0, err
+		}
+		b, ok := obj.([]byte)
+		if !ok {
+			return nil, 0, fmt.Errorf("invalid byte array ref")
+		}
+		return b, offset, nil
+	}
+	blen := ref >> 1
+	if offset+blen > len(v) {
+		return nil, 0, fmt.Errorf("EOF")
+	}
+	result := make([]byte, blen, max(blen, 1))
+	copy(result, v[offset:])
+	d.objects3 = append(d.objects3, result)
+	return result, offset + blen, nil
+}
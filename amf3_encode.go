@@ -5,7 +5,8 @@ import (
 	"fmt"
 	"io"
 	"math"
-	"sort"
+	"reflect"
+	"strings"
 	"time"
 )
 
@@ -15,157 +16,385 @@ const (
 )
 
 func EncodeAMF3(w io.Writer, v interface{}) (int, error) {
-	return encodeAMF3(0, w, v)
+	return NewEncoder().EncodeAMF3(w, v)
 }
 
-func encodeAMF3(n int, w io.Writer, v interface{}) (int, error) {
+func (e *Encoder) EncodeAMF3(w io.Writer, v interface{}) (int, error) {
+	sizes := e.sizes()
+	b, err := e.encodeAMF3(e.buf[:0], v)
+	e.buf = b[:0]
+	if err != nil {
+		e.rollback(sizes)
+		return 0, err
+	}
+	return w.Write(b)
+}
+
+// AppendAMF3 appends the AMF3 encoding of v to dst. On error dst is returned
+// unchanged, and the reference tables of the Encoder are as before the call.
+func AppendAMF3(dst []byte, v interface{}) ([]byte, error) {
+	var e Encoder
+	return e.AppendAMF3(dst, v)
+}
+
+func (e *Encoder) AppendAMF3(dst []byte, v interface{}) ([]byte, error) {
+	sizes := e.sizes()
+	b, err := e.encodeAMF3(dst, v)
+	if err != nil {
+		e.rollback(sizes)
+		return dst, err
+	}
+	return b, nil
+}
+
+// EncodeString3 encodes a UTF-8-vr string outside of a value, sharing the
+// string reference table with the values.
+func (e *Encoder) EncodeString3(w io.Writer, v string) (int, error) {
+	b := e.encodeUTF8VR(e.buf[:0], v)
+	e.buf = b[:0]
+	return w.Write(b)
+}
+
+func (e *Encoder) encodeAMF3(b []byte, v interface{}) ([]byte, error) {
+	if key, ok := objectKeyOf(v); ok {
+		return e.encodeShared(b, AMF3, key, v, e.encodeValue3)
+	}
+	if t, ok := v.(time.Time); ok {
+		key := objectKey{typ: reflect.TypeFor[time.Time](), date: math.Float64bits(e.timeToMs(t))}
+		return e.encodeShared(b, AMF3, key, v, e.encodeValue3)
+	}
+	return e.encodeValue3(b, v)
+}
+
+func (e *Encoder) encodeValue3(b []byte, v interface{}) ([]byte, error) {
 	switch v.(type) {
 	case float64:
-		return encodeDouble3(n, w, v.(float64))
+		return encodeDouble3(b, v.(float64)), nil
 	case int:
-		return encodeInteger3(n, w, v.(int))
-	case uint:
-		return encodeInteger3(n, w, v.(int))
+		return e.encodeNumber3(b, number{kind: reflect.Int64, i: int64(v.(int))})
 	case bool:
-		return encodeBoolean3(n, w, v.(bool))
+		return encodeBoolean3(b, v.(bool)), nil
 	case string:
-		return encodeString3(n, w, v.(string))
+		return e.encodeString3(b, v.(string)), nil
 	case nil:
-		return encodeNull3(n, w)
-	// case map[string]interface{}:
-	// return encodeObject3(n, w, v.(map[string]interface{}))
+		return encodeNull3(b), nil
+	case Undefined:
+		return append(b, amf3Undefined), nil
+	case map[string]interface{}:
+		return e.encodeObject3(b, v.(map[string]interface{}))
+	case TypedObject:
+		return e.encodeTypedObject3(b, v.(TypedObject))
 	case time.Time:
-		return encodeDate3(n, w, v.(time.Time))
+		e.addObject(AMF3, amf3Date)
+		return encodeDate3(b, e.timeToMs(v.(time.Time))), nil
 	case ECMAArray:
-		return encodeAssociativeArray3(n, w, v.(ECMAArray))
+		return e.encodeAssociativeArray3(b, v.(ECMAArray))
+	case *OrderedObject:
+		return e.encodeOrderedObject3(b, v.(*OrderedObject))
+	case *OrderedECMAArray:
+		return e.encodeOrderedAssociativeArray3(b, v.(*OrderedECMAArray))
 	case []interface{}:
-		return encodeStrictArray3(n, w, v.([]interface{}))
+		return e.encodeStrictArray3(b, v.([]interface{}))
+	case []byte:
+		e.addObject(AMF3, amf3ByteArray)
+		return encodeByteArray3(b, v.([]byte)), nil
+	case RawAMF0:
+		return e.encodeRaw3(b, v.(RawAMF0).RawValue)
+	case RawAMF3:
+		return e.encodeRaw3(b, v.(RawAMF3).RawValue)
 	}
-	return n, fmt.Errorf("type %T not supported", v)
+	return e.encodeOther(b, AMF3, v, e.encodeAMF3)
 }
 
-func encodeU29(n int, w io.Writer, v int) (int, error) {
+func (e *Encoder) encodeNumber3(b []byte, n number) ([]byte, error) {
+	switch {
+	case n.kind == reflect.Int64 && n.i >= amf3MinInt && n.i <= amf3MaxInt:
+		return encodeInteger3(b, int(n.i)), nil
+	case n.kind == reflect.Uint64 && n.u <= amf3MaxInt:
+		return encodeInteger3(b, int(n.u)), nil
+	}
+	f, err := e.float(n)
+	if err != nil {
+		return b, err
+	}
+	return encodeDouble3(b, f), nil
+}
+
+func encodeU29(b []byte, v int) []byte {
 	v &= 0x1fffffff
 	if v <= 0x7f {
-		return writeBytes(n, w, []byte{byte(v)})
+		return append(b, byte(v))
 	} else if v <= 0x3fff {
-		return writeBytes(n, w, []byte{byte((v >> 7) | 0x80), byte(v & 0x7f)})
+		return append(b, byte((v>>7)|0x80), byte(v&0x7f))
 	} else if v <= 0x1fffff {
-		return writeBytes(n, w, []byte{byte((v >> 14) | 0x80), byte((v >> 7) | 0x80), byte(v & 0x7f)})
+		return append(b, byte((v>>14)|0x80), byte((v>>7)|0x80), byte(v&0x7f))
 	} else {
-		return writeBytes(n, w, []byte{byte((v >> 22) | 0x80), byte((v >> 14) | 0x80), byte((v >> 7) | 0x80), byte(v)})
+		// The last byte holds 8 bits.
+		return append(b, byte((v>>22)|0x80), byte((v>>15)|0x80), byte((v>>8)|0x80), byte(v))
 	}
 }
 
-func encodeInteger3(n int, w io.Writer, v int) (int, error) {
+func encodeInteger3(b []byte, v int) []byte {
 	if v >= amf3MinInt && v <= amf3MaxInt {
-		n, err := writeBytes(n, w, []byte{amf3Integer})
-		if err != nil {
-			return n, err
-		}
-		return encodeU29(n, w, v)
+		b = append(b, amf3Integer)
+		return encodeU29(b, v)
 	} else {
-		return encodeDouble3(n, w, float64(v))
+		return encodeDouble3(b, float64(v))
 	}
 }
 
-func encodeDouble3(n int, w io.Writer, v float64) (int, error) {
-	n, err := writeBytes(n, w, []byte{amf3Double})
-	if err != nil {
-		return n, err
-	}
-	return writeData(n, w, binary.BigEndian, uint64(math.Float64bits(v)))
+func encodeDouble3(b []byte, v float64) []byte {
+	b = append(b, amf3Double)
+	return binary.BigEndian.AppendUint64(b, math.Float64bits(v))
 }
 
-func encodeBoolean3(n int, w io.Writer, v bool) (int, error) {
+func encodeBoolean3(b []byte, v bool) []byte {
 	if v {
-		return writeBytes(n, w, []byte{amf3True})
+		return append(b, amf3True)
 	} else {
-		return writeBytes(n, w, []byte{amf3False})
+		return append(b, amf3False)
 	}
 }
 
-func encodeNull3(n int, w io.Writer) (int, error) {
-	return writeBytes(n, w, []byte{amf3Null})
+func encodeNull3(b []byte) []byte {
+	return append(b, amf3Null)
 }
 
-func encodeUTF8VR(n int, w io.Writer, v string) (int, error) {
+func (e *Encoder) encodeUTF8VR(b []byte, v string) []byte {
+	if v != "" {
+		if ref, ok := e.strings3[v]; ok {
+			return encodeU29(b, ref<<1)
+		}
+		e.addString3(v)
+	}
 	var strlen = len(v)
 	if strlen > amf3MaxInt {
 		strlen = amf3MaxInt
 	}
-	n, err := encodeU29(n, w, (strlen<<1)|1)
-	if err != nil {
-		return n, err
+	b = encodeU29(b, (strlen<<1)|1)
+	return append(b, v...)
+}
+
+// addString3 adds a string written inline to the table. The first index of a
+// repeated string is kept.
+func (e *Encoder) addString3(v string) {
+	if e.strings3 == nil {
+		e.strings3 = make(map[string]int)
+	}
+	if _, ok := e.strings3[v]; !ok {
+		e.strings3[v] = e.nstrings3
 	}
-	return writeBytes(n, w, []byte(v))
+	e.nstrings3++
 }
 
-func encodeString3(n int, w io.Writer, v string) (int, error) {
-	n, err := writeBytes(n, w, []byte{amf3String})
-	if err != nil {
-		return n, err
+func (e *Encoder) encodeString3(b []byte, v string) []byte {
+	b = append(b, amf3String)
+	return e.encodeUTF8VR(b, v)
+}
+
+func encodeDate3(b []byte, ms float64) []byte {
+	b = append(b, amf3Date)
+	b = encodeU29(b, 1)
+	return binary.BigEndian.AppendUint64(b, math.Float64bits(ms))
+}
+
+func (e *Encoder) encodeAssociativeArray3(b []byte, v ECMAArray) ([]byte, error) {
+	b = append(b, amf3Array)
+	e.addObject(AMF3, amf3Array)
+	b = encodeU29(b, 1)
+	keys, mark := e.sortedKeys(v)
+	defer e.releaseKeys(mark)
+	return e.encodeDynamicMembers3(b, keys, v)
+}
+
+func (e *Encoder) encodeOrderedAssociativeArray3(b []byte, v *OrderedECMAArray) ([]byte, error) {
+	b = append(b, amf3Array)
+	e.addObject(AMF3, amf3Array)
+	b = encodeU29(b, 1)
+	return e.encodeDynamicMembers3(b, v.keys, v.values)
+}
+
+func (e *Encoder) encodeDynamicMembers3(b []byte, keys []string, v map[string]interface{}) ([]byte, error) {
+	var err error
+	for _, key := range keys {
+		b = e.encodeUTF8VR(b, key)
+		b, err = e.encodeAMF3(b, v[key])
+		if err != nil {
+			return b, err
+		}
 	}
-	return encodeUTF8VR(n, w, v)
+	return append(b, 0x01), nil
 }
 
-func encodeDate3(n int, w io.Writer, v time.Time) (int, error) {
-	n, err := writeBytes(n, w, []byte{amf3Date})
-	if err != nil {
-		return n, err
+func (e *Encoder) encodeStrictArray3(b []byte, v []interface{}) ([]byte, error) {
+	b = append(b, amf3Array)
+	e.addObject(AMF3, amf3Array)
+	b = encodeU29(b, (len(v)<<1)|1)
+	b = append(b, 0x01)
+	var err error
+	for _, item := range v {
+		b, err = e.encodeAMF3(b, item)
+		if err != nil {
+			return b, err
+		}
 	}
-	n, err = encodeU29(n, w, 1)
-	if err != nil {
-		return n, err
+	return b, nil
+}
+
+func (e *Encoder) encodeObject3(b []byte, v map[string]interface{}) ([]byte, error) {
+	b = append(b, amf3Object)
+	e.addObject(AMF3, amf3Object)
+	b = e.encodeTraits3(b, "", nil, true)
+	keys, mark := e.sortedKeys(v)
+	defer e.releaseKeys(mark)
+	return e.encodeDynamicMembers3(b, keys, v)
+}
+
+func (e *Encoder) encodeTypedObject3(b []byte, v TypedObject) ([]byte, error) {
+	b = append(b, amf3Object)
+	e.addObject(AMF3, amf3Object)
+	keys, mark := e.sortedKeys(v.Members)
+	defer e.releaseKeys(mark)
+	b = e.encodeTraits3(b, v.Class, keys, false)
+	return e.encodeSealedMembers3(b, keys, v.Members)
+}
+
+func (e *Encoder) encodeSealedMembers3(b []byte, keys []string, v map[string]interface{}) ([]byte, error) {
+	var err error
+	for _, key := range keys {
+		b, err = e.encodeAMF3(b, v[key])
+		if err != nil {
+			return b, err
+		}
 	}
-	return writeData(n, w, binary.BigEndian, float64(v.UnixNano()/1000000))
+	return b, nil
 }
 
-func encodeAssociativeArray3(n int, w io.Writer, v ECMAArray) (int, error) {
-	n, err := writeBytes(n, w, []byte{amf3Array})
-	if err != nil {
-		return n, err
+func (e *Encoder) encodeOrderedObject3(b []byte, v *OrderedObject) ([]byte, error) {
+	b = append(b, amf3Object)
+	e.addObject(AMF3, amf3Object)
+	sealed := v.sealed()
+	dynamic := v.dynamic()
+	b = e.encodeTraits3(b, v.Class, v.keys[:sealed], dynamic)
+	b, err := e.encodeSealedMembers3(b, v.keys[:sealed], v.values)
+	if err != nil || !dynamic {
+		return b, err
 	}
-	n, err = encodeU29(n, w, 1)
-	if err != nil {
-		return n, err
+	return e.encodeDynamicMembers3(b, v.keys[sealed:], v.values)
+}
+
+func traitsID3(class string, sealed []string, dynamic bool) string {
+	if class == "" && len(sealed) == 0 && dynamic {
+		return "\x00\x00*"
 	}
-	var keys []string
-	for k := range v {
-		keys = append(keys, k)
+	id := class + "\x00" + strings.Join(sealed, "\x00")
+	if dynamic {
+		id += "\x00*"
 	}
-	sort.Strings(keys)
-	for _, key := range keys {
-		n, err = encodeUTF8VR(n, w, key)
-		if err != nil {
-			return n, err
-		}
-		n, err = encodeAMF3(n, w, v[key])
-		if err != nil {
-			return n, err
-		}
+	return id
+}
+
+func (e *Encoder) encodeTraits3(b []byte, class string, sealed []string, dynamic bool) []byte {
+	id := traitsID3(class, sealed, dynamic)
+	if ref, ok := e.traits3[id]; ok {
+		return encodeU29(b, ref<<2|0x01)
 	}
-	return writeBytes(n, w, []byte{0x01})
+	e.addTraits3(id)
+	header := len(sealed)<<4 | 0x03
+	if dynamic {
+		header |= 0x08
+	}
+	b = encodeU29(b, header)
+	b = e.encodeUTF8VR(b, class)
+	for _, name := range sealed {
+		b = e.encodeUTF8VR(b, name)
+	}
+	return b
 }
 
-func encodeStrictArray3(n int, w io.Writer, v []interface{}) (int, error) {
-	n, err := writeBytes(n, w, []byte{amf3Array})
-	if err != nil {
-		return n, err
+// addTraits3 is the traits counterpart of addString3.
+func (e *Encoder) addTraits3(id string) {
+	if e.traits3 == nil {
+		e.traits3 = make(map[string]int)
 	}
-	n, err = encodeU29(n, w, (len(v)<<1)|1)
-	if err != nil {
-		return n, err
+	if _, ok := e.traits3[id]; !ok {
+		e.traits3[id] = e.ntraits3
 	}
-	n, err = writeBytes(n, w, []byte{0x01})
-	if err != nil {
-		return n, err
+	e.ntraits3++
+}
+
+// encodeExternalizableTraits3 writes the traits of an externalizable class,
+// whose instances write their content themselves.
+func (e *Encoder) encodeExternalizableTraits3(b []byte, class string) []byte {
+	id := "\x00\x00!" + class
+	if ref, ok := e.traits3[id]; ok {
+		return encodeU29(b, ref<<2|0x01)
 	}
-	for _, item := range v {
-		n, err = encodeAMF3(n, w, item)
-		if err != nil {
-			return n, err
+	e.addTraits3(id)
+	b = encodeU29(b, 0x07)
+	return e.encodeUTF8VR(b, class)
+}
+
+// encodeVector3 writes the slice or array rv as a vector, typed by its
+// element as in vectorType.
+func (e *Encoder) encodeVector3(b []byte, rv reflect.Value) ([]byte, error) {
+	marker, class := vectorType(rv.Type().Elem())
+	b = append(b, marker)
+	e.addObject(AMF3, marker)
+	b = encodeU29(b, rv.Len()<<1|1)
+	// Not fixed-length.
+	b = append(b, 0x00)
+	if marker == amf3VectorObject {
+		b = e.encodeUTF8VR(b, class)
+	}
+	var err error
+	for i := 0; i < rv.Len(); i++ {
+		item := rv.Index(i)
+		if marker == amf3VectorObject {
+			if b, err = e.encodeAMF3(b, item.Interface()); err != nil {
+				return b, err
+			}
+			continue
+		}
+		for item.Kind() == reflect.Pointer {
+			if item.IsNil() {
+				return b, fmt.Errorf("nil item in Vector.<%s>", class)
+			}
+			item = item.Elem()
+		}
+		n, _ := toNumber(item.Interface())
+		switch marker {
+		case amf3VectorInt:
+			if n.kind == reflect.Int64 {
+				b = binary.BigEndian.AppendUint32(b, uint32(n.i))
+			} else {
+				b = binary.BigEndian.AppendUint32(b, uint32(n.u))
+			}
+		case amf3VectorUint:
+			b = binary.BigEndian.AppendUint32(b, uint32(n.u))
+		default:
+			f, err := e.float(n)
+			if err != nil {
+				return b, err
+			}
+			b = binary.BigEndian.AppendUint64(b, math.Float64bits(f))
 		}
 	}
-	return n, err
+	return b, nil
+}
+
+// encodeArrayCollection3 writes the slice or array rv as an ArrayCollection,
+// which Flex writes as its array.
+func (e *Encoder) encodeArrayCollection3(b []byte, rv reflect.Value) ([]byte, error) {
+	b = append(b, amf3Object)
+	e.addObject(AMF3, amf3Object)
+	b = e.encodeExternalizableTraits3(b, arrayCollectionClass)
+	items, _ := e.reflectValue(rv)
+	return e.encodeStrictArray3(b, items.([]interface{}))
+}
+
+func encodeByteArray3(b []byte, v []byte) []byte {
+	b = append(b, amf3ByteArray)
+	b = encodeU29(b, (len(v)<<1)|1)
+	return append(b, v...)
 }
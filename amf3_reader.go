@@ -0,0 +1,244 @@
+package amf
+
+import (
+	"fmt"
+	"io"
+	"slices"
+)
+
+// amf3Reader reads the bytes of one AMF3 value from a stream, walking its
+// markers to find its end, so that it can be decoded from memory.
+type amf3Reader struct {
+	r   byteReader
+	buf []byte
+	pos int // of the next byte, below len(buf) after unread
+	// strings holds where each string of the table is in buf, as buf may
+	// move as it grows.
+	strings [][2]int
+	// traits holds the shape of each traits, as only the number of sealed
+	// members is needed to find the end of an object.
+	traits []rawTraits3
+}
+
+type rawTraits3 struct {
+	sealed                  int
+	dynamic, externalizable bool
+	class                   string // of externalizable traits
+}
+
+func (r *amf3Reader) byte() (byte, error) {
+	if r.pos == len(r.buf) {
+		b, err := r.r.ReadByte()
+		if err != nil {
+			return 0, unexpectedEOF(err)
+		}
+		r.buf = append(r.buf, b)
+	}
+	r.pos++
+	return r.buf[r.pos-1], nil
+}
+
+func (r *amf3Reader) unread() {
+	r.pos--
+}
+
+// skip reads n bytes, growing by at most readChunk bytes at a time.
+func (r *amf3Reader) skip(n int) error {
+	for n > 0 {
+		m := min(n, readChunk)
+		r.buf = slices.Grow(r.buf, m)
+		if _, err := io.ReadFull(r.r, r.buf[len(r.buf):len(r.buf)+m]); err != nil {
+			return unexpectedEOF(err)
+		}
+		r.buf = r.buf[:len(r.buf)+m]
+		n -= m
+	}
+	r.pos = len(r.buf)
+	return nil
+}
+
+func (r *amf3Reader) u29() (int, error) {
+	n := 0
+	for i := 0; i < 4; i++ {
+		b, err := r.byte()
+		if err != nil {
+			return 0, err
+		}
+		if i == 3 {
+			return n<<8 | int(b), nil
+		}
+		n = n<<7 | int(b&0x7f)
+		if b&0x80 == 0 {
+			break
+		}
+	}
+	return n, nil
+}
+
+// str reads a UTF-8-vr string. The result is only valid until the next read.
+func (r *amf3Reader) str() ([]byte, error) {
+	ref, err := r.u29()
+	if err != nil {
+		return nil, err
+	}
+	if ref&1 == 0 {
+		if ref>>1 >= len(r.strings) {
+			return nil, fmt.Errorf("invalid string ref %d", ref>>1)
+		}
+		s := r.strings[ref>>1]
+		return r.buf[s[0]:s[1]], nil
+	}
+	start := len(r.buf)
+	if err := r.skip(ref >> 1); err != nil {
+		return nil, err
+	}
+	if ref > 1 {
+		r.strings = append(r.strings, [2]int{start, len(r.buf)})
+	}
+	return r.buf[start:], nil
+}
+
+func (r *amf3Reader) value() error {
+	marker, err := r.byte()
+	if err != nil {
+		return err
+	}
+	switch marker {
+	case amf3Undefined, amf3Null, amf3False, amf3True:
+		return nil
+	case amf3Integer:
+		_, err := r.u29()
+		return err
+	case amf3Double:
+		return r.skip(8)
+	case amf3String:
+		_, err := r.str()
+		return err
+	case amf3Date, amf3ByteArray:
+		ref, err := r.u29()
+		if err != nil || ref&1 == 0 {
+			return err
+		}
+		if marker == amf3Date {
+			return r.skip(8)
+		}
+		return r.skip(ref >> 1)
+	case amf3Array:
+		return r.array()
+	case amf3Object:
+		return r.object()
+	case amf3VectorInt, amf3VectorUint, amf3VectorDouble, amf3VectorObject:
+		return r.vector(marker)
+	}
+	return fmt.Errorf("unsupported type 0x%0X", marker)
+}
+
+func (r *amf3Reader) array() error {
+	num, err := r.u29()
+	if err != nil || num&1 == 0 {
+		return err
+	}
+	b, err := r.byte()
+	if err != nil {
+		return err
+	}
+	// As in decodeArray3, an array has either associative or dense members.
+	if num == 1 && b != 0x01 {
+		r.unread()
+		return r.dynamic()
+	}
+	if b != 0x01 {
+		return fmt.Errorf("invalid strict array")
+	}
+	for i := 0; i < num>>1; i++ {
+		if err := r.value(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *amf3Reader) object() error {
+	ref, err := r.u29()
+	if err != nil || ref&1 == 0 {
+		return err
+	}
+	var t rawTraits3
+	if ref&2 == 0 {
+		if ref>>2 >= len(r.traits) {
+			return fmt.Errorf("invalid traits ref %d", ref>>2)
+		}
+		t = r.traits[ref>>2]
+	} else {
+		t = rawTraits3{dynamic: ref&8 != 0, externalizable: ref&4 != 0}
+		class, err := r.str()
+		if err != nil {
+			return err
+		}
+		if t.externalizable {
+			t.class = string(class)
+		} else {
+			t.sealed = ref >> 4
+		}
+		for i := 0; i < t.sealed; i++ {
+			if _, err := r.str(); err != nil {
+				return err
+			}
+		}
+		r.traits = append(r.traits, t)
+	}
+	if t.externalizable {
+		if t.class != arrayCollectionClass {
+			return fmt.Errorf("unsupported externalizable class %s", t.class)
+		}
+		return r.value()
+	}
+	for i := 0; i < t.sealed; i++ {
+		if err := r.value(); err != nil {
+			return err
+		}
+	}
+	if !t.dynamic {
+		return nil
+	}
+	return r.dynamic()
+}
+
+func (r *amf3Reader) vector(marker byte) error {
+	ref, err := r.u29()
+	if err != nil || ref&1 == 0 {
+		return err
+	}
+	// The fixed-length flag.
+	if _, err := r.byte(); err != nil {
+		return err
+	}
+	switch marker {
+	case amf3VectorInt, amf3VectorUint:
+		return r.skip(ref >> 1 * 4)
+	case amf3VectorDouble:
+		return r.skip(ref >> 1 * 8)
+	}
+	if _, err := r.str(); err != nil {
+		return err
+	}
+	for i := 0; i < ref>>1; i++ {
+		if err := r.value(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dynamic reads name and value pairs up to the empty name.
+func (r *amf3Reader) dynamic() error {
+	for {
+		key, err := r.str()
+		if err != nil || len(key) == 0 {
+			return err
+		}
+		if err := r.value(); err != nil {
+			return err
+		}
+	}
+}
@@ -1,6 +1,10 @@
 package amf
 
 import (
+	"bytes"
+	"io"
+	"reflect"
+	"slices"
 	"testing"
 	"time"
 )
@@ -12,12 +16,14 @@ var encodeCases3 = []encodeTestCase{
 	{amf3MinInt, []byte{0x04, 0xc0, 0x80, 0x80, 0x00}},
 	{amf3MinInt - 1, []byte{0x5, 0xc1, 0xb0, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00}},
 	{amf3MaxInt, []byte{0x04, 0xbf, 0xff, 0xff, 0xff}},
+	{0x200000, []byte{0x04, 0x80, 0xc0, 0x80, 0x00}},
 	{amf3MaxInt + 1, []byte{0x5, 0x41, 0xb0, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}},
 	{true, []byte{0x03}},
 	{false, []byte{0x02}},
 	{"foo", []byte{0x06, 0x07, 0x66, 0x6f, 0x6f}},
 	{"", []byte{0x06, 0x01}},
 	{nil, []byte{0x01}},
+	{Undefined{}, []byte{0x00}},
 	// {map[string]interface{}{
 	// 	"1": 1,
 	// 	"2": 3.14,
@@ -58,12 +64,165 @@ var encodeCases3 = []encodeTestCase{
 		0x03,
 		0x02,
 		0x03}},
+	{map[string]interface{}{"a": 1, "b": "x"}, []byte{0x0a, 0x0b, 0x01,
+		0x03, 0x61 /*:*/, 0x04, 0x01,
+		0x03, 0x62 /*:*/, 0x06, 0x03, 0x78,
+		0x01}},
+	{TypedObject{"Foo", map[string]interface{}{"a": 1, "b": true}}, []byte{0x0a, 0x23,
+		0x07, 0x46, 0x6f, 0x6f,
+		0x03, 0x61, 0x03, 0x62,
+		0x04, 0x01,
+		0x03}},
+	{[]byte{0x01, 0x02, 0x03}, []byte{0x0c, 0x07, 0x01, 0x02, 0x03}},
+	{[]interface{}{"foo", "foo"}, []byte{0x09,
+		0x05, 0x01,
+		0x06, 0x07, 0x66, 0x6f, 0x6f,
+		0x06, 0x00}},
+	{[]interface{}{
+		TypedObject{"Foo", map[string]interface{}{"a": 1}},
+		TypedObject{"Foo", map[string]interface{}{"a": 2}}}, []byte{0x09,
+		0x05, 0x01,
+		0x0a, 0x13, 0x07, 0x46, 0x6f, 0x6f, 0x03, 0x61, 0x04, 0x01,
+		0x0a, 0x01, 0x04, 0x02}},
+	{int8(-1), []byte{0x04, 0xff, 0xff, 0xff, 0xff}},
+	{uint16(300), []byte{0x04, 0x82, 0x2c}},
+	{uint(amf3MaxInt), []byte{0x04, 0xbf, 0xff, 0xff, 0xff}},
+	{uint(amf3MaxInt + 1), []byte{0x5, 0x41, 0xb0, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}},
+	{int64(1 << 40), []byte{0x05, 0x42, 0x70, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}},
+	{uint64(1 << 63), []byte{0x05, 0x43, 0xe0, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}},
+	{float32(0.5), []byte{0x05, 0x3f, 0xe0, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}},
+	{namedInt(5), []byte{0x04, 0x05}},
+	{namedFloat(0.5), []byte{0x05, 0x3f, 0xe0, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}},
 }
 
 func TestEncodeAMF3(t *testing.T) {
 	testEncode(t, encodeCases3, EncodeAMF3, "TestEncodeAMF3")
 }
 
+func TestEncodeAMF3ExactIntegers(t *testing.T) {
+	testExactIntegers(t, func(e *Encoder, v interface{}) ([]byte, error) {
+		return e.AppendAMF3(nil, v)
+	})
+}
+
+func TestAppendAMF3(t *testing.T) {
+	testEncode(t, encodeCases3, func(w io.Writer, v interface{}) (int, error) {
+		b, err := AppendAMF3([]byte{0xff}, v)
+		if err != nil {
+			return 0, err
+		}
+		if b[0] != 0xff {
+			t.Errorf("AppendAMF3(%#v) overwrote dst", v)
+		}
+		return w.Write(b[1:])
+	}, "TestAppendAMF3")
+	e := NewEncoder()
+	testAppendAllocs(t, benchValue, e.AppendAMF3, e.Reset, "AppendAMF3")
+}
+
+func TestAppendAMF3Rollback(t *testing.T) {
+	m := map[string]interface{}{}
+	testRollback(t, (*Encoder).AppendAMF3, []interface{}{"ab", m},
+		[]byte{0x09, 0x05, 0x01, 0x06, 0x05, 0x61, 0x62, 0x0a, 0x0b, 0x01, 0x01})
+}
+
+func TestDatesAMF3(t *testing.T) {
+	testDates(t, (*Encoder).AppendAMF3, (*Decoder).DecodeAMF3)
+}
+
+func TestEncodeAMF3References(t *testing.T) {
+	m := map[string]interface{}{"a": nil}
+	b := []byte{0x01}
+	testReferences(t, []referenceTestCase{
+		{cyclicNode(), []byte{0x0a, 0x0b, 0x01,
+			0x09, 0x6e, 0x61, 0x6d, 0x65, 0x06, 0x03, 0x61,
+			0x09, 0x6e, 0x65, 0x78, 0x74, 0x0a, 0x00,
+			0x01}, nil},
+		// The date takes index 1 in the object table.
+		{[]interface{}{time.UnixMilli(0), m, m}, []byte{0x09, 0x07, 0x01,
+			0x08, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+			0x0a, 0x0b, 0x01, 0x03, 0x61, 0x01, 0x01,
+			0x0a, 0x04,
+		}, []byte{0x09, 0x07, 0x01,
+			0x08, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+			0x0a, 0x0b, 0x01, 0x03, 0x61, 0x01, 0x01,
+			0x0a, 0x01, 0x00, 0x01, 0x01}},
+		// Dates are the same object when they have the same time.
+		{[]interface{}{time.UnixMilli(0), time.UnixMilli(0).UTC()}, []byte{0x09, 0x05, 0x01,
+			0x08, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+			0x08, 0x02,
+		}, []byte{0x09, 0x05, 0x01,
+			0x08, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+			0x08, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}},
+		{[]interface{}{b, b}, []byte{0x09, 0x05, 0x01, 0x0c, 0x03, 0x01, 0x0c, 0x02},
+			[]byte{0x09, 0x05, 0x01, 0x0c, 0x03, 0x01, 0x0c, 0x03, 0x01}},
+	}, (*Encoder).AppendAMF3)
+}
+
+func TestEncodeAMF3Collections(t *testing.T) {
+	arrayCollection := append([]byte{0x0a, 0x07, 0x43}, "flex.messaging.io.ArrayCollection"...)
+	shared := []int32{1}
+	for _, c := range []struct {
+		collection Collection
+		in         interface{}
+		want       []byte
+	}{
+		{Vector, []int32{1, -1}, []byte{0x0d, 0x05, 0x00, 0x00, 0x00, 0x00, 0x01, 0xff, 0xff, 0xff, 0xff}},
+		{Vector, [1]uint16{2}, []byte{0x0d, 0x03, 0x00, 0x00, 0x00, 0x00, 0x02}},
+		{Vector, []uint32{0xffffffff}, []byte{0x0e, 0x03, 0x00, 0xff, 0xff, 0xff, 0xff}},
+		{Vector, []float64{1.5}, []byte{0x0f, 0x03, 0x00, 0x3f, 0xf8, 0, 0, 0, 0, 0, 0}},
+		{Vector, []int{1}, []byte{0x0f, 0x03, 0x00, 0x3f, 0xf0, 0, 0, 0, 0, 0, 0}},
+		{Vector, []string{"a", "a"}, []byte{0x10, 0x05, 0x00, 0x0d, 0x53, 0x74, 0x72, 0x69, 0x6e, 0x67,
+			0x06, 0x03, 0x61, 0x06, 0x02}},
+		{Vector, []*registeredUser{nil}, []byte{0x10, 0x03, 0x00, 0x13, 0x74, 0x65, 0x73, 0x74, 0x2e, 0x55, 0x73, 0x65, 0x72, 0x01}},
+		{Vector, [][]int32{{1}}, append(append([]byte{0x10, 0x03, 0x00, 0x33}, "__AS3__.vec::Vector.<int>"...),
+			0x0d, 0x03, 0x00, 0x00, 0x00, 0x00, 0x01)},
+		// The same slice twice is a reference to the vector.
+		{Vector, []interface{}{shared, shared}, []byte{0x09, 0x05, 0x01, 0x0d, 0x03, 0x00, 0x00, 0x00, 0x00, 0x01, 0x0d, 0x02}},
+		{Vector, []int32(nil), []byte{0x01}},
+		{Vector, []byte{1}, []byte{0x0c, 0x03, 0x01}},
+		{Vector, []interface{}{1}, []byte{0x09, 0x03, 0x01, 0x04, 0x01}},
+		{ArrayCollection, []int{1, 2}, append(slices.Clip(arrayCollection), 0x09, 0x05, 0x01, 0x04, 0x01, 0x04, 0x02)},
+		{ArrayCollection, [][]int{{1}, {2}}, append(slices.Clip(arrayCollection), 0x09, 0x05, 0x01,
+			0x0a, 0x01, 0x09, 0x03, 0x01, 0x04, 0x01,
+			0x0a, 0x01, 0x09, 0x03, 0x01, 0x04, 0x02)},
+		{ArrayCollection, []byte{1}, []byte{0x0c, 0x03, 0x01}},
+	} {
+		e := &Encoder{Collection: c.collection}
+		if got, err := e.AppendAMF3(nil, c.in); err != nil || !bytes.Equal(got, c.want) {
+			t.Errorf("AppendAMF3(%#v) with Collection %d == % x, %v, want % x", c.in, c.collection, got, err, c.want)
+		}
+	}
+	if _, err := (&Encoder{Collection: Vector}).AppendAMF3(nil, []*int32{nil}); err == nil {
+		t.Error("AppendAMF3 of a nil item in Vector.<int> succeeded")
+	}
+	for _, collection := range []Collection{Vector, ArrayCollection} {
+		in := [][]uint32{{0xffffffff, 1}}
+		b, err := (&Encoder{Collection: collection}).AppendAMF3(nil, in)
+		var out [][]uint32
+		if err == nil {
+			err = Unmarshal(b, AMF3, &out)
+		}
+		if err != nil || !reflect.DeepEqual(out, in) {
+			t.Errorf("Unmarshal with Collection %d == %v, %v, want %v", collection, out, err, in)
+		}
+	}
+	// AMF0 has neither.
+	if got, err := (&Encoder{Collection: Vector}).AppendAMF0(nil, []int32{1}); err != nil ||
+		!bytes.Equal(got, []byte{0x0a, 0x00, 0x00, 0x00, 0x01, 0x00, 0x3f, 0xf0, 0, 0, 0, 0, 0, 0}) {
+		t.Errorf("AppendAMF0 with Collection Vector == % x, %v", got, err)
+	}
+}
+
+func BenchmarkEncodeAMF3(b *testing.B) {
+	benchmarkEncode(b, EncodeAMF3)
+}
+
+func BenchmarkAppendAMF3(b *testing.B) {
+	e := NewEncoder()
+	benchmarkAppend(b, e.AppendAMF3, e.Reset)
+}
+
 var decodeCases3 = []decodeTestCase{
 	{[]byte{0x05, 0x40, 0x9, 0x1e, 0xb8, 0x51, 0xeb, 0x85, 0x1f}, 9, 3.14},
 	{[]byte{0x04, 0x01}, 2, int(1)},
@@ -71,6 +230,7 @@ var decodeCases3 = []decodeTestCase{
 	{[]byte{0x04, 0xc0, 0x80, 0x80, 0x00}, 5, int(amf3MinInt)},
 	{[]byte{0x5, 0xc1, 0xb0, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00}, 9, float64(amf3MinInt - 1)},
 	{[]byte{0x04, 0xbf, 0xff, 0xff, 0xff}, 5, int(amf3MaxInt)},
+	{[]byte{0x04, 0x80, 0xc0, 0x80, 0x00}, 5, 0x200000},
 	{[]byte{0x5, 0x41, 0xb0, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}, 9, float64(amf3MaxInt + 1)},
 	{[]byte{0x03}, 1, true},
 	{[]byte{0x02}, 1, false},
@@ -102,6 +262,7 @@ var decodeCases3 = []decodeTestCase{
 		"3": "three",
 		"4": nil,
 		"5": true})},
+	{[]byte{0x09, 0x01, 0x01}, 3, []interface{}{}},
 	{[]byte{0x08, 0x01, 0x42, 0x3c, 0xbe, 0x99, 0x1a, 0x83, 0x00, 0x00}, 10, time.Unix(123456789, 123000000)},
 	{[]byte{0x09,
 		0x07, 0x01,
@@ -118,12 +279,95 @@ var decodeCases3 = []decodeTestCase{
 		0x03,
 		0x02,
 		0x03}, 6, []interface{}{true, false, true}},
+	{[]byte{0x0a, 0x0b, 0x01,
+		0x03, 0x61 /*:*/, 0x04, 0x01,
+		0x03, 0x62 /*:*/, 0x06, 0x03, 0x78,
+		0x01}, 13, map[string]interface{}{"a": 1, "b": "x"}},
+	{[]byte{0x0a, 0x23,
+		0x07, 0x46, 0x6f, 0x6f,
+		0x03, 0x61, 0x03, 0x62,
+		0x04, 0x01,
+		0x03}, 13, TypedObject{"Foo", map[string]interface{}{"a": 1, "b": true}}},
+	{[]byte{0x0c, 0x07, 0x01, 0x02, 0x03}, 5, []byte{0x01, 0x02, 0x03}},
+	{[]byte{0x09,
+		0x05, 0x01,
+		0x06, 0x07, 0x66, 0x6f, 0x6f,
+		0x06, 0x00}, 10, []interface{}{"foo", "foo"}},
+	{[]byte{0x09,
+		0x05, 0x01,
+		0x0a, 0x13, 0x07, 0x46, 0x6f, 0x6f, 0x03, 0x61, 0x04, 0x01,
+		0x0a, 0x01, 0x04, 0x02}, 17, []interface{}{
+		TypedObject{"Foo", map[string]interface{}{"a": 1}},
+		TypedObject{"Foo", map[string]interface{}{"a": 2}}}},
+	{[]byte{0x09,
+		0x05, 0x01,
+		0x09, 0x03, 0x01, 0x04, 0x01,
+		0x09, 0x02}, 10, []interface{}{[]interface{}{1}, []interface{}{1}}},
+	{[]byte{0x0d, 0x05, 0x00, 0x00, 0x00, 0x00, 0x01, 0xff, 0xff, 0xff, 0xff}, 11, []interface{}{1, -1}},
+	{[]byte{0x0e, 0x03, 0x01, 0xff, 0xff, 0xff, 0xff}, 7, []interface{}{0xffffffff}},
+	{[]byte{0x0f, 0x03, 0x00, 0x3f, 0xf8, 0, 0, 0, 0, 0, 0}, 11, []interface{}{1.5}},
+	{[]byte{0x10, 0x05, 0x00, 0x0d, 0x53, 0x74, 0x72, 0x69, 0x6e, 0x67,
+		0x06, 0x03, 0x61, 0x06, 0x02}, 15, []interface{}{"a", "a"}},
+	{[]byte{0x09,
+		0x05, 0x01,
+		0x0d, 0x03, 0x00, 0x00, 0x00, 0x00, 0x01,
+		0x0d, 0x02}, 12, []interface{}{[]interface{}{1}, []interface{}{1}}},
+	// [<ArrayCollection> [int(1)], <ref 1>]: the reference to the collection is
+	// its array.
+	{append(append([]byte{0x09, 0x05, 0x01, 0x0a, 0x07, 0x43}, "flex.messaging.io.ArrayCollection"...),
+		0x09, 0x03, 0x01, 0x04, 0x01,
+		0x0a, 0x02), 46, []interface{}{[]interface{}{1}, []interface{}{1}}},
 }
 
 func TestDecodeAMF3(t *testing.T) {
-	testDecode(t, decodeCases3, decodeAMF3, "TestDecodeAMF3")
+	testDecode(t, decodeCases3, func(v []byte) (interface{}, int, error) {
+		return NewDecoder().DecodeAMF3(v)
+	}, "TestDecodeAMF3")
+	testDecode(t, []decodeTestCase{
+		{[]byte{0x00}, 1, Undefined{}},
+		{[]byte{0x01}, 1, nil},
+	}, (&Decoder{KeepUndefined: true}).DecodeAMF3, "TestDecodeAMF3KeepUndefined")
+}
+
+func TestDecodeAllAMF3(t *testing.T) {
+	testDecodeAll(t, []decodeAllTestCase{
+		{[]byte{}, nil},
+		{[]byte{0x06, 0x07, 0x66, 0x6f, 0x6f,
+			0x06, 0x00,
+			0x04, 0x01}, []interface{}{"foo", "foo", 1}},
+		{[]byte{0x06, 0x00}, nil},
+	}, DecodeAllAMF3, "TestDecodeAllAMF3")
 }
 
-// func TestExternAMF3(t *testing.T) {
-// 	testExtern(t, decodeCases3, "TestExternAMF3", 3)
-// }
+func TestDecoderValuesAMF3(t *testing.T) {
+	d := NewDecoder()
+	if _, _, err := d.DecodeAMF3([]byte{0x06, 0x03, 0x61}); err != nil {
+		t.Fatal(err)
+	}
+	var got []interface{}
+	for value, err := range d.ValuesAMF3([]byte{0x06, 0x00, 0x06, 0x03, 0x62, 0x06, 0x02}) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, value)
+	}
+	if want := []interface{}{"a", "b", "b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Decoder.ValuesAMF3 == %#v, want %#v", got, want)
+	}
+}
+
+func TestValuesAMF3Break(t *testing.T) {
+	var got []interface{}
+	for value, err := range ValuesAMF3([]byte{0x04, 0x01, 0x04, 0x02, 0xff}) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, value)
+		if len(got) == 2 {
+			break
+		}
+	}
+	if !reflect.DeepEqual(got, []interface{}{1, 2}) {
+		t.Errorf("ValuesAMF3 == %#v", got)
+	}
+}
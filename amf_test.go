@@ -2,12 +2,9 @@ package amf
 
 import (
 	"bytes"
-	"encoding/hex"
 	"io"
-	"net"
-	"os/exec"
 	"reflect"
-	"strconv"
+	"slices"
 	"testing"
 	"time"
 )
@@ -37,6 +34,204 @@ func testEncode(t *testing.T, cases []encodeTestCase, encode encodeFunc, name st
 	}
 }
 
+type namedInt int16
+type namedFloat float32
+
+// testRollback checks that a value failing to encode after adding to the
+// reference tables doesn't leave references for v to point to.
+func testRollback(t *testing.T, appendFn func(*Encoder, []byte, interface{}) ([]byte, error), v []interface{}, want []byte) {
+	e := &Encoder{ExactIntegers: true}
+	if _, err := appendFn(e, nil, append(slices.Clip(v), int64(1<<53+1))); err == nil {
+		t.Fatalf("encode of an inexact integer succeeded")
+	}
+	b, err := appendFn(e, nil, v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, want) {
+		t.Errorf("after a failed encode got %#v, want %#v", b, want)
+	}
+}
+
+func testExactIntegers(t *testing.T, encode func(*Encoder, interface{}) ([]byte, error)) {
+	for _, c := range []struct {
+		in    interface{}
+		exact bool
+	}{
+		{int64(1 << 53), true},
+		{int64(-1 << 53), true},
+		{int64(1<<53 + 1), false},
+		{int64(-1<<53 - 1), false},
+		{uint64(1 << 53), true},
+		{uint64(1<<53 + 1), false},
+		{1<<53 + 1, false},
+	} {
+		if _, err := encode(&Encoder{}, c.in); err != nil {
+			t.Errorf("encode(%#v): %s", c.in, err)
+		}
+		_, err := encode(&Encoder{ExactIntegers: true}, c.in)
+		if c.exact && err != nil {
+			t.Errorf("exact encode(%#v): %s", c.in, err)
+		} else if !c.exact && err == nil {
+			t.Errorf("exact encode(%#v) succeeded", c.in)
+		}
+	}
+}
+
+// refNode encodes as an object that can refer to itself.
+type refNode struct {
+	Name string   `amf:"name"`
+	Next *refNode `amf:"next,omitempty"`
+}
+
+func cyclicNode() *refNode {
+	n := &refNode{Name: "a"}
+	n.Next = n
+	return n
+}
+
+type referenceTestCase struct {
+	in     interface{}
+	want   []byte
+	inline []byte // with NoReferences, nil for an error
+}
+
+func testReferences(t *testing.T, cases []referenceTestCase, appendFn func(*Encoder, []byte, interface{}) ([]byte, error)) {
+	for _, c := range cases {
+		got, err := appendFn(&Encoder{}, nil, c.in)
+		if err != nil || !bytes.Equal(got, c.want) {
+			t.Errorf("encode(%#v) == % x, %v, want % x", c.in, got, err, c.want)
+		}
+		got, err = appendFn(&Encoder{NoReferences: true}, nil, c.in)
+		if c.inline == nil {
+			if err == nil {
+				t.Errorf("encode(%#v) without references succeeded", c.in)
+			}
+		} else if err != nil || !bytes.Equal(got, c.inline) {
+			t.Errorf("encode(%#v) without references == % x, %v, want % x", c.in, got, err, c.inline)
+		}
+	}
+}
+
+// testDates encodes and decodes dates before 1970, with a fraction of a
+// millisecond, at the zero time.Time and in a Location.
+func testDates(t *testing.T, appendFn func(*Encoder, []byte, interface{}) ([]byte, error), decode func(*Decoder, []byte) (interface{}, int, error)) {
+	loc := time.FixedZone("UTC-5", -5*60*60)
+	before := time.UnixMilli(-1).Add(-500 * time.Microsecond)
+	for _, c := range []struct {
+		in   time.Time
+		e    Encoder
+		d    Decoder
+		want time.Time
+	}{
+		{before, Encoder{}, Decoder{}, time.UnixMilli(-2)},
+		{before, Encoder{SubMillisecond: true}, Decoder{}, before},
+		{time.Time{}, Encoder{}, Decoder{}, time.Time{}},
+		{time.Date(-1000, 1, 1, 0, 0, 0, 0, time.UTC), Encoder{}, Decoder{}, time.Date(-1000, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{time.UnixMilli(1234567890123), Encoder{}, Decoder{Location: loc}, time.UnixMilli(1234567890123).In(loc)},
+	} {
+		b, err := appendFn(&c.e, nil, c.in)
+		if err != nil {
+			t.Errorf("encode(%v): %s", c.in, err)
+			continue
+		}
+		got, _, err := decode(&c.d, b)
+		if g, ok := got.(time.Time); err != nil || !ok || !g.Equal(c.want) || c.d.Location != nil && g.Location() != c.d.Location {
+			t.Errorf("decode(encode(%v)) == %v, %v, want %v", c.in, got, err, c.want)
+		}
+	}
+}
+
+func TestDecodeStrictLenient(t *testing.T) {
+	for _, c := range []struct {
+		version AMFVersion
+		in      []byte
+		ok      bool   // accepted by default
+		want    string // when Lenient
+		warning string
+	}{
+		{AMF3, []byte{0x04, 0x01, 0xff}, true, `int(1)`, "1 trailing bytes"},
+		{AMF0, []byte{0x03, 0x00, 0x01, 0x61, 0x05}, false, `{a: null}`, "missing end of object"},
+		{AMF0, []byte{0x03, 0x00, 0x01, 0x61, 0x05, 0x00, 0x00}, false, `{a: null}`, "missing end of object"},
+		{AMF3, []byte{0x0a, 0x0b, 0x01, 0x03, 0x61, 0x01}, false, `{a: null}`, "missing end of object"},
+		{AMF0, []byte{0x08, 0x00, 0x00, 0x00, 0x02, 0x00, 0x01, 0x61, 0x05, 0x00, 0x00, 0x09}, true, `ecma{a: null}`, "ECMA array count 2 for 1 members"},
+		{AMF0, []byte{0x0b, 0, 0, 0, 0, 0, 0, 0, 0, 0xfe, 0xd4}, true, `date("1970-01-01T00:00:00.000Z")`, "date timezone -300"},
+		{AMF0, []byte{0x01, 0x02}, true, `true`, "invalid boolean 0x02"},
+	} {
+		decode := func(d *Decoder) (interface{}, error) {
+			var v interface{}
+			var err error
+			if c.version == AMF0 {
+				v, _, err = d.DecodeAMF0(c.in)
+			} else {
+				v, _, err = d.DecodeAMF3(c.in)
+			}
+			return v, err
+		}
+		if _, err := decode(&Decoder{}); (err == nil) != c.ok {
+			t.Errorf("decode(% x) == %v, want ok %v", c.in, err, c.ok)
+		}
+		if v, err := decode(&Decoder{Strict: true, Lenient: true}); err == nil {
+			t.Errorf("Strict decode(% x) == %#v", c.in, v)
+		}
+		d := &Decoder{Lenient: true, Ordered: true}
+		v, err := decode(d)
+		if got, _ := Format(v); err != nil || got != c.want {
+			t.Errorf("Lenient decode(% x) == %s, %v, want %s", c.in, got, err, c.want)
+		}
+		if w := d.Warnings(); len(w) != 1 || w[0] != c.warning {
+			t.Errorf("Lenient decode(% x) warnings == %q, want %q", c.in, w, c.warning)
+		}
+		if d.Reset(); d.Warnings() != nil {
+			t.Errorf("Reset kept warnings")
+		}
+	}
+}
+
+func testAppendAllocs(t *testing.T, v interface{}, appendFn func([]byte, interface{}) ([]byte, error), reset func(), name string) {
+	buf := make([]byte, 0, 1024)
+	allocs := testing.AllocsPerRun(100, func() {
+		reset()
+		if _, err := appendFn(buf[:0], v); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if allocs != 0 {
+		t.Errorf("%s allocates %v times per run", name, allocs)
+	}
+}
+
+// Benchmark
+
+var benchValue interface{} = map[string]interface{}{
+	"level":       "status",
+	"code":        "NetStream.Play.Start",
+	"description": "Started playing stream.",
+	"details":     "stream",
+	"clientid":    1234.0,
+	"list":        []interface{}{1.0, "two", true, nil},
+}
+
+func benchmarkEncode(b *testing.B, encode encodeFunc) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := encode(io.Discard, benchValue); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func benchmarkAppend(b *testing.B, appendFn func([]byte, interface{}) ([]byte, error), reset func()) {
+	b.ReportAllocs()
+	buf := make([]byte, 0, 1024)
+	for i := 0; i < b.N; i++ {
+		reset()
+		if _, err := appendFn(buf[:0], benchValue); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
 // Decode
 
 type decodeTestCase struct {
@@ -59,7 +254,7 @@ func testDecode(t *testing.T, cases []decodeTestCase, decode decodeFunc, name st
 			continue
 		}
 		switch got.(type) {
-		case []interface{}, map[string]interface{}, ECMAArray:
+		case []interface{}, map[string]interface{}, ECMAArray, TypedObject, []byte:
 			if !reflect.DeepEqual(c.want, got) {
 				t.Errorf("%s(%#v) == %#v, want %#v", name, c.in, got, c.want)
 			}
@@ -71,33 +266,30 @@ func testDecode(t *testing.T, cases []decodeTestCase, decode decodeFunc, name st
 	}
 }
 
-// Extern
+// Decode all
 
-func testExtern(t *testing.T, cases []decodeTestCase, name string, version int) {
-	cmd := exec.Command("ruby", "amf_test_server.rb", strconv.Itoa(version))
-	cmd.Start()
+type decodeAllTestCase struct {
+	in   []byte
+	want []interface{} // nil for an error
+}
 
-	conn, err := net.Dial("tcp", "localhost:4242")
-	if err != nil {
-		return
-	}
-	buffer := make([]byte, 256)
+type decodeAllFunc func([]byte) ([]interface{}, error)
+
+func testDecodeAll(t *testing.T, cases []decodeAllTestCase, decode decodeAllFunc, name string) {
 	for _, c := range cases {
-		outdata := hex.EncodeToString(c.in)
-		conn.SetDeadline(time.Now().Add(time.Second))
-		t.Logf("sending [%s]", outdata)
-		conn.Write(append([]byte(outdata), '\n'))
-		ndata, err := conn.Read(buffer)
-		if err != nil && err != io.EOF {
-			t.Error(err)
+		got, err := decode(c.in)
+		if c.want == nil {
+			if err == nil && len(c.in) > 0 {
+				t.Errorf("%s(%#v) == %#v, want error", name, c.in, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s(%#v): %s", name, c.in, err)
 			continue
 		}
-		indata := buffer[:ndata]
-		t.Logf("received [%s]", string(indata))
-		if outdata != string(indata) {
-			t.Errorf("%s(%#v): %s != %s", name, c.in, indata, outdata)
+		if !reflect.DeepEqual(c.want, got) {
+			t.Errorf("%s(%#v) == %#v, want %#v", name, c.in, got, c.want)
 		}
 	}
-	conn.Write([]byte("exit"))
-	conn.Close()
 }
@@ -1,7 +1,10 @@
 require 'socket'
 require 'rocketamf'
 
-port = 4242
+# Decodes and encodes again with RocketAMF each hex line it reads, replying
+# with a hex line; used by TestCorpusRocketAMF.
+amf_version = ARGV[0].to_i
+port = 4242 + amf_version
 server = TCPServer.new port
 puts "server created on port #{port}"
 
@@ -29,8 +32,6 @@ def transform_amf(in_bytes, version)
   end
 end
 
-amf_version = ARGV[0].to_i
-
 loop do
 	puts "waiting for client"
 	client = server.accept
@@ -48,7 +49,7 @@ loop do
 			out_bytes = transform_amf(in_bytes, amf_version)
 			out_data = bytes_to_hex(out_bytes)
 			puts "sending [#{out_data}]"
-			client.write out_data
+			client.puts out_data
 		rescue StandardError
 			break
 		end
@@ -0,0 +1,519 @@
+// Package amfjson converts decoded AMF values to JSON and back.
+//
+// JSON has no counterpart for several AMF types, so they are written as
+// single-purpose objects whose keys start with "$":
+//
+//	undefined          {"$undefined": true}
+//	Date               {"$date": "2006-01-02T15:04:05.000Z"}
+//	ByteArray          {"$bytes": "<base64>"}
+//	ECMA array         {"$ecma": {...}}
+//	typed object       {"$class": "com.acme.User", "$sealed": 2, "$dynamic": true, "$members": {...}}
+//	sealed anonymous   {"$class": "", "$sealed": 1, "$members": {...}}
+//	NaN and infinities {"$double": "NaN"}
+//	reference          {"$ref": 0}
+//
+// A reference is to the n-th array, object, ECMA array, typed object or byte
+// array of the JSON, counted from 0 in the order they start. It is written
+// for a value met again, so that cyclic values can be written and shared ones
+// stay shared when converted back.
+//
+// Dates have nine fraction digits when they are not whole milliseconds.
+// Keys of plain objects that start with "$" are escaped with another "$".
+// Integral Numbers are written with a ".0" fraction so that they stay distinct
+// from AMF3 integers. Members are written in the order they were decoded, so
+// a payload decoded by FromAMF converts back to the same bytes with ToAMF.
+package amfjson
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	amf "github.com/TatoExp/go-amf"
+)
+
+// Dates are written in milliseconds, as AMF has them, or in nanoseconds when
+// they have a fraction of a millisecond.
+const (
+	dateLayout     = "2006-01-02T15:04:05.000Z07:00"
+	dateNanoLayout = "2006-01-02T15:04:05.000000000Z07:00"
+)
+
+// FromAMF decodes one AMF value from data and returns its JSON form.
+func FromAMF(data []byte, version amf.AMFVersion) ([]byte, error) {
+	d := &amf.Decoder{Ordered: true, KeepUndefined: true}
+	var value interface{}
+	var err error
+	switch version {
+	case amf.AMF0:
+		value, _, err = d.DecodeAMF0(data)
+	case amf.AMF3:
+		value, _, err = d.DecodeAMF3(data)
+	default:
+		return nil, fmt.Errorf("unsupported version %d", version)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return Marshal(value)
+}
+
+// ToAMF encodes the value described by the JSON in data. Dates keep their
+// fraction of a millisecond.
+func ToAMF(data []byte, version amf.AMFVersion) ([]byte, error) {
+	value, err := Unmarshal(data)
+	if err != nil {
+		return nil, err
+	}
+	buf := &bytes.Buffer{}
+	e := &amf.Encoder{SubMillisecond: true}
+	switch version {
+	case amf.AMF0:
+		_, err = e.EncodeAMF0(buf, value)
+	case amf.AMF3:
+		_, err = e.EncodeAMF3(buf, value)
+	default:
+		return nil, fmt.Errorf("unsupported version %d", version)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Marshal returns the JSON form of a value as produced by the amf decoders.
+func Marshal(v interface{}) ([]byte, error) {
+	m := &marshaler{seen: make(map[interface{}]int)}
+	if err := m.marshal(v); err != nil {
+		return nil, err
+	}
+	return m.buf.Bytes(), nil
+}
+
+// marshaler numbers the containers it writes, for references to them.
+type marshaler struct {
+	buf  bytes.Buffer
+	seen map[interface{}]int
+	n    int
+}
+
+// container numbers v and reports whether it was written before, in which
+// case a reference to it has been written instead.
+func (m *marshaler) container(v interface{}) bool {
+	if id, ok := amf.Identity(v); ok {
+		if n, ok := m.seen[id]; ok {
+			fmt.Fprintf(&m.buf, `{"$ref":%d}`, n)
+			return true
+		}
+		m.seen[id] = m.n
+	}
+	m.n++
+	return false
+}
+
+func (m *marshaler) marshal(v interface{}) error {
+	buf := &m.buf
+	switch v.(type) {
+	case []byte, []interface{}, map[string]interface{}, amf.ECMAArray, amf.TypedObject, *amf.OrderedObject, *amf.OrderedECMAArray:
+		if m.container(v) {
+			return nil
+		}
+	}
+	switch v := v.(type) {
+	case nil:
+		buf.WriteString("null")
+	case amf.Undefined:
+		buf.WriteString(`{"$undefined":true}`)
+	case bool:
+		buf.WriteString(strconv.FormatBool(v))
+	case int:
+		buf.WriteString(strconv.Itoa(v))
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			fmt.Fprintf(buf, `{"$double":"%s"}`, strconv.FormatFloat(v, 'g', -1, 64))
+			break
+		}
+		s := strconv.FormatFloat(v, 'g', -1, 64)
+		if !strings.ContainsAny(s, ".e") {
+			s += ".0"
+		}
+		buf.WriteString(s)
+	case string:
+		writeString(buf, v)
+	case time.Time:
+		layout := dateLayout
+		if v.Nanosecond()%1e6 != 0 {
+			layout = dateNanoLayout
+		}
+		fmt.Fprintf(buf, `{"$date":"%s"}`, v.UTC().Format(layout))
+	case []byte:
+		fmt.Fprintf(buf, `{"$bytes":"%s"}`, base64.StdEncoding.EncodeToString(v))
+	case []interface{}:
+		buf.WriteByte('[')
+		for i, item := range v {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := m.marshal(item); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+	case map[string]interface{}:
+		return m.members(sortedKeys(v), v, true)
+	case amf.ECMAArray:
+		buf.WriteString(`{"$ecma":`)
+		if err := m.members(sortedKeys(v), v, false); err != nil {
+			return err
+		}
+		buf.WriteByte('}')
+	case *amf.OrderedECMAArray:
+		buf.WriteString(`{"$ecma":`)
+		if err := m.ordered(v.Keys(), v.Get, false); err != nil {
+			return err
+		}
+		buf.WriteByte('}')
+	case amf.TypedObject:
+		buf.WriteString(`{"$class":`)
+		writeString(buf, v.Class)
+		buf.WriteString(`,"$members":`)
+		if err := m.members(sortedKeys(v.Members), v.Members, false); err != nil {
+			return err
+		}
+		buf.WriteByte('}')
+	case *amf.OrderedObject:
+		if v.Class == "" && v.Sealed == 0 {
+			return m.ordered(v.Keys(), v.Get, true)
+		}
+		buf.WriteString(`{"$class":`)
+		writeString(buf, v.Class)
+		if v.Sealed > 0 {
+			fmt.Fprintf(buf, `,"$sealed":%d`, v.Sealed)
+		}
+		if v.Dynamic {
+			buf.WriteString(`,"$dynamic":true`)
+		}
+		buf.WriteString(`,"$members":`)
+		if err := m.ordered(v.Keys(), v.Get, false); err != nil {
+			return err
+		}
+		buf.WriteByte('}')
+	default:
+		return fmt.Errorf("type %T not supported", v)
+	}
+	return nil
+}
+
+func sortedKeys(v map[string]interface{}) []string {
+	keys := make([]string, 0, len(v))
+	for k := range v {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+func (m *marshaler) members(keys []string, v map[string]interface{}, escape bool) error {
+	return m.ordered(keys, func(key string) (interface{}, bool) {
+		value, ok := v[key]
+		return value, ok
+	}, escape)
+}
+
+func (m *marshaler) ordered(keys []string, get func(string) (interface{}, bool), escape bool) error {
+	buf := &m.buf
+	buf.WriteByte('{')
+	for i, key := range keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		if escape && strings.HasPrefix(key, "$") {
+			writeString(buf, "$"+key)
+		} else {
+			writeString(buf, key)
+		}
+		buf.WriteByte(':')
+		value, _ := get(key)
+		if err := m.marshal(value); err != nil {
+			return fmt.Errorf("%s: %s", key, err)
+		}
+	}
+	buf.WriteByte('}')
+	return nil
+}
+
+func writeString(buf *bytes.Buffer, s string) {
+	b, _ := json.Marshal(s)
+	buf.Write(b)
+}
+
+// Unmarshal parses JSON written by Marshal into the value it describes, ready
+// for amf.EncodeAMF0 or amf.EncodeAMF3. Objects keep their member order, and
+// references are to the same pointer or slice as their target.
+func Unmarshal(data []byte) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	p := &parser{dec: dec}
+	value, err := p.value()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, fmt.Errorf("trailing data after value")
+	}
+	for _, c := range p.refs {
+		p.resolve(c)
+	}
+	return value, nil
+}
+
+// parser keeps the containers in the order they start, for references to
+// them. References are stored as ref until the containers are complete.
+type parser struct {
+	dec  *json.Decoder
+	refs []interface{}
+}
+
+type ref int
+
+// reserve numbers a container before its items are parsed.
+func (p *parser) reserve() int {
+	p.refs = append(p.refs, nil)
+	return len(p.refs) - 1
+}
+
+// resolve replaces the references in the items and members of c.
+func (p *parser) resolve(c interface{}) {
+	switch c := c.(type) {
+	case []interface{}:
+		for i, item := range c {
+			if n, ok := item.(ref); ok {
+				c[i] = p.refs[n]
+			}
+		}
+	case *amf.OrderedObject:
+		p.resolveMembers(c.Keys(), c.Get, c.Set)
+	case *amf.OrderedECMAArray:
+		p.resolveMembers(c.Keys(), c.Get, c.Set)
+	}
+}
+
+func (p *parser) resolveMembers(keys []string, get func(string) (interface{}, bool), set func(string, interface{})) {
+	for _, key := range keys {
+		value, _ := get(key)
+		if n, ok := value.(ref); ok {
+			set(key, p.refs[n])
+		}
+	}
+}
+
+func (p *parser) value() (interface{}, error) {
+	tok, err := p.dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch tok := tok.(type) {
+	case nil, bool, string:
+		return tok, nil
+	case json.Number:
+		return number(tok)
+	case json.Delim:
+		if tok == '[' {
+			n := p.reserve()
+			// Empty arrays get a capacity too, giving them an identity.
+			result := make([]interface{}, 0, 1)
+			for p.dec.More() {
+				item, err := p.value()
+				if err != nil {
+					return nil, err
+				}
+				result = append(result, item)
+			}
+			p.refs[n] = result
+			_, err := p.dec.Token()
+			return result, err
+		}
+		return p.object()
+	}
+	return nil, fmt.Errorf("unexpected token %v", tok)
+}
+
+func number(n json.Number) (interface{}, error) {
+	if strings.ContainsAny(string(n), ".eE") {
+		return n.Float64()
+	}
+	i, err := strconv.ParseInt(string(n), 10, 64)
+	if err != nil {
+		return n.Float64()
+	}
+	if i < math.MinInt || i > math.MaxInt {
+		return float64(i), nil
+	}
+	return int(i), nil
+}
+
+// members reads object members up to the closing brace, after the opening
+// brace and any members already consumed by the caller.
+func (p *parser) members(set func(string, interface{})) error {
+	for p.dec.More() {
+		tok, err := p.dec.Token()
+		if err != nil {
+			return err
+		}
+		key := tok.(string)
+		value, err := p.value()
+		if err != nil {
+			return fmt.Errorf("%s: %s", key, err)
+		}
+		set(key, value)
+	}
+	_, err := p.dec.Token()
+	return err
+}
+
+func (p *parser) object() (interface{}, error) {
+	obj := &amf.OrderedObject{}
+	if !p.dec.More() {
+		p.refs = append(p.refs, obj)
+		_, err := p.dec.Token()
+		return obj, err
+	}
+	tok, err := p.dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	key := tok.(string)
+	switch key {
+	case "$undefined", "$date", "$bytes", "$double", "$ecma", "$ref":
+		result, err := p.tagged(key)
+		if err != nil {
+			return nil, err
+		}
+		if tok, err := p.dec.Token(); err != nil || tok != json.Delim('}') {
+			return nil, fmt.Errorf("%s: unexpected members", key)
+		}
+		return result, nil
+	case "$class":
+		return p.typedObject()
+	}
+	p.refs = append(p.refs, obj)
+	set := func(key string, value interface{}) {
+		if strings.HasPrefix(key, "$") {
+			key = key[1:]
+		}
+		obj.Set(key, value)
+	}
+	value, err := p.value()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", key, err)
+	}
+	set(key, value)
+	return obj, p.members(set)
+}
+
+func (p *parser) tagged(tag string) (interface{}, error) {
+	switch tag {
+	case "$ecma":
+		if tok, err := p.dec.Token(); err != nil || tok != json.Delim('{') {
+			return nil, fmt.Errorf("$ecma: object expected")
+		}
+		result := &amf.OrderedECMAArray{}
+		p.refs = append(p.refs, result)
+		return result, p.members(result.Set)
+	case "$bytes":
+		// Numbered before its string, like the other containers.
+		n := p.reserve()
+		b, err := p.tagString(tag)
+		if err != nil {
+			return nil, err
+		}
+		result, err := base64.StdEncoding.DecodeString(b)
+		p.refs[n] = result
+		return result, err
+	case "$ref":
+		value, err := p.value()
+		n, ok := value.(int)
+		if err != nil || !ok || n < 0 || n >= len(p.refs) {
+			return nil, fmt.Errorf("$ref: index of an earlier container expected")
+		}
+		return ref(n), nil
+	case "$undefined":
+		if _, err := p.value(); err != nil {
+			return nil, fmt.Errorf("%s: %s", tag, err)
+		}
+		return amf.Undefined{}, nil
+	}
+	s, err := p.tagString(tag)
+	if err != nil {
+		return nil, err
+	}
+	if tag == "$date" {
+		return time.Parse(time.RFC3339Nano, s)
+	}
+	return strconv.ParseFloat(s, 64)
+}
+
+func (p *parser) tagString(tag string) (string, error) {
+	value, err := p.value()
+	if err != nil {
+		return "", fmt.Errorf("%s: %s", tag, err)
+	}
+	s, ok := value.(string)
+	if !ok {
+		return "", fmt.Errorf("%s: string expected", tag)
+	}
+	return s, nil
+}
+
+func (p *parser) typedObject() (interface{}, error) {
+	tok, err := p.dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	class, ok := tok.(string)
+	if !ok {
+		return nil, fmt.Errorf("$class: string expected")
+	}
+	result := &amf.OrderedObject{Class: class}
+	p.refs = append(p.refs, result)
+	for p.dec.More() {
+		tok, err := p.dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		switch tok {
+		case "$sealed":
+			value, err := p.value()
+			n, ok := value.(int)
+			if err != nil || !ok {
+				return nil, fmt.Errorf("$sealed: integer expected")
+			}
+			result.Sealed = n
+		case "$dynamic":
+			value, err := p.value()
+			dynamic, ok := value.(bool)
+			if err != nil || !ok {
+				return nil, fmt.Errorf("$dynamic: boolean expected")
+			}
+			result.Dynamic = dynamic
+		case "$members":
+			if tok, err := p.dec.Token(); err != nil || tok != json.Delim('{') {
+				return nil, fmt.Errorf("$members: object expected")
+			}
+			if err := p.members(result.Set); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("unexpected member %v of typed object", tok)
+		}
+	}
+	_, err = p.dec.Token()
+	return result, err
+}
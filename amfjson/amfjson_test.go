@@ -0,0 +1,114 @@
+package amfjson
+
+import (
+	"bytes"
+	"testing"
+
+	amf "github.com/TatoExp/go-amf"
+)
+
+var jsonCases = []struct {
+	version amf.AMFVersion
+	in      []byte
+	json    string
+}{
+	{amf.AMF0, []byte{0x00, 0x3f, 0xf0, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}, `1.0`},
+	{amf.AMF0, []byte{0x06}, `{"$undefined":true}`},
+	{amf.AMF0, []byte{0x0b, 0x42, 0x3c, 0xbe, 0x99, 0x1a, 0x83, 0x00, 0x00, 0x00, 0x00}, `{"$date":"1973-11-29T21:33:09.123Z"}`},
+	{amf.AMF0, []byte{0x03,
+		0x00, 0x01, 0x62, 0x01, 0x01,
+		0x00, 0x05, 0x24, 0x64, 0x61, 0x74, 0x65, 0x05,
+		0x00, 0x00, 0x09}, `{"b":true,"$$date":null}`},
+	{amf.AMF0, []byte{0x08, 0x00, 0x00, 0x00, 0x02,
+		0x00, 0x01, 0x32, 0x02, 0x00, 0x01, 0x78,
+		0x00, 0x01, 0x31, 0x05,
+		0x00, 0x00, 0x09}, `{"$ecma":{"2":"x","1":null}}`},
+	{amf.AMF0, []byte{0x10, 0x00, 0x03, 0x46, 0x6f, 0x6f,
+		0x00, 0x01, 0x62, 0x01, 0x01,
+		0x00, 0x00, 0x09}, `{"$class":"Foo","$sealed":1,"$members":{"b":true}}`},
+	{amf.AMF0, []byte{0x0a, 0x00, 0x00, 0x00, 0x02,
+		0x02, 0x00, 0x01, 0x22,
+		0x00, 0x7f, 0xf8, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01}, `["\"",{"$double":"NaN"}]`},
+	{amf.AMF3, []byte{0x04, 0x01}, `1`},
+	{amf.AMF3, []byte{0x05, 0x3f, 0xf0, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}, `1.0`},
+	{amf.AMF3, []byte{0x05, 0x47, 0xef, 0xff, 0xff, 0xe0, 0x00, 0x00, 0x00}, `3.4028234663852886e+38`},
+	{amf.AMF3, []byte{0x00}, `{"$undefined":true}`},
+	{amf.AMF3, []byte{0x0c, 0x07, 0x01, 0x02, 0x03}, `{"$bytes":"AQID"}`},
+	{amf.AMF3, []byte{0x09, 0x01,
+		0x03, 0x7a, 0x04, 0x01,
+		0x01}, `{"$ecma":{"z":1}}`},
+	{amf.AMF3, []byte{0x0a, 0x2b, 0x07, 0x46, 0x6f, 0x6f,
+		0x03, 0x62, 0x03, 0x61,
+		0x04, 0x01,
+		0x06, 0x02,
+		0x03, 0x63, 0x02,
+		0x01}, `{"$class":"Foo","$sealed":2,"$dynamic":true,"$members":{"b":1,"a":"b","c":false}}`},
+	{amf.AMF3, []byte{0x0a, 0x13, 0x01, 0x03, 0x61, 0x04, 0x01}, `{"$class":"","$sealed":1,"$members":{"a":1}}`},
+	{amf.AMF3, []byte{0x08, 0x01, 0x3f, 0xf8, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}, `{"$date":"1970-01-01T00:00:00.001500000Z"}`},
+	{amf.AMF3, []byte{0x0a, 0x0b, 0x01,
+		0x03, 0x62, 0x09, 0x03, 0x01, 0x06, 0x03, 0x78,
+		0x03, 0x61, 0x0a, 0x01, 0x01,
+		0x01}, `{"b":["x"],"a":{}}`},
+	{amf.AMF0, []byte{0x03, 0x00, 0x04, 0x73, 0x65, 0x6c, 0x66, 0x07, 0x00, 0x00, 0x00, 0x00, 0x09}, `{"self":{"$ref":0}}`},
+	{amf.AMF0, []byte{0x0a, 0x00, 0x00, 0x00, 0x02,
+		0x03, 0x00, 0x01, 0x61, 0x00, 0x3f, 0xf0, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x09,
+		0x07, 0x00, 0x01}, `[{"a":1.0},{"$ref":1}]`},
+	{amf.AMF0, []byte{0x0a, 0x00, 0x00, 0x00, 0x02,
+		0x0a, 0x00, 0x00, 0x00, 0x00,
+		0x07, 0x00, 0x01}, `[[],{"$ref":1}]`},
+	{amf.AMF3, []byte{0x0a, 0x0b, 0x01, 0x03, 0x61, 0x0a, 0x00, 0x01}, `{"a":{"$ref":0}}`},
+	{amf.AMF3, []byte{0x09, 0x05, 0x01,
+		0x0a, 0x0b, 0x01, 0x03, 0x61, 0x04, 0x01, 0x01,
+		0x0a, 0x02}, `[{"a":1},{"$ref":1}]`},
+	{amf.AMF3, []byte{0x09, 0x07, 0x01,
+		0x0c, 0x03, 0x01,
+		0x09, 0x03, 0x01, 0x09, 0x00,
+		0x0c, 0x02}, `[{"$bytes":"AQ=="},[{"$ref":0}],{"$ref":1}]`},
+}
+
+func TestFromAMF(t *testing.T) {
+	for _, c := range jsonCases {
+		got, err := FromAMF(c.in, c.version)
+		if err != nil {
+			t.Errorf("FromAMF(%#v): %s", c.in, err)
+			continue
+		}
+		if string(got) != c.json {
+			t.Errorf("FromAMF(%#v) == %s, want %s", c.in, got, c.json)
+		}
+	}
+}
+
+func TestToAMF(t *testing.T) {
+	for _, c := range jsonCases {
+		got, err := ToAMF([]byte(c.json), c.version)
+		if err != nil {
+			t.Errorf("ToAMF(%s): %s", c.json, err)
+			continue
+		}
+		if !bytes.Equal(got, c.in) {
+			t.Errorf("ToAMF(%s) == %#v, want %#v", c.json, got, c.in)
+		}
+	}
+}
+
+func TestToAMFInvalid(t *testing.T) {
+	for _, in := range []string{
+		``,
+		`{"a":1`,
+		`1 2`,
+		`{"$date":1}`,
+		`{"$bytes":"!"}`,
+		`{"$ecma":[]}`,
+		`{"$date":"2000-01-01T00:00:00.000Z","x":1}`,
+		`{"$class":"Foo","x":1}`,
+		`{"$ref":0}`,
+		`[{"$ref":1}]`,
+		`[{"$ref":"0"}]`,
+		`[{"$ref":0,"x":1}]`,
+	} {
+		if got, err := ToAMF([]byte(in), amf.AMF3); err == nil {
+			t.Errorf("ToAMF(%s) == %#v, want error", in, got)
+		}
+	}
+}
@@ -0,0 +1,314 @@
+// Package asgen generates ActionScript 3 classes and TypeScript interfaces for
+// the Go structs registered with amf.RegisterClass, so that Flex and
+// TypeScript clients share the value objects of a Go service.
+//
+// The generator reflects over the registered types, so it runs in a program
+// that imports them, for example from go generate:
+//
+//	func main() {
+//		files, err := asgen.AS3(asgen.Options{Collection: amf.Vector})
+//		...
+//	}
+//
+// Members are named and typed as the amf package encodes them. Go types map
+// to:
+//
+//	Go                       ActionScript          TypeScript
+//	bool                     Boolean               boolean
+//	int8-int32, uint8-uint16 int                   number
+//	uint32                   uint                  number
+//	other numbers            Number                number
+//	string, TextMarshaler    String                string
+//	time.Time                Date                  Date
+//	[]byte                   ByteArray             Uint8Array
+//	slices and arrays        see Options           T[]
+//	maps, other structs      Object                object types
+//	registered structs       their class           their interface
+//	interface{}, Marshalers  *                     unknown
+package asgen
+
+import (
+	"bytes"
+	"encoding"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+
+	amf "github.com/TatoExp/go-amf"
+)
+
+// Options adjusts the generated code.
+type Options struct {
+	// Collection is the type of slices and arrays other than []byte and
+	// []interface{}, which is always Array. It should be that of the
+	// amf.Encoder writing the values: Array, Vector.<T> typed by the element,
+	// or mx.collections.ArrayCollection.
+	Collection amf.Collection
+}
+
+const header = "// Code generated by asgen. DO NOT EDIT.\n\n"
+
+type class struct {
+	alias string
+	pkg   string // of the alias
+	name  string
+	typ   reflect.Type
+}
+
+type generator struct {
+	opts    Options
+	classes []class
+	byType  map[reflect.Type]class
+	imports map[string]bool // of the class being written
+}
+
+func newGenerator(opts Options) (*generator, error) {
+	g := &generator{opts: opts, byType: make(map[reflect.Type]class)}
+	names := make(map[string]string)
+	for alias, t := range amf.RegisteredClasses() {
+		if t.Kind() == reflect.Pointer {
+			t = t.Elem()
+		}
+		c := class{alias: alias, typ: t}
+		c.name = alias
+		if i := strings.LastIndexByte(alias, '.'); i >= 0 {
+			c.pkg, c.name = alias[:i], alias[i+1:]
+		}
+		if other, ok := names[c.name]; ok {
+			return nil, fmt.Errorf("classes %s and %s have the same name", other, alias)
+		}
+		names[c.name] = alias
+		g.classes = append(g.classes, c)
+		g.byType[t] = c
+	}
+	slices.SortFunc(g.classes, func(a, b class) int { return strings.Compare(a.alias, b.alias) })
+	return g, nil
+}
+
+type member struct {
+	name      string
+	typ       reflect.Type
+	omitEmpty bool
+}
+
+// members lists the members of a struct as the amf package encodes them.
+func members(t reflect.Type) []member {
+	var result []member
+	for _, f := range amf.Fields(t) {
+		result = append(result, member{f.Name, t.Field(f.Index).Type, f.OmitEmpty})
+	}
+	return result
+}
+
+var (
+	timeType          = reflect.TypeFor[time.Time]()
+	textMarshalerType = reflect.TypeFor[encoding.TextMarshaler]()
+	amf0MarshalerType = reflect.TypeFor[amf.AMF0Marshaler]()
+	amf3MarshalerType = reflect.TypeFor[amf.AMF3Marshaler]()
+	interfacesType    = reflect.TypeFor[[]interface{}]()
+)
+
+func implements(t, iface reflect.Type) bool {
+	return t.Implements(iface) || t.Kind() != reflect.Pointer && reflect.PointerTo(t).Implements(iface)
+}
+
+// AS3 returns an ActionScript class with [RemoteClass] metadata per
+// registered class, by the path of its file, such as com/example/User.as.
+func AS3(opts Options) (map[string][]byte, error) {
+	g, err := newGenerator(opts)
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string][]byte)
+	for _, c := range g.classes {
+		g.imports = make(map[string]bool)
+		var body bytes.Buffer
+		fmt.Fprintf(&body, "\t[RemoteClass(alias=%q)]\n", c.alias)
+		fmt.Fprintf(&body, "\tpublic class %s {\n", c.name)
+		for _, m := range members(c.typ) {
+			fmt.Fprintf(&body, "\t\tpublic var %s:%s;\n", m.name, g.as3Type(m.typ, c))
+		}
+		body.WriteString("\t}\n")
+
+		var b bytes.Buffer
+		b.WriteString(header)
+		if c.pkg == "" {
+			b.WriteString("package {\n")
+		} else {
+			fmt.Fprintf(&b, "package %s {\n", c.pkg)
+		}
+		imports := make([]string, 0, len(g.imports))
+		for imp := range g.imports {
+			imports = append(imports, imp)
+		}
+		slices.Sort(imports)
+		for _, imp := range imports {
+			fmt.Fprintf(&b, "\timport %s;\n", imp)
+		}
+		if len(imports) > 0 {
+			b.WriteString("\n")
+		}
+		b.Write(body.Bytes())
+		b.WriteString("}\n")
+		files[strings.ReplaceAll(c.alias, ".", "/")+".as"] = b.Bytes()
+	}
+	return files, nil
+}
+
+// use returns name, importing it from pkg into the class from.
+func (g *generator) use(pkg, name string, from class) string {
+	if pkg != "" && pkg != from.pkg {
+		g.imports[pkg+"."+name] = true
+	}
+	return name
+}
+
+func (g *generator) as3Type(t reflect.Type, from class) string {
+	switch {
+	case t == timeType:
+		return "Date"
+	case implements(t, amf0MarshalerType) || implements(t, amf3MarshalerType):
+		return "*"
+	case implements(t, textMarshalerType):
+		return "String"
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return "Boolean"
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint8, reflect.Uint16:
+		return "int"
+	case reflect.Uint32:
+		return "uint"
+	case reflect.Int, reflect.Int64, reflect.Uint, reflect.Uint64, reflect.Uintptr, reflect.Float32, reflect.Float64:
+		return "Number"
+	case reflect.String:
+		return "String"
+	case reflect.Pointer:
+		return g.as3Type(t.Elem(), from)
+	case reflect.Slice, reflect.Array:
+		if t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8 {
+			return g.use("flash.utils", "ByteArray", from)
+		}
+		if t == interfacesType {
+			return "Array"
+		}
+		switch g.opts.Collection {
+		case amf.Vector:
+			return "Vector.<" + g.as3Type(t.Elem(), from) + ">"
+		case amf.ArrayCollection:
+			return g.use("mx.collections", "ArrayCollection", from)
+		}
+		return "Array"
+	case reflect.Struct:
+		if c, ok := g.byType[t]; ok {
+			return g.use(c.pkg, c.name, from)
+		}
+		return "Object"
+	case reflect.Map:
+		return "Object"
+	}
+	return "*"
+}
+
+// TypeScript returns a TypeScript module with an interface per registered
+// class.
+func TypeScript(opts Options) ([]byte, error) {
+	g, err := newGenerator(opts)
+	if err != nil {
+		return nil, err
+	}
+	var b bytes.Buffer
+	b.WriteString(header)
+	for i, c := range g.classes {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		fmt.Fprintf(&b, "/** The ActionScript class %s. */\n", c.alias)
+		fmt.Fprintf(&b, "export interface %s {\n", c.name)
+		for _, m := range members(c.typ) {
+			optional := ""
+			if m.omitEmpty {
+				optional = "?"
+			}
+			fmt.Fprintf(&b, "\t%s%s: %s;\n", tsName(m.name), optional, g.tsType(m.typ, make(map[reflect.Type]bool)))
+		}
+		b.WriteString("}\n")
+	}
+	return b.Bytes(), nil
+}
+
+// tsType returns the TypeScript type of t, writing structs that are not
+// registered inline unless they contain themselves.
+func (g *generator) tsType(t reflect.Type, seen map[reflect.Type]bool) string {
+	switch {
+	case t == timeType:
+		return "Date"
+	case implements(t, amf0MarshalerType) || implements(t, amf3MarshalerType):
+		return "unknown"
+	case implements(t, textMarshalerType):
+		return "string"
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.String:
+		return "string"
+	case reflect.Pointer:
+		return g.tsType(t.Elem(), seen) + " | null"
+	case reflect.Slice, reflect.Array:
+		if t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8 {
+			return "Uint8Array"
+		}
+		elem := g.tsType(t.Elem(), seen)
+		if strings.ContainsAny(elem, " |") && !strings.HasPrefix(elem, "{") {
+			elem = "(" + elem + ")"
+		}
+		return elem + "[]"
+	case reflect.Map:
+		if t.Key().Kind() != reflect.String {
+			return "unknown"
+		}
+		return "Record<string, " + g.tsType(t.Elem(), seen) + ">"
+	case reflect.Struct:
+		if c, ok := g.byType[t]; ok {
+			return c.name
+		}
+		if seen[t] {
+			return "object"
+		}
+		seen[t] = true
+		defer delete(seen, t)
+		var fields []string
+		for _, m := range members(t) {
+			optional := ""
+			if m.omitEmpty {
+				optional = "?"
+			}
+			fields = append(fields, tsName(m.name)+optional+": "+g.tsType(m.typ, seen))
+		}
+		if len(fields) == 0 {
+			return "{}"
+		}
+		return "{ " + strings.Join(fields, "; ") + " }"
+	}
+	return "unknown"
+}
+
+// tsName quotes member names that are not identifiers.
+func tsName(name string) string {
+	for i, r := range name {
+		if !(r == '_' || r == '$' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || i > 0 && r >= '0' && r <= '9') {
+			return fmt.Sprintf("%q", name)
+		}
+	}
+	if name == "" {
+		return `""`
+	}
+	return name
+}
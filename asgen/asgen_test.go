@@ -0,0 +1,143 @@
+package asgen
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	amf "github.com/TatoExp/go-amf"
+)
+
+type status int
+
+func (s status) MarshalText() ([]byte, error) {
+	return []byte(fmt.Sprint(int(s))), nil
+}
+
+type address struct {
+	City string `amf:"city"`
+	Zip  int16  `amf:"zip,omitempty"`
+}
+
+type user struct {
+	ID       int64              `amf:"id"`
+	Name     string             `amf:"name"`
+	Age      uint8              `amf:"age,omitempty"`
+	Flags    uint32             `amf:"flags"`
+	Created  time.Time          `amf:"created"`
+	Avatar   []byte             `amf:"avatar"`
+	Groups   []*group           `amf:"groups"`
+	Address  address            `amf:"address"`
+	Status   status             `amf:"status"`
+	Settings map[string]float64 `amf:"settings"`
+	Extra    interface{}        `amf:"extra"`
+	Secret   string             `amf:"-"`
+	Active   bool
+}
+
+type group struct {
+	Title string `amf:"title"`
+	Owner *user  `amf:"owner"`
+}
+
+func init() {
+	amf.RegisterClass("com.example.vo.User", &user{})
+	amf.RegisterClass("com.example.groups.Group", group{})
+}
+
+func TestAS3(t *testing.T) {
+	files, err := AS3(Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"com/example/vo/User.as": header + `package com.example.vo {
+	import flash.utils.ByteArray;
+
+	[RemoteClass(alias="com.example.vo.User")]
+	public class User {
+		public var id:Number;
+		public var name:String;
+		public var age:int;
+		public var flags:uint;
+		public var created:Date;
+		public var avatar:ByteArray;
+		public var groups:Array;
+		public var address:Object;
+		public var status:String;
+		public var settings:Object;
+		public var extra:*;
+		public var Active:Boolean;
+	}
+}
+`,
+		"com/example/groups/Group.as": header + `package com.example.groups {
+	import com.example.vo.User;
+
+	[RemoteClass(alias="com.example.groups.Group")]
+	public class Group {
+		public var title:String;
+		public var owner:User;
+	}
+}
+`,
+	}
+	if len(files) != len(want) {
+		t.Errorf("AS3 returned %d files, want %d", len(files), len(want))
+	}
+	for name, src := range want {
+		if got := string(files[name]); got != src {
+			t.Errorf("%s ==\n%s\nwant\n%s", name, got, src)
+		}
+	}
+
+	files, err = AS3(Options{Collection: amf.Vector})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if src := string(files["com/example/vo/User.as"]); !strings.Contains(src, "import com.example.groups.Group;") ||
+		!strings.Contains(src, "public var groups:Vector.<Group>;") {
+		t.Errorf("User.as with vectors ==\n%s", src)
+	}
+	files, err = AS3(Options{Collection: amf.ArrayCollection})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if src := string(files["com/example/vo/User.as"]); !strings.Contains(src, "import mx.collections.ArrayCollection;") ||
+		!strings.Contains(src, "public var groups:ArrayCollection;") {
+		t.Errorf("User.as with collections ==\n%s", src)
+	}
+}
+
+func TestTypeScript(t *testing.T) {
+	got, err := TypeScript(Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := header + `/** The ActionScript class com.example.groups.Group. */
+export interface Group {
+	title: string;
+	owner: User | null;
+}
+
+/** The ActionScript class com.example.vo.User. */
+export interface User {
+	id: number;
+	name: string;
+	age?: number;
+	flags: number;
+	created: Date;
+	avatar: Uint8Array;
+	groups: (Group | null)[];
+	address: { city: string; zip?: number };
+	status: string;
+	settings: Record<string, number>;
+	extra: unknown;
+	Active: boolean;
+}
+`
+	if string(got) != want {
+		t.Errorf("TypeScript ==\n%s\nwant\n%s", got, want)
+	}
+}
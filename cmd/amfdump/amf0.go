@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/binary"
+	"math"
+	"strconv"
+	"time"
+)
+
+// amf0Walker builds the tree of AMF0 values. Like amf.Decoder it numbers
+// objects and arrays as they appear so that references can be shown.
+type amf0Walker struct {
+	objects int
+}
+
+func readUTF8(v []byte, offset int) (string, int, error) {
+	if offset+2 > len(v) {
+		return "", 0, errAt(offset, "EOF")
+	}
+	end := offset + 2 + int(binary.BigEndian.Uint16(v[offset:]))
+	if end > len(v) {
+		return "", 0, errAt(offset, "EOF")
+	}
+	return string(v[offset+2 : end]), end, nil
+}
+
+func need(v []byte, offset, n int) error {
+	if offset+n > len(v) {
+		return errAt(offset, "EOF")
+	}
+	return nil
+}
+
+// value returns the node of the value at offset. On error the node holds
+// what was read so far.
+func (w *amf0Walker) value(v []byte, offset int) (*node, error) {
+	n := newNode(v, offset)
+	end, err := w.body(v, offset, n)
+	n.Length = end - offset
+	return n, err
+}
+
+func (w *amf0Walker) body(v []byte, offset int, n *node) (int, error) {
+	if offset >= len(v) {
+		n.Type = "?"
+		return offset, errAt(offset, "EOF")
+	}
+	p := offset + 1
+	switch v[offset] {
+	case 0x00:
+		n.Type = "number"
+		if err := need(v, p, 8); err != nil {
+			return p, err
+		}
+		n.Value = math.Float64frombits(binary.BigEndian.Uint64(v[p:]))
+		return p + 8, nil
+	case 0x01:
+		n.Type = "boolean"
+		if err := need(v, p, 1); err != nil {
+			return p, err
+		}
+		n.Value = v[p] != 0
+		return p + 1, nil
+	case 0x02:
+		n.Type = "string"
+		s, end, err := readUTF8(v, p)
+		if err != nil {
+			return p, err
+		}
+		n.Value = s
+		return end, nil
+	case 0x0c, 0x0f:
+		n.Type = "long string"
+		if v[offset] == 0x0f {
+			n.Type = "xml document"
+		}
+		if err := need(v, p, 4); err != nil {
+			return p, err
+		}
+		size := int(binary.BigEndian.Uint32(v[p:]))
+		if err := need(v, p+4, size); err != nil {
+			return p + 4, err
+		}
+		n.Value = string(v[p+4 : p+4+size])
+		return p + 4 + size, nil
+	case 0x03:
+		n.Type = "object"
+		n.Index = intp(w.objects)
+		w.objects++
+		return w.properties(v, p, n)
+	case 0x10:
+		n.Type = "typed object"
+		class, end, err := readUTF8(v, p)
+		if err != nil {
+			return p, err
+		}
+		n.Class = class
+		n.Index = intp(w.objects)
+		w.objects++
+		return w.properties(v, end, n)
+	case 0x05:
+		n.Type = "null"
+		return p, nil
+	case 0x06:
+		n.Type = "undefined"
+		return p, nil
+	case 0x07:
+		n.Type = "reference"
+		if err := need(v, p, 2); err != nil {
+			return p, err
+		}
+		ref := int(binary.BigEndian.Uint16(v[p:]))
+		n.Ref = intp(ref)
+		if ref >= w.objects {
+			return p + 2, errAt(p, "invalid reference %d", ref)
+		}
+		return p + 2, nil
+	case 0x08:
+		n.Type = "ecma array"
+		if err := need(v, p, 4); err != nil {
+			return p, err
+		}
+		n.Count = intp(int(binary.BigEndian.Uint32(v[p:])))
+		n.Index = intp(w.objects)
+		w.objects++
+		return w.properties(v, p+4, n)
+	case 0x0a:
+		n.Type = "strict array"
+		if err := need(v, p, 4); err != nil {
+			return p, err
+		}
+		count := int(binary.BigEndian.Uint32(v[p:]))
+		n.Count = intp(count)
+		n.Index = intp(w.objects)
+		w.objects++
+		p += 4
+		if count > len(v)-p {
+			return p, errAt(p, "EOF")
+		}
+		for i := 0; i < count; i++ {
+			child, err := w.value(v, p)
+			child.Name = "[" + strconv.Itoa(i) + "]"
+			n.Children = append(n.Children, child)
+			p += child.Length
+			if err != nil {
+				return p, err
+			}
+		}
+		return p, nil
+	case 0x0b:
+		n.Type = "date"
+		if err := need(v, p, 10); err != nil {
+			return p, err
+		}
+		n.Value = formatDate(math.Float64frombits(binary.BigEndian.Uint64(v[p:])))
+		if tz := int16(binary.BigEndian.Uint16(v[p+8:])); tz != 0 {
+			n.Detail = "tz=" + strconv.Itoa(int(tz))
+		}
+		return p + 10, nil
+	case 0x11:
+		n.Type = "avmplus"
+		child, err := (&amf3Walker{}).value(v, p)
+		n.Children = append(n.Children, child)
+		return p + child.Length, err
+	}
+	n.Type = "?"
+	return p, errAt(offset, "unsupported marker 0x%02x", v[offset])
+}
+
+// properties reads members up to the empty key and object end marker.
+func (w *amf0Walker) properties(v []byte, offset int, n *node) (int, error) {
+	for {
+		key, end, err := readUTF8(v, offset)
+		if err != nil {
+			return offset, err
+		}
+		if key == "" {
+			if end >= len(v) || v[end] != 0x09 {
+				return end, errAt(end, "invalid end of object")
+			}
+			return end + 1, nil
+		}
+		child, err := w.value(v, end)
+		child.Name = key
+		n.Children = append(n.Children, child)
+		offset = end + child.Length
+		if err != nil {
+			return offset, err
+		}
+	}
+}
+
+func formatDate(ms float64) interface{} {
+	if math.IsNaN(ms) || math.IsInf(ms, 0) {
+		return ms
+	}
+	return time.UnixMilli(int64(ms)).UTC().Format("2006-01-02T15:04:05.000Z07:00")
+}
@@ -0,0 +1,395 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+type traits3 struct {
+	class          string
+	sealed         []string
+	dynamic        bool
+	externalizable bool
+}
+
+// amf3Walker builds the tree of AMF3 values, keeping the string, object and
+// traits reference tables.
+type amf3Walker struct {
+	strings []string
+	objects int
+	traits  []traits3
+}
+
+func readU29(v []byte, offset int) (int, int, error) {
+	n := 0
+	for i := 0; i < 4; i++ {
+		if offset >= len(v) {
+			return 0, offset, errAt(offset, "EOF")
+		}
+		b := int(v[offset])
+		offset++
+		if i == 3 {
+			return n<<8 | b, offset, nil
+		}
+		n = n<<7 | b&0x7f
+		if b&0x80 == 0 {
+			break
+		}
+	}
+	return n, offset, nil
+}
+
+// str reads a UTF-8-vr string. ref is -1 for inline strings.
+func (w *amf3Walker) str(v []byte, offset int) (string, int, int, error) {
+	ref, p, err := readU29(v, offset)
+	if err != nil {
+		return "", -1, p, err
+	}
+	if ref&1 == 0 {
+		ref >>= 1
+		if ref >= len(w.strings) {
+			return "", ref, p, errAt(offset, "invalid string ref %d", ref)
+		}
+		return w.strings[ref], ref, p, nil
+	}
+	size := ref >> 1
+	if err := need(v, p, size); err != nil {
+		return "", -1, p, err
+	}
+	s := string(v[p : p+size])
+	if s != "" {
+		w.strings = append(w.strings, s)
+	}
+	return s, -1, p + size, nil
+}
+
+func (w *amf3Walker) value(v []byte, offset int) (*node, error) {
+	n := newNode(v, offset)
+	end, err := w.body(v, offset, n)
+	n.Length = end - offset
+	return n, err
+}
+
+// header reads the U29 that starts objects, arrays and other referenceable
+// values. It returns ok false after recording a reference.
+func (w *amf3Walker) header(v []byte, offset int, n *node) (int, int, bool, error) {
+	ref, p, err := readU29(v, offset)
+	if err != nil {
+		return 0, p, false, err
+	}
+	if ref&1 == 0 {
+		n.Ref = intp(ref >> 1)
+		if ref>>1 >= w.objects {
+			return 0, p, false, errAt(offset, "invalid object ref %d", ref>>1)
+		}
+		return 0, p, false, nil
+	}
+	n.Index = intp(w.objects)
+	w.objects++
+	return ref >> 1, p, true, nil
+}
+
+func (w *amf3Walker) body(v []byte, offset int, n *node) (int, error) {
+	if offset >= len(v) {
+		n.Type = "?"
+		return offset, errAt(offset, "EOF")
+	}
+	p := offset + 1
+	switch v[offset] {
+	case 0x00:
+		n.Type = "undefined"
+		return p, nil
+	case 0x01:
+		n.Type = "null"
+		return p, nil
+	case 0x02, 0x03:
+		n.Type = "boolean"
+		n.Value = v[offset] == 0x03
+		return p, nil
+	case 0x04:
+		n.Type = "integer"
+		i, end, err := readU29(v, p)
+		if err != nil {
+			return end, err
+		}
+		if i&0x10000000 != 0 {
+			i -= 0x20000000
+		}
+		n.Value = i
+		return end, nil
+	case 0x05:
+		n.Type = "double"
+		if err := need(v, p, 8); err != nil {
+			return p, err
+		}
+		n.Value = math.Float64frombits(binary.BigEndian.Uint64(v[p:]))
+		return p + 8, nil
+	case 0x06:
+		n.Type = "string"
+		s, ref, end, err := w.str(v, p)
+		if ref >= 0 {
+			n.Ref = intp(ref)
+		}
+		if err != nil {
+			return end, err
+		}
+		n.Value = s
+		return end, nil
+	case 0x07, 0x0b:
+		n.Type = "xml"
+		if v[offset] == 0x07 {
+			n.Type = "xml document"
+		}
+		size, end, ok, err := w.header(v, p, n)
+		if !ok {
+			return end, err
+		}
+		if err := need(v, end, size); err != nil {
+			return end, err
+		}
+		n.Value = string(v[end : end+size])
+		return end + size, nil
+	case 0x08:
+		n.Type = "date"
+		_, end, ok, err := w.header(v, p, n)
+		if !ok {
+			return end, err
+		}
+		if err := need(v, end, 8); err != nil {
+			return end, err
+		}
+		n.Value = formatDate(math.Float64frombits(binary.BigEndian.Uint64(v[end:])))
+		return end + 8, nil
+	case 0x09:
+		n.Type = "array"
+		return w.array(v, p, n)
+	case 0x0a:
+		n.Type = "object"
+		return w.object(v, p, n)
+	case 0x0c:
+		n.Type = "byte array"
+		size, end, ok, err := w.header(v, p, n)
+		if !ok {
+			return end, err
+		}
+		if err := need(v, end, size); err != nil {
+			return end, err
+		}
+		n.Value = v[end : end+size]
+		return end + size, nil
+	case 0x0d, 0x0e, 0x0f, 0x10:
+		return w.vector(v, offset, n)
+	case 0x11:
+		n.Type = "dictionary"
+		return w.dictionary(v, p, n)
+	}
+	n.Type = "?"
+	return p, errAt(offset, "unsupported marker 0x%02x", v[offset])
+}
+
+func (w *amf3Walker) array(v []byte, offset int, n *node) (int, error) {
+	count, p, ok, err := w.header(v, offset, n)
+	if !ok {
+		return p, err
+	}
+	n.Count = intp(count)
+	for {
+		key, _, end, err := w.str(v, p)
+		if err != nil {
+			return end, err
+		}
+		p = end
+		if key == "" {
+			break
+		}
+		child, err := w.value(v, p)
+		child.Name = key
+		n.Children = append(n.Children, child)
+		p += child.Length
+		if err != nil {
+			return p, err
+		}
+	}
+	return w.items(v, p, count, n)
+}
+
+func (w *amf3Walker) items(v []byte, offset, count int, n *node) (int, error) {
+	if count > len(v)-offset {
+		return offset, errAt(offset, "EOF")
+	}
+	for i := 0; i < count; i++ {
+		child, err := w.value(v, offset)
+		child.Name = "[" + strconv.Itoa(i) + "]"
+		n.Children = append(n.Children, child)
+		offset += child.Length
+		if err != nil {
+			return offset, err
+		}
+	}
+	return offset, nil
+}
+
+func (w *amf3Walker) object(v []byte, offset int, n *node) (int, error) {
+	ref, p, err := readU29(v, offset)
+	if err != nil {
+		return p, err
+	}
+	if ref&1 == 0 {
+		n.Ref = intp(ref >> 1)
+		if ref>>1 >= w.objects {
+			return p, errAt(offset, "invalid object ref %d", ref>>1)
+		}
+		return p, nil
+	}
+	var t traits3
+	var details []string
+	if ref&2 == 0 {
+		if ref>>2 >= len(w.traits) {
+			return p, errAt(offset, "invalid traits ref %d", ref>>2)
+		}
+		t = w.traits[ref>>2]
+		details = append(details, fmt.Sprintf("traits=#%d", ref>>2))
+	} else {
+		t.dynamic = ref&8 != 0
+		t.externalizable = ref&4 != 0
+		class, _, end, err := w.str(v, p)
+		if err != nil {
+			return end, err
+		}
+		t.class = class
+		p = end
+		if !t.externalizable {
+			for i := 0; i < ref>>4; i++ {
+				name, _, end, err := w.str(v, p)
+				if err != nil {
+					return end, err
+				}
+				t.sealed = append(t.sealed, name)
+				p = end
+			}
+		}
+		w.traits = append(w.traits, t)
+	}
+	n.Class = t.class
+	n.Index = intp(w.objects)
+	w.objects++
+	if t.externalizable {
+		n.Detail = strings.Join(append(details, "externalizable"), " ")
+		if t.class != "flex.messaging.io.ArrayCollection" {
+			return p, errAt(offset, "externalizable class %s not supported", t.class)
+		}
+		// An ArrayCollection writes the array it wraps.
+		child, err := w.value(v, p)
+		child.Name = "source"
+		n.Children = append(n.Children, child)
+		return p + child.Length, err
+	}
+	details = append(details, fmt.Sprintf("sealed=%d", len(t.sealed)))
+	if t.dynamic {
+		details = append(details, "dynamic")
+	}
+	n.Detail = strings.Join(details, " ")
+	for _, name := range t.sealed {
+		child, err := w.value(v, p)
+		child.Name = name
+		n.Children = append(n.Children, child)
+		p += child.Length
+		if err != nil {
+			return p, err
+		}
+	}
+	for t.dynamic {
+		key, _, end, err := w.str(v, p)
+		if err != nil {
+			return end, err
+		}
+		p = end
+		if key == "" {
+			break
+		}
+		child, err := w.value(v, p)
+		child.Name = key
+		n.Children = append(n.Children, child)
+		p += child.Length
+		if err != nil {
+			return p, err
+		}
+	}
+	return p, nil
+}
+
+func (w *amf3Walker) vector(v []byte, offset int, n *node) (int, error) {
+	kind := v[offset]
+	n.Type = [...]string{"vector<int>", "vector<uint>", "vector<double>", "vector<object>"}[kind-0x0d]
+	count, p, ok, err := w.header(v, offset+1, n)
+	if !ok {
+		return p, err
+	}
+	n.Count = intp(count)
+	if err := need(v, p, 1); err != nil {
+		return p, err
+	}
+	if v[p] != 0 {
+		n.Detail = "fixed"
+	}
+	p++
+	if kind == 0x10 {
+		class, _, end, err := w.str(v, p)
+		if err != nil {
+			return end, err
+		}
+		n.Class = class
+		return w.items(v, end, count, n)
+	}
+	size := 4
+	if kind == 0x0f {
+		size = 8
+	}
+	if count > (len(v)-p)/size {
+		return p, errAt(p, "EOF")
+	}
+	for i := 0; i < count; i++ {
+		child := &node{Offset: p, Length: size, Name: "[" + strconv.Itoa(i) + "]"}
+		switch kind {
+		case 0x0d:
+			child.Type, child.Value = "int", int32(binary.BigEndian.Uint32(v[p:]))
+		case 0x0e:
+			child.Type, child.Value = "uint", binary.BigEndian.Uint32(v[p:])
+		default:
+			child.Type, child.Value = "double", math.Float64frombits(binary.BigEndian.Uint64(v[p:]))
+		}
+		n.Children = append(n.Children, child)
+		p += size
+	}
+	return p, nil
+}
+
+func (w *amf3Walker) dictionary(v []byte, offset int, n *node) (int, error) {
+	count, p, ok, err := w.header(v, offset, n)
+	if !ok {
+		return p, err
+	}
+	n.Count = intp(count)
+	if err := need(v, p, 1); err != nil {
+		return p, err
+	}
+	if v[p] != 0 {
+		n.Detail = "weak keys"
+	}
+	p++
+	for i := 0; i < count; i++ {
+		for _, name := range []string{"key", "value"} {
+			child, err := w.value(v, p)
+			child.Name = fmt.Sprintf("[%d] %s", i, name)
+			n.Children = append(n.Children, child)
+			p += child.Length
+			if err != nil {
+				return p, err
+			}
+		}
+	}
+	return p, nil
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+var kinds = []string{"amf0", "amf3", "remoting", "flv", "sol"}
+
+// detect guesses the container type of data. Remoting packets and raw AMF0
+// are only chosen if they parse to the end.
+func detect(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, []byte("FLV")) || len(data) > 0 && data[0] == 0x12:
+		return "flv"
+	case bytes.HasPrefix(data, []byte{0x00, 0xbf}):
+		return "sol"
+	}
+	if _, err := dumpRemoting(data); err == nil {
+		return "remoting"
+	}
+	if _, err := dumpValues(data, "amf0"); err == nil {
+		return "amf0"
+	}
+	return "amf3"
+}
+
+// dump returns the tree of data read as kind. On error the tree holds what
+// was read so far.
+func dump(data []byte, kind string) (*node, error) {
+	switch kind {
+	case "amf0", "amf3":
+		return dumpValues(data, kind)
+	case "remoting":
+		return dumpRemoting(data)
+	case "flv":
+		return dumpFLV(data)
+	case "sol":
+		return dumpSOL(data)
+	}
+	return nil, fmt.Errorf("unknown type %s", kind)
+}
+
+// values adds the consecutive values in v[offset:end] to n.
+func values(v []byte, offset, end int, value func([]byte, int) (*node, error), n *node) error {
+	for offset < end {
+		child, err := value(v[:end], offset)
+		n.Children = append(n.Children, child)
+		offset += child.Length
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func dumpValues(data []byte, kind string) (*node, error) {
+	root := &node{Type: kind, Length: len(data)}
+	if kind == "amf0" {
+		return root, values(data, 0, len(data), (&amf0Walker{}).value, root)
+	}
+	return root, values(data, 0, len(data), (&amf3Walker{}).value, root)
+}
+
+// dumpRemoting reads an AMF remoting packet: a version, headers and messages,
+// each with an AMF0 value of its own.
+func dumpRemoting(v []byte) (*node, error) {
+	root := &node{Type: "remoting", Length: len(v)}
+	if err := need(v, 0, 4); err != nil {
+		return root, err
+	}
+	version := binary.BigEndian.Uint16(v)
+	if version != 0 && version != 3 {
+		return root, errAt(0, "invalid version %d", version)
+	}
+	root.Detail = fmt.Sprintf("version=%d", version)
+	count := int(binary.BigEndian.Uint16(v[2:]))
+	offset := 4
+	for i := 0; i < count; i++ {
+		n := &node{Offset: offset, Type: "header"}
+		root.Children = append(root.Children, n)
+		name, end, err := readUTF8(v, offset)
+		if err != nil {
+			return root, err
+		}
+		n.Name = name
+		if err := need(v, end, 5); err != nil {
+			return root, err
+		}
+		if v[end] != 0 {
+			n.Detail = "must understand"
+		}
+		offset, err = remotingValue(v, end+1, n)
+		if err != nil {
+			return root, err
+		}
+	}
+	if err := need(v, offset, 2); err != nil {
+		return root, err
+	}
+	count = int(binary.BigEndian.Uint16(v[offset:]))
+	offset += 2
+	for i := 0; i < count; i++ {
+		n := &node{Offset: offset, Type: "message"}
+		root.Children = append(root.Children, n)
+		target, end, err := readUTF8(v, offset)
+		if err != nil {
+			return root, err
+		}
+		response, end, err := readUTF8(v, end)
+		if err != nil {
+			return root, err
+		}
+		n.Name = target
+		n.Detail = "response=" + response
+		if err := need(v, end, 4); err != nil {
+			return root, err
+		}
+		offset, err = remotingValue(v, end, n)
+		if err != nil {
+			return root, err
+		}
+	}
+	if offset != len(v) {
+		return root, errAt(offset, "trailing data")
+	}
+	return root, nil
+}
+
+// remotingValue reads the length prefixed value of a header or message. The
+// length may be -1 when unknown.
+func remotingValue(v []byte, offset int, n *node) (int, error) {
+	size := int(int32(binary.BigEndian.Uint32(v[offset:])))
+	offset += 4
+	child, err := (&amf0Walker{}).value(v, offset)
+	n.Children = append(n.Children, child)
+	offset += child.Length
+	n.Length = offset - n.Offset
+	if err != nil {
+		return offset, err
+	}
+	if size != -1 && size != child.Length {
+		return offset, errAt(offset, "length %d, have %d", size, child.Length)
+	}
+	return offset, nil
+}
+
+// dumpFLV reads an FLV file, or script tags without the file header, and
+// expands the AMF0 values of the script tags.
+func dumpFLV(v []byte) (*node, error) {
+	root := &node{Type: "flv", Length: len(v)}
+	offset := 0
+	if bytes.HasPrefix(v, []byte("FLV")) {
+		if err := need(v, 0, 13); err != nil {
+			return root, err
+		}
+		root.Detail = fmt.Sprintf("version=%d flags=0x%02x", v[3], v[4])
+		offset = int(binary.BigEndian.Uint32(v[5:])) + 4
+	}
+	for offset < len(v) {
+		if err := need(v, offset, 11); err != nil {
+			return root, err
+		}
+		size := int(v[offset+1])<<16 | int(binary.BigEndian.Uint16(v[offset+2:]))
+		timestamp := int(v[offset+7])<<24 | int(v[offset+4])<<16 | int(binary.BigEndian.Uint16(v[offset+5:]))
+		n := &node{Offset: offset, Length: 11 + size, Marker: fmt.Sprintf("0x%02x", v[offset]), Detail: fmt.Sprintf("timestamp=%d", timestamp)}
+		root.Children = append(root.Children, n)
+		if err := need(v, offset+11, size); err != nil {
+			return root, err
+		}
+		switch v[offset] & 0x1f {
+		case 8:
+			n.Type = "audio"
+		case 9:
+			n.Type = "video"
+		case 18:
+			n.Type = "script"
+			if err := values(v, offset+11, offset+11+size, (&amf0Walker{}).value, n); err != nil {
+				return root, err
+			}
+		default:
+			n.Type = "tag"
+		}
+		offset += 11 + size
+		if offset+4 <= len(v) && int(binary.BigEndian.Uint32(v[offset:])) == 11+size {
+			offset += 4
+		}
+	}
+	return root, nil
+}
+
+// dumpSOL reads a Local Shared Object file. Entries are named values followed
+// by a zero byte. In AMF3 files all entries share the reference tables.
+func dumpSOL(v []byte) (*node, error) {
+	root := &node{Type: "sol", Length: len(v)}
+	if err := need(v, 0, 16); err != nil {
+		return root, err
+	}
+	if size := int(binary.BigEndian.Uint32(v[2:])); size != len(v)-6 {
+		return root, errAt(2, "length %d, have %d", size, len(v)-6)
+	}
+	if !bytes.Equal(v[6:10], []byte("TCSO")) {
+		return root, errAt(6, "invalid signature")
+	}
+	name, offset, err := readUTF8(v, 16)
+	if err != nil {
+		return root, err
+	}
+	if err := need(v, offset, 4); err != nil {
+		return root, err
+	}
+	version := binary.BigEndian.Uint32(v[offset:])
+	root.Name = name
+	root.Detail = fmt.Sprintf("version=%d", version)
+	offset += 4
+	w3 := &amf3Walker{}
+	for offset < len(v) {
+		var key string
+		var err error
+		if version == 3 {
+			key, _, offset, err = w3.str(v, offset)
+		} else {
+			key, offset, err = readUTF8(v, offset)
+		}
+		if err != nil {
+			return root, err
+		}
+		var child *node
+		if version == 3 {
+			child, err = w3.value(v, offset)
+		} else {
+			child, err = (&amf0Walker{}).value(v, offset)
+		}
+		child.Name = key
+		root.Children = append(root.Children, child)
+		offset += child.Length
+		if err != nil {
+			return root, err
+		}
+		if offset >= len(v) || v[offset] != 0x00 {
+			return root, errAt(offset, "invalid end of entry")
+		}
+		offset++
+	}
+	return root, nil
+}
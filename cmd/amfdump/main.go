@@ -0,0 +1,146 @@
+// Command amfdump prints the structure of AMF data as an annotated tree with
+// byte offsets, markers, lengths and references.
+//
+// Usage:
+//
+//	amfdump [-format text|json] [-type auto|amf0|amf3|remoting|flv|sol] [file]
+//	amfdump diff [-type auto|amf0|amf3] file1 file2
+//
+// The input is read from file, or from stdin if no file is given, and may be
+// raw bytes or hex digits. With -type auto the container type is guessed from
+// the data.
+//
+// The diff command lists the differences between the first values of two
+// files, one per line. It exits with status 1 if there are any and 2 if the
+// files can't be read or decoded.
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+
+	amf "github.com/TatoExp/go-amf"
+)
+
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "diff" {
+		diff(os.Args[2:])
+		return
+	}
+	format := flag.String("format", "text", "output `format`: text or json")
+	kind := flag.String("type", "auto", "input `type`: auto, amf0, amf3, remoting, flv or sol")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: amfdump [flags] [file]\n       amfdump diff [-type type] file1 file2\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() > 1 || *format != "text" && *format != "json" || *kind != "auto" && !slices.Contains(kinds, *kind) {
+		flag.Usage()
+		os.Exit(2)
+	}
+	data, err := readInput(flag.Arg(0))
+	if err != nil {
+		fatal(err)
+	}
+	if *kind == "auto" {
+		*kind = detect(data)
+	}
+	root, err := dump(data, *kind)
+	if *format == "json" {
+		jsonValue(root)
+		out, _ := json.MarshalIndent(root, "", "  ")
+		os.Stdout.Write(append(out, '\n'))
+	} else {
+		writeText(os.Stdout, root, 0)
+	}
+	if err != nil {
+		fatal(err)
+	}
+}
+
+func diff(args []string) {
+	flags := flag.NewFlagSet("diff", flag.ExitOnError)
+	kind := flags.String("type", "auto", "input `type`: auto, amf0 or amf3")
+	flags.Parse(args)
+	if flags.NArg() != 2 || *kind != "auto" && *kind != "amf0" && *kind != "amf3" {
+		flags.Usage()
+		os.Exit(2)
+	}
+	diffs, err := diffFiles(flags.Arg(0), flags.Arg(1), *kind)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "amfdump: %s\n", err)
+		os.Exit(2)
+	}
+	for _, d := range diffs {
+		fmt.Println(d)
+	}
+	if len(diffs) > 0 {
+		os.Exit(1)
+	}
+}
+
+func diffFiles(name1, name2, kind string) ([]amf.Difference, error) {
+	a, err := readInput(name1)
+	if err != nil {
+		return nil, err
+	}
+	b, err := readInput(name2)
+	if err != nil {
+		return nil, err
+	}
+	if kind == "auto" {
+		kind = detect(a)
+	}
+	version := amf.AMF0
+	switch kind {
+	case "amf0":
+	case "amf3":
+		version = amf.AMF3
+	default:
+		return nil, fmt.Errorf("diff of %s is not supported", kind)
+	}
+	return amf.Diff(a, b, version)
+}
+
+// readInput reads the named file or stdin, decoding it if it only holds hex
+// digits and white space.
+func readInput(name string) ([]byte, error) {
+	var data []byte
+	var err error
+	if name == "" || name == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if digits, ok := hexDigits(data); ok {
+		return hex.DecodeString(string(digits))
+	}
+	return data, nil
+}
+
+func hexDigits(data []byte) ([]byte, bool) {
+	digits := make([]byte, 0, len(data))
+	for _, c := range data {
+		switch {
+		case c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F':
+			digits = append(digits, c)
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+		default:
+			return nil, false
+		}
+	}
+	return digits, len(digits) > 0 && len(digits)%2 == 0
+}
+
+func fatal(err error) {
+	fmt.Fprintf(os.Stderr, "amfdump: %s\n", err)
+	os.Exit(1)
+}
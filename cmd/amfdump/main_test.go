@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var detectCases = []struct {
+	in   []byte
+	want string
+}{
+	{[]byte{0x02, 0x00, 0x03, 'f', 'o', 'o'}, "amf0"},
+	{[]byte{0x06, 0x07, 'f', 'o', 'o'}, "amf3"},
+	{[]byte{0x04, 0x01}, "amf3"},
+	{[]byte{0x00, 0x03, 0x00, 0x00, 0x00, 0x01, 0x00, 0x01, 'a', 0x00, 0x02, '/', '1', 0xff, 0xff, 0xff, 0xff, 0x05}, "remoting"},
+	{[]byte{'F', 'L', 'V', 0x01, 0x05, 0x00, 0x00, 0x00, 0x09, 0x00, 0x00, 0x00, 0x00}, "flv"},
+	{[]byte{0x00, 0xbf, 0x00, 0x00, 0x00, 0x00}, "sol"},
+}
+
+func TestDetect(t *testing.T) {
+	for _, c := range detectCases {
+		if got := detect(c.in); got != c.want {
+			t.Errorf("detect(%#v) == %s, want %s", c.in, got, c.want)
+		}
+	}
+}
+
+var dumpCases = []struct {
+	kind string
+	in   []byte
+	want string
+}{
+	{"amf0", []byte{
+		0x03,
+		0x00, 0x01, 'a', 0x00, 0x3f, 0xf0, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x01, 'b', 0x07, 0x00, 0x00,
+		0x00, 0x00, 0x09,
+	}, `
+00000000  amf0  [len=22]
+00000000    object  [0x03 len=22 #0]
+00000004      a: number = 1  [0x00 len=9]
+00000010      b: reference  [0x07 len=3 ref=#0]
+`},
+	{"amf3", []byte{
+		0x0a, 0x13, 0x07, 'F', 'o', 'o', 0x03, 'a',
+		0x06, 0x02,
+		0x0a, 0x01, 0x06, 0x00,
+	}, `
+00000000  amf3  [len=14]
+00000000    object <Foo>  [0x0a len=10 #0 sealed=1]
+00000008      a: string = "a"  [0x06 len=2 ref=#1]
+0000000a    object <Foo>  [0x0a len=4 #1 traits=#0 sealed=1]
+0000000c      a: string = "Foo"  [0x06 len=2 ref=#0]
+`},
+	{"amf3", append(append([]byte{0x0a, 0x07, 0x43}, "flex.messaging.io.ArrayCollection"...), 0x09, 0x03, 0x01, 0x04, 0x01), `
+00000000  amf3  [len=41]
+00000000    object <flex.messaging.io.ArrayCollection>  [0x0a len=41 #0 externalizable]
+00000024      source: array  [0x09 len=5 count=1 #1]
+00000027        [0]: integer = 1  [0x04 len=2]
+`},
+	{"remoting", []byte{
+		0x00, 0x00,
+		0x00, 0x00,
+		0x00, 0x01,
+		0x00, 0x01, 'a', 0x00, 0x02, '/', '1', 0x00, 0x00, 0x00, 0x01, 0x05,
+	}, `
+00000000  remoting  [len=18 version=0]
+00000006    a: message  [len=12 response=/1]
+00000011      null  [0x05 len=1]
+`},
+}
+
+func TestDump(t *testing.T) {
+	for _, c := range dumpCases {
+		root, err := dump(c.in, c.kind)
+		if err != nil {
+			t.Errorf("dump(%#v): %s", c.in, err)
+			continue
+		}
+		buf := &bytes.Buffer{}
+		writeText(buf, root, 0)
+		if got := buf.String(); got != strings.TrimPrefix(c.want, "\n") {
+			t.Errorf("dump(%#v) ==\n%s\nwant\n%s", c.in, got, c.want)
+		}
+	}
+}
+
+func TestDumpInvalid(t *testing.T) {
+	for _, c := range []struct {
+		kind string
+		in   []byte
+	}{
+		{"amf0", []byte{0x07, 0x00, 0x00}},
+		{"amf0", []byte{0x03, 0x00, 0x01, 'a'}},
+		{"amf3", []byte{0x06, 0x00}},
+		{"amf3", []byte{0x0a, 0x07, 0x01}},
+		{"remoting", []byte{0x00, 0x03, 0x00}},
+	} {
+		if _, err := dump(c.in, c.kind); err == nil {
+			t.Errorf("dump(%#v) succeeded", c.in)
+		}
+	}
+}
+
+func TestDiffFiles(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, hex string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(hex), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	a := write("a", "04 01")
+	b := write("b", "04 02")
+	if diffs, err := diffFiles(a, a, "auto"); err != nil || len(diffs) != 0 {
+		t.Errorf("diffFiles(a, a) == %v, %v", diffs, err)
+	}
+	if diffs, err := diffFiles(a, b, "amf3"); err != nil || len(diffs) != 1 {
+		t.Errorf("diffFiles(a, b) == %v, %v", diffs, err)
+	}
+	for _, c := range []struct{ a, b, kind string }{
+		{a, filepath.Join(dir, "missing"), "amf3"},
+		{a, write("c", "0a"), "amf3"},
+		{write("d", "00 03 00 00 00 00"), b, "auto"},
+	} {
+		if _, err := diffFiles(c.a, c.b, c.kind); err == nil {
+			t.Errorf("diffFiles(%s, %s, %s) succeeded", c.a, c.b, c.kind)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// node is one element of the dump: a value, an entry of a container, or the
+// container itself. Offsets are absolute in the input.
+type node struct {
+	Offset   int         `json:"offset"`
+	Length   int         `json:"length"`
+	Marker   string      `json:"marker,omitempty"`
+	Type     string      `json:"type"`
+	Name     string      `json:"name,omitempty"`
+	Class    string      `json:"class,omitempty"`
+	Value    interface{} `json:"value,omitempty"`
+	Count    *int        `json:"count,omitempty"`
+	Index    *int        `json:"index,omitempty"`
+	Ref      *int        `json:"ref,omitempty"`
+	Detail   string      `json:"detail,omitempty"`
+	Children []*node     `json:"children,omitempty"`
+}
+
+func newNode(v []byte, offset int) *node {
+	n := &node{Offset: offset}
+	if offset < len(v) {
+		n.Marker = fmt.Sprintf("0x%02x", v[offset])
+	}
+	return n
+}
+
+func intp(i int) *int {
+	return &i
+}
+
+func errAt(offset int, format string, args ...interface{}) error {
+	return fmt.Errorf("offset %d: %s", offset, fmt.Sprintf(format, args...))
+}
+
+const maxBytes = 32
+
+// writeText prints n and its children, one per line, indented by depth.
+func writeText(w io.Writer, n *node, depth int) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%08x  %s", n.Offset, strings.Repeat("  ", depth))
+	if n.Name != "" {
+		fmt.Fprintf(&b, "%s: ", n.Name)
+	}
+	b.WriteString(n.Type)
+	if n.Class != "" {
+		fmt.Fprintf(&b, " <%s>", n.Class)
+	}
+	if n.Value != nil {
+		fmt.Fprintf(&b, " = %s", formatValue(n.Value))
+	}
+	b.WriteString("  [")
+	if n.Marker != "" {
+		fmt.Fprintf(&b, "%s ", n.Marker)
+	}
+	fmt.Fprintf(&b, "len=%d", n.Length)
+	if n.Count != nil {
+		fmt.Fprintf(&b, " count=%d", *n.Count)
+	}
+	if n.Index != nil {
+		fmt.Fprintf(&b, " #%d", *n.Index)
+	}
+	if n.Ref != nil {
+		fmt.Fprintf(&b, " ref=#%d", *n.Ref)
+	}
+	if n.Detail != "" {
+		fmt.Fprintf(&b, " %s", n.Detail)
+	}
+	b.WriteString("]\n")
+	io.WriteString(w, b.String())
+	for _, child := range n.Children {
+		writeText(w, child, depth+1)
+	}
+}
+
+func formatValue(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return strconv.Quote(v)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case []byte:
+		if len(v) > maxBytes {
+			return hex.EncodeToString(v[:maxBytes]) + "..."
+		}
+		return hex.EncodeToString(v)
+	}
+	return fmt.Sprint(v)
+}
+
+// jsonValue makes values that encoding/json cannot represent printable.
+func jsonValue(n *node) {
+	switch v := n.Value.(type) {
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			n.Value = strconv.FormatFloat(v, 'g', -1, 64)
+		}
+	case []byte:
+		n.Value = hex.EncodeToString(v)
+	}
+	for _, child := range n.Children {
+		jsonValue(child)
+	}
+}
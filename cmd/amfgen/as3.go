@@ -0,0 +1,360 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// as3Class is an ActionScript class with the properties Flash serializes:
+// public variables and public read-write accessors, neither static nor
+// [Transient].
+type as3Class struct {
+	pkg     string
+	name    string
+	alias   string // from [RemoteClass], empty if not remote
+	extends string
+	props   []as3Property
+}
+
+type as3Property struct {
+	name string
+	typ  string // as written, e.g. "int", "Vector.<String>" or "com.foo.Bar"
+}
+
+func (c *as3Class) qualifiedName() string {
+	if c.pkg == "" {
+		return c.name
+	}
+	return c.pkg + "." + c.name
+}
+
+// tokenize splits ActionScript source into identifiers, string literals
+// (kept with their quotes) and punctuation, dropping comments.
+func tokenize(src string) ([]string, error) {
+	var tokens []string
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case strings.HasPrefix(src[i:], "//"):
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+		case strings.HasPrefix(src[i:], "/*"):
+			end := strings.Index(src[i+2:], "*/")
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated comment")
+			}
+			i += end + 4
+		case c == '"' || c == '\'':
+			j := i + 1
+			for j < len(src) && src[j] != c {
+				if src[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(src) {
+				return nil, fmt.Errorf("unterminated string")
+			}
+			tokens = append(tokens, src[i:j+1])
+			i = j + 1
+		case isIdent(rune(c)):
+			j := i
+			for j < len(src) && isIdent(rune(src[j])) {
+				j++
+			}
+			tokens = append(tokens, src[i:j])
+			i = j
+		default:
+			tokens = append(tokens, src[i:i+1])
+			i++
+		}
+	}
+	return tokens, nil
+}
+
+func isIdent(r rune) bool {
+	return r == '_' || r == '$' || r >= 0x80 || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+type as3Parser struct {
+	tokens []string
+	pos    int
+	pkg    string
+}
+
+// parseAS3 returns the classes declared in an ActionScript source file.
+func parseAS3(src string) ([]*as3Class, error) {
+	tokens, err := tokenize(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &as3Parser{tokens: tokens}
+	var classes []*as3Class
+	var meta []metadata
+	for !p.done() {
+		switch tok := p.next(); tok {
+		case "package":
+			if p.peek() != "{" {
+				p.pkg = p.typeName()
+			}
+		case "[":
+			meta = append(meta, p.metadata())
+		case "import", "use":
+			p.skipPast(";")
+		case "class":
+			c, err := p.class(meta)
+			if err != nil {
+				return nil, err
+			}
+			classes = append(classes, c)
+			meta = nil
+		case "interface", "function", "var", "const", "namespace":
+			meta = nil
+			p.skipDeclaration()
+		}
+	}
+	return classes, nil
+}
+
+func (p *as3Parser) done() bool {
+	return p.pos >= len(p.tokens)
+}
+
+func (p *as3Parser) peek() string {
+	if p.done() {
+		return ""
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *as3Parser) next() string {
+	tok := p.peek()
+	p.pos++
+	return tok
+}
+
+func (p *as3Parser) expect(tok string) error {
+	if got := p.next(); got != tok {
+		return fmt.Errorf("expected %q, found %q", tok, got)
+	}
+	return nil
+}
+
+func (p *as3Parser) skipPast(tok string) {
+	for !p.done() && p.next() != tok {
+	}
+}
+
+// skipBlock skips to the brace closing the one just read.
+func (p *as3Parser) skipBlock() {
+	for depth := 1; depth > 0 && !p.done(); {
+		switch p.next() {
+		case "{":
+			depth++
+		case "}":
+			depth--
+		}
+	}
+}
+
+// skipDeclaration skips a declaration up to its semicolon or body.
+func (p *as3Parser) skipDeclaration() {
+	for depth := 0; !p.done(); {
+		switch p.next() {
+		case "(", "[":
+			depth++
+		case ")", "]":
+			depth--
+		case "{":
+			p.skipBlock()
+			return
+		case ";":
+			if depth == 0 {
+				return
+			}
+		}
+	}
+}
+
+// typeName reads a possibly qualified type, including Vector.<T> and *.
+func (p *as3Parser) typeName() string {
+	if p.peek() == "*" {
+		return p.next()
+	}
+	var b strings.Builder
+	b.WriteString(p.next())
+	for p.peek() == "." {
+		p.next()
+		if p.peek() == "<" {
+			p.next()
+			b.WriteString(".<" + p.typeName() + ">")
+			p.skipPast(">")
+			continue
+		}
+		b.WriteString("." + p.next())
+	}
+	return b.String()
+}
+
+type metadata struct {
+	name string
+	args map[string]string
+}
+
+// metadata reads [Name(key="value", ...)] after its opening bracket.
+func (p *as3Parser) metadata() metadata {
+	m := metadata{name: p.next(), args: make(map[string]string)}
+	if p.peek() == "(" {
+		p.next()
+		for !p.done() && p.peek() != ")" {
+			key := p.next()
+			if key == "," {
+				continue
+			}
+			if p.peek() == "=" {
+				p.next()
+				m.args[key] = unquote(p.next())
+			} else {
+				m.args[""] = unquote(key)
+			}
+		}
+		p.next()
+	}
+	p.skipPast("]")
+	return m
+}
+
+func unquote(s string) string {
+	if len(s) >= 2 && (s[0] == '"' || s[0] == '\'') {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
+
+func (p *as3Parser) class(meta []metadata) (*as3Class, error) {
+	c := &as3Class{pkg: p.pkg, name: p.next()}
+	for _, m := range meta {
+		if m.name == "RemoteClass" {
+			c.alias = m.args["alias"]
+			if c.alias == "" {
+				c.alias = c.qualifiedName()
+			}
+		}
+	}
+	for !p.done() && p.peek() != "{" {
+		if p.next() == "extends" {
+			c.extends = p.typeName()
+		}
+	}
+	if err := p.expect("{"); err != nil {
+		return nil, fmt.Errorf("class %s: %s", c.name, err)
+	}
+	if err := p.members(c); err != nil {
+		return nil, fmt.Errorf("class %s: %s", c.name, err)
+	}
+	return c, nil
+}
+
+// members reads the class body up to its closing brace.
+func (p *as3Parser) members(c *as3Class) error {
+	// Accessors are serialized when they can be both read and written.
+	accessors := make(map[string]int) // index in c.props
+	getters := make(map[string]bool)
+	setters := make(map[string]bool)
+	var transient, public, static bool
+	reset := func() { transient, public, static = false, false, false }
+	for {
+		if p.done() {
+			return fmt.Errorf("unexpected end of file")
+		}
+		switch tok := p.next(); tok {
+		case "}":
+			var props []as3Property
+			for i, prop := range c.props {
+				if index, ok := accessors[prop.name]; ok && index == i && !(getters[prop.name] && setters[prop.name]) {
+					continue
+				}
+				props = append(props, prop)
+			}
+			c.props = props
+			return nil
+		case "[":
+			if p.metadata().name == "Transient" {
+				transient = true
+			}
+		case "public":
+			public = true
+		case "static":
+			static = true
+		case "var":
+			name := p.next()
+			typ := "*"
+			if p.peek() == ":" {
+				p.next()
+				typ = p.typeName()
+			}
+			p.skipDeclaration()
+			if public && !static && !transient {
+				c.props = append(c.props, as3Property{name, typ})
+			}
+			reset()
+		case "function":
+			kind := ""
+			if (p.peek() == "get" || p.peek() == "set") && p.pos+1 < len(p.tokens) && p.tokens[p.pos+1] != "(" {
+				kind = p.next()
+			}
+			name := p.next()
+			param, typ := p.signature()
+			p.skipDeclaration()
+			if public && !static && !transient {
+				if kind == "set" {
+					typ = param
+				}
+				if kind != "" {
+					if _, ok := accessors[name]; !ok {
+						accessors[name] = len(c.props)
+						c.props = append(c.props, as3Property{name, typ})
+					}
+					getters[name] = getters[name] || kind == "get"
+					setters[name] = setters[name] || kind == "set"
+				}
+			}
+			reset()
+		case "const", "namespace":
+			p.skipDeclaration()
+			reset()
+		case ";":
+			reset()
+		}
+	}
+}
+
+// signature reads the parameter list and return type of a function, returning
+// the type of its first parameter and its return type.
+func (p *as3Parser) signature() (string, string) {
+	param, result := "*", "*"
+	if p.next() != "(" {
+		return param, result
+	}
+	for first := true; !p.done(); {
+		tok := p.next()
+		if tok == ")" {
+			break
+		}
+		if tok == ":" && first {
+			param = p.typeName()
+		}
+		if tok == "," {
+			first = false
+		}
+	}
+	if p.peek() == ":" {
+		p.next()
+		result = p.typeName()
+	}
+	return param, result
+}
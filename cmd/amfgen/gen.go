@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"strings"
+	"unicode"
+)
+
+// generator writes Go structs for a set of classes, which may refer to and
+// extend each other.
+type generator struct {
+	classes map[string]*as3Class // by qualified and short name
+	imports map[string]bool
+}
+
+func newGenerator(classes []*as3Class) (*generator, error) {
+	g := &generator{classes: make(map[string]*as3Class), imports: make(map[string]bool)}
+	for _, c := range classes {
+		if other, ok := g.classes[c.name]; ok {
+			return nil, fmt.Errorf("classes %s and %s have the same name", other.qualifiedName(), c.qualifiedName())
+		}
+		g.classes[c.name] = c
+		g.classes[c.qualifiedName()] = c
+	}
+	return g, nil
+}
+
+// generate returns the Go source declaring a struct per class and
+// registering the remote classes.
+func generate(pkg string, classes []*as3Class) ([]byte, error) {
+	g, err := newGenerator(classes)
+	if err != nil {
+		return nil, err
+	}
+	var body bytes.Buffer
+	for _, c := range classes {
+		g.writeStruct(&body, c)
+	}
+	var registrations []string
+	for _, c := range classes {
+		if c.alias != "" {
+			registrations = append(registrations, fmt.Sprintf("amf.RegisterClass(%q, &%s{})\n", c.alias, c.name))
+		}
+	}
+	if len(registrations) > 0 {
+		g.imports["amf \"github.com/TatoExp/go-amf\""] = true
+		body.WriteString("func init() {\n" + strings.Join(registrations, "") + "}\n")
+	}
+
+	var b bytes.Buffer
+	b.WriteString("// Code generated by amfgen. DO NOT EDIT.\n\n")
+	fmt.Fprintf(&b, "package %s\n\n", pkg)
+	if len(g.imports) > 0 {
+		b.WriteString("import (\n")
+		for _, imp := range []string{`"time"`, "", `amf "github.com/TatoExp/go-amf"`} {
+			if imp == "" || g.imports[imp] {
+				b.WriteString(imp + "\n")
+			}
+		}
+		b.WriteString(")\n\n")
+	}
+	b.Write(body.Bytes())
+	return format.Source(b.Bytes())
+}
+
+func (g *generator) writeStruct(b *bytes.Buffer, c *as3Class) {
+	if c.alias != "" {
+		fmt.Fprintf(b, "// %s is the ActionScript class %s.\n", c.name, c.alias)
+	} else {
+		fmt.Fprintf(b, "// %s is the ActionScript class %s, which is not a remote class.\n", c.name, c.qualifiedName())
+	}
+	fmt.Fprintf(b, "type %s struct {\n", c.name)
+	names := make(map[string]bool)
+	fields := make(map[string]bool)
+	for _, prop := range g.properties(c, make(map[*as3Class]bool)) {
+		if names[prop.name] {
+			continue
+		}
+		names[prop.name] = true
+		field := fieldName(prop.name)
+		for fields[field] {
+			field += "_"
+		}
+		fields[field] = true
+		fmt.Fprintf(b, "%s %s `amf:\"%s\"`\n", field, g.goType(prop.typ), prop.name)
+	}
+	b.WriteString("}\n\n")
+}
+
+// properties returns the properties of c after those it inherits.
+func (g *generator) properties(c *as3Class, seen map[*as3Class]bool) []as3Property {
+	if seen[c] {
+		return nil
+	}
+	seen[c] = true
+	var props []as3Property
+	if base, ok := g.classes[c.extends]; ok {
+		props = g.properties(base, seen)
+	}
+	return append(props, c.props...)
+}
+
+func (g *generator) goType(typ string) string {
+	switch typ {
+	case "int":
+		return "int32"
+	case "uint":
+		return "uint32"
+	case "Number":
+		return "float64"
+	case "String":
+		return "string"
+	case "Boolean":
+		return "bool"
+	case "Date":
+		g.imports[`"time"`] = true
+		return "time.Time"
+	case "ByteArray", "flash.utils.ByteArray":
+		return "[]byte"
+	case "Array":
+		return "[]interface{}"
+	}
+	if elem, ok := strings.CutPrefix(typ, "Vector.<"); ok {
+		return "[]" + g.goType(strings.TrimSuffix(elem, ">"))
+	}
+	if c, ok := g.classes[typ]; ok {
+		return "*" + c.name
+	}
+	return "interface{}"
+}
+
+var initialisms = map[string]bool{"id": true, "uid": true, "uuid": true, "url": true, "uri": true, "ip": true, "xml": true, "json": true, "html": true, "http": true}
+
+// fieldName exports an ActionScript property name.
+func fieldName(name string) string {
+	name = strings.TrimLeft(name, "_$")
+	if initialisms[strings.ToLower(name)] {
+		return strings.ToUpper(name)
+	}
+	r := []rune(name)
+	if len(r) == 0 {
+		return "X"
+	}
+	r[0] = unicode.ToUpper(r[0])
+	if !unicode.IsLetter(r[0]) {
+		return "X" + string(r)
+	}
+	return strings.Map(func(r rune) rune {
+		if r == '$' {
+			return '_'
+		}
+		return r
+	}, string(r))
+}
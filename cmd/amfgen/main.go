@@ -0,0 +1,90 @@
+// Command amfgen generates Go structs from ActionScript 3 value object
+// classes.
+//
+// Usage:
+//
+//	amfgen [-package name] [-o file] path...
+//
+// Each path is an .as file or a directory searched for them. Every class
+// becomes a struct with a field, tagged with its `amf` name, per property
+// Flash serializes: public variables and public read-write accessors that are
+// neither static nor [Transient], after those of the classes it extends.
+// Classes with [RemoteClass] metadata are registered under their alias with
+// amf.RegisterClass, so that they encode as typed objects and decode into
+// their struct. Properties typed with other parsed classes become pointers to
+// their struct, and those of types without a Go counterpart interface{}. The
+// dynamic members of dynamic classes are not kept, as structs only have the
+// declared properties.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func main() {
+	pkg := flag.String("package", "vo", "`name` of the generated package")
+	out := flag.String("o", "", "output `file`, stdout if not set")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: amfgen [flags] path...\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	var classes []*as3Class
+	for _, path := range flag.Args() {
+		parsed, err := parseFiles(path)
+		if err != nil {
+			fatal(err)
+		}
+		classes = append(classes, parsed...)
+	}
+	src, err := generate(*pkg, classes)
+	if err != nil {
+		fatal(err)
+	}
+	if *out == "" {
+		_, err = os.Stdout.Write(src)
+	} else {
+		err = os.WriteFile(*out, src, 0o644)
+	}
+	if err != nil {
+		fatal(err)
+	}
+}
+
+// parseFiles parses the .as file at path, or those in the directory at path.
+func parseFiles(path string) ([]*as3Class, error) {
+	var classes []*as3Class
+	err := filepath.WalkDir(path, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || name != path && !strings.HasSuffix(name, ".as") {
+			return nil
+		}
+		src, err := os.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		parsed, err := parseAS3(string(src))
+		if err != nil {
+			return fmt.Errorf("%s: %s", name, err)
+		}
+		classes = append(classes, parsed...)
+		return nil
+	})
+	return classes, err
+}
+
+func fatal(err error) {
+	fmt.Fprintf(os.Stderr, "amfgen: %s\n", err)
+	os.Exit(1)
+}
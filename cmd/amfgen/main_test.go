@@ -0,0 +1,132 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+const userAS = `
+package com.example.vo {
+	import flash.utils.ByteArray;
+
+	[Bindable]
+	[RemoteClass(alias="com.example.User")]
+	public class User extends Entity {
+		public static const ADMIN:String = "admin";
+		public var name:String = "it's {not} a body"; // comment
+		public var groups:Vector.<Group>;
+		[Transient] public var selected:Boolean;
+		private var _age:int;
+		public var avatar:ByteArray;
+		/* public var hidden:String; */
+
+		public function get age():int { return _age; }
+		public function set age(value:int):void { _age = value; }
+		public function get label():String { return name + "!"; }
+		public function greet(other:User):String { return "hi"; }
+	}
+}
+`
+
+const entityAS = `
+package com.example.vo {
+	[RemoteClass]
+	public class Entity {
+		public var id:Number;
+		public var created:Date;
+		public var tags:Array;
+		public var extra:*;
+	}
+}
+`
+
+const groupAS = `
+package com.example.vo {
+	public dynamic class Group {
+		public var owner:User;
+		public var scores:Vector.<Vector.<uint>>;
+		public var _owner:String;
+	}
+}
+`
+
+const wantGo = `// Code generated by amfgen. DO NOT EDIT.
+
+package vo
+
+import (
+	"time"
+
+	amf "github.com/TatoExp/go-amf"
+)
+
+// User is the ActionScript class com.example.User.
+type User struct {
+	ID      float64       ` + "`amf:\"id\"`" + `
+	Created time.Time     ` + "`amf:\"created\"`" + `
+	Tags    []interface{} ` + "`amf:\"tags\"`" + `
+	Extra   interface{}   ` + "`amf:\"extra\"`" + `
+	Name    string        ` + "`amf:\"name\"`" + `
+	Groups  []*Group      ` + "`amf:\"groups\"`" + `
+	Avatar  []byte        ` + "`amf:\"avatar\"`" + `
+	Age     int32         ` + "`amf:\"age\"`" + `
+}
+
+// Entity is the ActionScript class com.example.vo.Entity.
+type Entity struct {
+	ID      float64       ` + "`amf:\"id\"`" + `
+	Created time.Time     ` + "`amf:\"created\"`" + `
+	Tags    []interface{} ` + "`amf:\"tags\"`" + `
+	Extra   interface{}   ` + "`amf:\"extra\"`" + `
+}
+
+// Group is the ActionScript class com.example.vo.Group, which is not a remote class.
+type Group struct {
+	Owner  *User      ` + "`amf:\"owner\"`" + `
+	Scores [][]uint32 ` + "`amf:\"scores\"`" + `
+	Owner_ string     ` + "`amf:\"_owner\"`" + `
+}
+
+func init() {
+	amf.RegisterClass("com.example.User", &User{})
+	amf.RegisterClass("com.example.vo.Entity", &Entity{})
+}
+`
+
+func TestGenerate(t *testing.T) {
+	var classes []*as3Class
+	for _, src := range []string{userAS, entityAS, groupAS} {
+		parsed, err := parseAS3(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		classes = append(classes, parsed...)
+	}
+	got, err := generate("vo", classes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != wantGo {
+		t.Errorf("generate ==\n%s\nwant\n%s", got, wantGo)
+	}
+}
+
+func TestParseAS3Invalid(t *testing.T) {
+	for _, src := range []string{
+		"package { public class A { public var a:int;",
+		"package { /* public class A {} }",
+		`package { [RemoteClass(alias="a)] public class A {} }`,
+		"package { public class A extends B }",
+	} {
+		if classes, err := parseAS3(src); err == nil {
+			t.Errorf("parseAS3(%q) == %v, want error", src, classes)
+		}
+	}
+	classes, _ := parseAS3("package a.b { public class A {} }")
+	if len(classes) != 1 || classes[0].qualifiedName() != "a.b.A" {
+		t.Errorf("parseAS3 == %v", classes)
+	}
+	if _, err := generate("vo", append(classes, &as3Class{pkg: "c", name: "A"})); err == nil || !strings.Contains(err.Error(), "same name") {
+		t.Errorf("generate with duplicate names == %v", err)
+	}
+}
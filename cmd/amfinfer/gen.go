@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"strings"
+	"unicode"
+)
+
+// generate returns Go source with a struct per class, registered under its
+// alias.
+func (s *schema) generate(pkg string) ([]byte, error) {
+	if len(s.order) == 0 {
+		return nil, fmt.Errorf("no typed objects observed")
+	}
+	names := make(map[string]string) // Go name by class
+	used := make(map[string]string)
+	for _, class := range s.order {
+		name := goName(class[strings.LastIndexByte(class, '.')+1:])
+		if other, ok := used[name]; ok {
+			return nil, fmt.Errorf("classes %s and %s have the same name", other, class)
+		}
+		used[name] = class
+		names[class] = name
+	}
+	imports := make(map[string]bool)
+	var body bytes.Buffer
+	for _, class := range s.order {
+		c := s.classes[class]
+		fmt.Fprintf(&body, "// %s is the ActionScript class %s, inferred from %d objects.\n", names[class], class, c.objects)
+		fmt.Fprintf(&body, "type %s struct {\n", names[class])
+		fields := make(map[string]bool)
+		for _, key := range c.order {
+			m := c.members[key]
+			field := goName(key)
+			for fields[field] {
+				field += "_"
+			}
+			fields[field] = true
+			tag := key
+			if c.optional(m) {
+				tag += ",omitempty"
+			}
+			fmt.Fprintf(&body, "%s %s `amf:%q`\n", field, goType(m.kinds, m.items, names, imports), tag)
+		}
+		body.WriteString("}\n\n")
+	}
+	body.WriteString("func init() {\n")
+	for _, class := range s.order {
+		fmt.Fprintf(&body, "amf.RegisterClass(%q, &%s{})\n", class, names[class])
+	}
+	body.WriteString("}\n")
+
+	var b bytes.Buffer
+	b.WriteString("// Code generated by amfinfer. DO NOT EDIT.\n\n")
+	fmt.Fprintf(&b, "package %s\n\nimport (\n", pkg)
+	if imports["time"] {
+		b.WriteString("\"time\"\n\n")
+	}
+	b.WriteString("amf \"github.com/TatoExp/go-amf\"\n)\n\n")
+	b.Write(body.Bytes())
+	return format.Source(b.Bytes())
+}
+
+// goType picks the Go type holding every kind observed. Nullable scalars
+// become pointers.
+func goType(counts, items map[string]int, names map[string]string, imports map[string]bool) string {
+	ks := kinds(counts)
+	nullable := counts["null"] > 0 || counts["undefined"] > 0
+	if len(ks) == 2 && (ks[0] == "int" && ks[1] == "double" || ks[0] == "double" && ks[1] == "int") {
+		ks = []string{"double"}
+	}
+	if len(ks) != 1 {
+		return "interface{}"
+	}
+	var t string
+	switch k := ks[0]; k {
+	case "boolean":
+		t = "bool"
+	case "int":
+		t = "int32"
+	case "double":
+		t = "float64"
+	case "string":
+		t = "string"
+	case "date":
+		imports["time"] = true
+		t = "time.Time"
+	case "bytes":
+		return "[]byte"
+	case "array":
+		return "[]" + goType(items, nil, names, imports)
+	case "ecma", "object":
+		return "map[string]interface{}"
+	default:
+		return "*" + names[k]
+	}
+	if nullable {
+		return "*" + t
+	}
+	return t
+}
+
+var initialisms = map[string]bool{"id": true, "uid": true, "uuid": true, "url": true, "uri": true, "ip": true, "xml": true, "json": true, "html": true, "http": true}
+
+// goName exports a member or class name.
+func goName(name string) string {
+	if initialisms[strings.ToLower(name)] {
+		return strings.ToUpper(name)
+	}
+	var b strings.Builder
+	upper := true
+	for _, r := range name {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			upper = true
+			continue
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		b.WriteRune(r)
+	}
+	if b.Len() == 0 || !unicode.IsLetter([]rune(b.String())[0]) {
+		return "X" + b.String()
+	}
+	return b.String()
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+
+	amf "github.com/TatoExp/go-amf"
+)
+
+// schema aggregates the classes of the typed objects observed in many
+// payloads.
+type schema struct {
+	classes map[string]*class
+	order   []string
+}
+
+type class struct {
+	name    string
+	objects int
+	sealed  []string // of the first object
+	varying bool     // objects had other sealed members
+	dynamic bool
+	members map[string]*member
+	order   []string
+}
+
+// member counts the kinds of value observed for a member, and of the items
+// when they are arrays.
+type member struct {
+	objects int
+	kinds   map[string]int
+	items   map[string]int
+}
+
+func newSchema() *schema {
+	return &schema{classes: make(map[string]*class)}
+}
+
+// kind names the type of a decoded value: a wire type, or the class of a
+// typed object.
+func kind(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case amf.Undefined:
+		return "undefined"
+	case bool:
+		return "boolean"
+	case int:
+		return "int"
+	case float64:
+		return "double"
+	case string:
+		return "string"
+	case time.Time:
+		return "date"
+	case []byte:
+		return "bytes"
+	case []interface{}:
+		return "array"
+	case *amf.OrderedECMAArray:
+		return "ecma"
+	case *amf.OrderedObject:
+		if v.Class != "" {
+			return v.Class
+		}
+	}
+	return "object"
+}
+
+// observe adds the typed objects found in v, which must come from a Decoder
+// with Ordered set. Objects referred to more than once count once.
+func (s *schema) observe(v interface{}) {
+	s.walk(v, make(map[interface{}]bool))
+}
+
+func (s *schema) walk(v interface{}, seen map[interface{}]bool) {
+	switch v := v.(type) {
+	case []interface{}:
+		if len(v) == 0 || seen[&v[0]] {
+			return
+		}
+		seen[&v[0]] = true
+		for _, item := range v {
+			s.walk(item, seen)
+		}
+	case *amf.OrderedECMAArray:
+		if seen[v] {
+			return
+		}
+		seen[v] = true
+		for _, key := range v.Keys() {
+			item, _ := v.Get(key)
+			s.walk(item, seen)
+		}
+	case *amf.OrderedObject:
+		if seen[v] {
+			return
+		}
+		seen[v] = true
+		if v.Class == "" {
+			for _, key := range v.Keys() {
+				item, _ := v.Get(key)
+				s.walk(item, seen)
+			}
+			return
+		}
+		c := s.class(v)
+		c.objects++
+		for i, key := range v.Keys() {
+			value, _ := v.Get(key)
+			m := c.members[key]
+			if m == nil {
+				m = &member{kinds: make(map[string]int), items: make(map[string]int)}
+				c.members[key] = m
+				c.order = append(c.order, key)
+			}
+			m.objects++
+			m.kinds[kind(value)]++
+			if items, ok := value.([]interface{}); ok {
+				for _, item := range items {
+					m.items[kind(item)]++
+				}
+			}
+			if i >= v.Sealed {
+				c.dynamic = true
+			}
+			s.walk(value, seen)
+		}
+	}
+}
+
+func (s *schema) class(o *amf.OrderedObject) *class {
+	sealed := o.Keys()[:min(o.Sealed, o.Len())]
+	c := s.classes[o.Class]
+	if c == nil {
+		c = &class{name: o.Class, sealed: slices.Clone(sealed), members: make(map[string]*member)}
+		s.classes[o.Class] = c
+		s.order = append(s.order, o.Class)
+	} else if !slices.Equal(c.sealed, sealed) {
+		c.varying = true
+	}
+	c.dynamic = c.dynamic || o.Dynamic
+	return c
+}
+
+// optional reports whether some objects lacked the member.
+func (c *class) optional(m *member) bool {
+	return m.objects < c.objects
+}
+
+// nullable reports whether the member was null or undefined.
+func (m *member) nullable() bool {
+	return m.kinds["null"] > 0 || m.kinds["undefined"] > 0
+}
+
+// kinds lists the kinds other than null and undefined, the most frequent
+// first.
+func kinds(counts map[string]int) []string {
+	var result []string
+	for k := range counts {
+		if k != "null" && k != "undefined" {
+			result = append(result, k)
+		}
+	}
+	slices.SortFunc(result, func(a, b string) int {
+		if counts[a] != counts[b] {
+			return counts[b] - counts[a]
+		}
+		return strings.Compare(a, b)
+	})
+	return result
+}
+
+// typeName describes the kinds of a member, such as "int | double",
+// "array<string>" or "com.example.User | null".
+func (m *member) typeName() string {
+	var parts []string
+	for _, k := range kinds(m.kinds) {
+		if k == "array" && len(m.items) > 0 {
+			items := kinds(m.items)
+			if m.items["null"] > 0 || m.items["undefined"] > 0 {
+				items = append(items, "null")
+			}
+			k = "array<" + strings.Join(items, " | ") + ">"
+		}
+		parts = append(parts, k)
+	}
+	if m.nullable() {
+		parts = append(parts, "null")
+	}
+	return strings.Join(parts, " | ")
+}
+
+// writeReport lists each class with its members, their kinds and how often
+// they were present.
+func (s *schema) writeReport(w io.Writer) {
+	for i, name := range s.order {
+		c := s.classes[name]
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		traits := "sealed"
+		if c.dynamic {
+			traits = "dynamic"
+		}
+		if c.varying {
+			traits += ", sealed members vary"
+		}
+		fmt.Fprintf(w, "class %s (%d objects, %s)\n", c.name, c.objects, traits)
+		width := 0
+		for _, key := range c.order {
+			width = max(width, len(key)+1)
+		}
+		for _, key := range c.order {
+			m := c.members[key]
+			name := key
+			if !slices.Contains(c.sealed, key) {
+				name += "*"
+			}
+			fmt.Fprintf(w, "  %-*s %s", width, name, m.typeName())
+			if c.optional(m) {
+				fmt.Fprintf(w, "  (in %d of %d)", m.objects, c.objects)
+			}
+			fmt.Fprintln(w)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+// Command amfinfer infers the classes of an undocumented AMF service from
+// captured payloads.
+//
+// Usage:
+//
+//	amfinfer [-type amf3|amf0] [-go file] [-package name] file...
+//
+// Each file holds one or more consecutive values, as raw bytes or hex digits.
+// The traits of every typed object are aggregated by class alias, and a
+// report lists each class with its members in wire order, the kinds of value
+// observed for them and how often they were present. Dynamic members are
+// marked with "*". With -go, Go structs with `amf` tags are also written,
+// with the RegisterClass calls mapping the aliases to them.
+package main
+
+import (
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	amf "github.com/TatoExp/go-amf"
+)
+
+func main() {
+	kind := flag.String("type", "amf3", "input `type`: amf3 or amf0")
+	out := flag.String("go", "", "write Go structs to `file`")
+	pkg := flag.String("package", "vo", "`name` of the generated package")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: amfinfer [flags] file...\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 || *kind != "amf3" && *kind != "amf0" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	version := amf.AMF3
+	if *kind == "amf0" {
+		version = amf.AMF0
+	}
+	s := newSchema()
+	for _, name := range flag.Args() {
+		data, err := readInput(name)
+		if err == nil {
+			err = s.observeAll(data, version)
+		}
+		if err != nil {
+			fatal(fmt.Errorf("%s: %s", name, err))
+		}
+	}
+	s.writeReport(os.Stdout)
+	if *out != "" {
+		src, err := s.generate(*pkg)
+		if err == nil {
+			err = os.WriteFile(*out, src, 0o644)
+		}
+		if err != nil {
+			fatal(err)
+		}
+	}
+}
+
+// observeAll observes the consecutive values of a payload, which share their
+// reference tables.
+func (s *schema) observeAll(data []byte, version amf.AMFVersion) error {
+	d := &amf.Decoder{Ordered: true, KeepUndefined: true}
+	for offset := 0; offset < len(data); {
+		var value interface{}
+		var n int
+		var err error
+		if version == amf.AMF0 {
+			value, n, err = d.DecodeAMF0(data[offset:])
+		} else {
+			value, n, err = d.DecodeAMF3(data[offset:])
+		}
+		if err != nil {
+			return fmt.Errorf("offset %d: %s", offset, err)
+		}
+		s.observe(value)
+		offset += n
+	}
+	return nil
+}
+
+// readInput reads the named file, decoding it if it only holds hex digits
+// and white space.
+func readInput(name string) ([]byte, error) {
+	var data []byte
+	var err error
+	if name == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if digits, ok := hexDigits(data); ok {
+		return hex.DecodeString(string(digits))
+	}
+	return data, nil
+}
+
+func hexDigits(data []byte) ([]byte, bool) {
+	digits := make([]byte, 0, len(data))
+	for _, c := range data {
+		switch {
+		case c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F':
+			digits = append(digits, c)
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+		default:
+			return nil, false
+		}
+	}
+	return digits, len(digits) > 0 && len(digits)%2 == 0
+}
+
+func fatal(err error) {
+	fmt.Fprintf(os.Stderr, "amfinfer: %s\n", err)
+	os.Exit(1)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	amf "github.com/TatoExp/go-amf"
+)
+
+func object(class string, sealed int, kv ...interface{}) *amf.OrderedObject {
+	o := &amf.OrderedObject{Class: class, Sealed: sealed}
+	for i := 0; i < len(kv); i += 2 {
+		o.Set(kv[i].(string), kv[i+1])
+	}
+	return o
+}
+
+func payloads(t *testing.T) *schema {
+	group := object("com.example.Group", 1, "title", "admins")
+	ann := object("com.example.User", 4, "id", 1, "name", "ann", "groups", []interface{}{group}, "created", time.UnixMilli(0), "note", "x")
+	ann.Dynamic = true
+	bob := object("com.example.User", 4, "id", 2.5, "name", nil, "groups", []interface{}{}, "created", time.UnixMilli(0))
+	bob.Set("friend", ann)
+	s := newSchema()
+	for _, v := range []interface{}{ann, []interface{}{bob, bob}, group} {
+		data, err := amf.AppendAMF3(nil, v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.observeAll(data, amf.AMF3); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return s
+}
+
+const wantReport = `class com.example.User (3 objects, dynamic)
+  id       int | double
+  name     string | null
+  groups   array<com.example.Group>
+  created  date
+  note*    string  (in 2 of 3)
+  friend*  com.example.User  (in 1 of 3)
+
+class com.example.Group (3 objects, sealed)
+  title  string
+`
+
+func TestReport(t *testing.T) {
+	var b bytes.Buffer
+	payloads(t).writeReport(&b)
+	if b.String() != wantReport {
+		t.Errorf("report ==\n%s\nwant\n%s", b.String(), wantReport)
+	}
+}
+
+const wantGo = `// Code generated by amfinfer. DO NOT EDIT.
+
+package vo
+
+import (
+	"time"
+
+	amf "github.com/TatoExp/go-amf"
+)
+
+// User is the ActionScript class com.example.User, inferred from 3 objects.
+type User struct {
+	ID      float64   ` + "`amf:\"id\"`" + `
+	Name    *string   ` + "`amf:\"name\"`" + `
+	Groups  []*Group  ` + "`amf:\"groups\"`" + `
+	Created time.Time ` + "`amf:\"created\"`" + `
+	Note    string    ` + "`amf:\"note,omitempty\"`" + `
+	Friend  *User     ` + "`amf:\"friend,omitempty\"`" + `
+}
+
+// Group is the ActionScript class com.example.Group, inferred from 3 objects.
+type Group struct {
+	Title string ` + "`amf:\"title\"`" + `
+}
+
+func init() {
+	amf.RegisterClass("com.example.User", &User{})
+	amf.RegisterClass("com.example.Group", &Group{})
+}
+`
+
+func TestGenerate(t *testing.T) {
+	got, err := payloads(t).generate("vo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != wantGo {
+		t.Errorf("generate ==\n%s\nwant\n%s", got, wantGo)
+	}
+	if _, err := newSchema().generate("vo"); err == nil {
+		t.Error("generate without classes succeeded")
+	}
+}
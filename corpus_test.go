@@ -0,0 +1,243 @@
+package amf
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	corpusImport = flag.String("amf.import", "", "directory of amf0-*.bin and amf3-*.bin files to print as corpus vectors")
+	corpusFrom   = flag.String("amf.from", "", "origin of the imported files, such as \"RocketAMF 1.0.0 spec/fixtures/objects\"")
+	rocketAMF    = flag.Bool("amf.rocketamf", false, "check the corpus against RocketAMF, run by amf_test_server.rb with ruby")
+)
+
+var corpusFiles = []struct {
+	file    string
+	version AMFVersion
+}{
+	{"amf0.txt", AMF0},
+	{"amf3.txt", AMF3},
+}
+
+// corpusVector is one entry of the golden vectors in testdata/corpus,
+// described in testdata/corpus/README.
+type corpusVector struct {
+	name, from string
+	line       int
+	data       []byte
+	value      string
+	encode     []byte
+}
+
+func readCorpus(t *testing.T, path string) []corpusVector {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var vectors []corpusVector
+	var v *corpusVector
+	s := bufio.NewScanner(f)
+	for line := 1; s.Scan(); line++ {
+		text := strings.TrimSpace(s.Text())
+		if text == "" {
+			v = nil
+			continue
+		}
+		if strings.HasPrefix(text, "#") {
+			continue
+		}
+		if v == nil {
+			vectors = append(vectors, corpusVector{line: line})
+			v = &vectors[len(vectors)-1]
+		}
+		field, value, ok := strings.Cut(text, ":")
+		value = strings.TrimSpace(value)
+		switch field {
+		case "name":
+			v.name = value
+		case "from":
+			v.from = value
+		case "value":
+			v.value = value
+		case "hex", "encode":
+			b, err := hex.DecodeString(strings.ReplaceAll(value, " ", ""))
+			if err != nil {
+				t.Fatalf("%s:%d: %s", path, line, err)
+			}
+			if field == "hex" {
+				v.data = append(v.data, b...)
+			} else {
+				v.encode = append(v.encode, b...)
+			}
+		default:
+			ok = false
+		}
+		if !ok {
+			t.Fatalf("%s:%d: invalid line %q", path, line, text)
+		}
+	}
+	if err := s.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return vectors
+}
+
+func TestCorpus(t *testing.T) {
+	for _, c := range corpusFiles {
+		path := filepath.Join("testdata", "corpus", c.file)
+		names := make(map[string]bool)
+		for _, v := range readCorpus(t, path) {
+			if v.name == "" || v.from == "" || v.data == nil || v.value == "" || names[v.name] {
+				t.Errorf("%s:%d: vector needs a unique name, from, hex and value", path, v.line)
+				continue
+			}
+			names[v.name] = true
+			testCorpusVector(t, c.version, path+":"+v.name, v)
+		}
+	}
+}
+
+func testCorpusVector(t *testing.T, version AMFVersion, name string, v corpusVector) {
+	d := &Decoder{Ordered: true, KeepUndefined: true}
+	var value interface{}
+	var n int
+	var err error
+	if version == AMF0 {
+		value, n, err = d.DecodeAMF0(v.data)
+	} else {
+		value, n, err = d.DecodeAMF3(v.data)
+	}
+	if err != nil {
+		t.Errorf("%s: decode: %s", name, err)
+		return
+	}
+	if n != len(v.data) {
+		t.Errorf("%s: decoded %d of %d bytes", name, n, len(v.data))
+	}
+	if got, err := Format(value); err != nil || got != v.value {
+		t.Errorf("%s: decoded %s, %v, want %s", name, got, err, v.value)
+	}
+	want := v.encode
+	if want == nil {
+		want = v.data
+	}
+	var got []byte
+	if version == AMF0 {
+		got, err = AppendAMF0(nil, value)
+	} else {
+		got, err = AppendAMF3(nil, value)
+	}
+	if err != nil || !bytes.Equal(got, want) {
+		t.Errorf("%s: encoded % x, %v, want % x", name, got, err, want)
+	}
+}
+
+// TestCorpusRocketAMF has RocketAMF decode and encode every vector again, as an
+// interop check while the corpus holds no vectors taken from RocketAMF itself.
+func TestCorpusRocketAMF(t *testing.T) {
+	if !*rocketAMF {
+		t.Skip("no -amf.rocketamf")
+	}
+	for _, c := range corpusFiles {
+		path := filepath.Join("testdata", "corpus", c.file)
+		testRocketAMF(t, c.version, path, readCorpus(t, path))
+	}
+}
+
+func testRocketAMF(t *testing.T, version AMFVersion, path string, vectors []corpusVector) {
+	// RocketAMF numbers the versions 0 and 3.
+	n := 0
+	if version == AMF3 {
+		n = 3
+	}
+	cmd := exec.Command("ruby", "amf_test_server.rb", strconv.Itoa(n))
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Process.Kill()
+	var conn net.Conn
+	var err error
+	for i := 0; i < 50; i++ {
+		if conn, err = net.Dial("tcp", "localhost:"+strconv.Itoa(4242+n)); err == nil {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for _, v := range vectors {
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		if _, err := fmt.Fprintf(conn, "%x\n", v.data); err != nil {
+			t.Fatal(err)
+		}
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := v.encode
+		if want == nil {
+			want = v.data
+		}
+		if got := strings.TrimSpace(line); got != hex.EncodeToString(want) {
+			t.Errorf("%s:%s: RocketAMF encoded %s, want %x", path, v.name, got, want)
+		}
+	}
+	fmt.Fprintf(conn, "exit\n")
+}
+
+// TestCorpusImport prints the files of -amf.import as corpus vectors, to be
+// checked by hand before they are added.
+func TestCorpusImport(t *testing.T) {
+	if *corpusImport == "" {
+		t.Skip("no -amf.import directory")
+	}
+	if *corpusFrom == "" {
+		t.Fatal("-amf.from is needed to record where the files come from")
+	}
+	paths, err := filepath.Glob(filepath.Join(*corpusImport, "amf[03]-*.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		name := strings.TrimSuffix(filepath.Base(path), ".bin")
+		d := &Decoder{Ordered: true, KeepUndefined: true}
+		var value interface{}
+		if strings.HasPrefix(name, "amf0") {
+			value, _, err = d.DecodeAMF0(data)
+		} else {
+			value, _, err = d.DecodeAMF3(data)
+		}
+		var text string
+		if err == nil {
+			text, err = Format(value)
+		}
+		if err != nil {
+			t.Errorf("%s: %s", path, err)
+			continue
+		}
+		fmt.Printf("name: %s\nfrom: %s/%s\n", name[5:], *corpusFrom, filepath.Base(path))
+		for i := 0; i < len(data); i += 32 {
+			fmt.Printf("hex: % x\n", data[i:min(i+32, len(data))])
+		}
+		fmt.Printf("value: %s\n\n", text)
+	}
+}
@@ -0,0 +1,291 @@
+package amf
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"time"
+)
+
+type DiffKind int
+
+const (
+	TypeChanged  DiffKind = iota // different types, classes or traits
+	ValueChanged                 // same type, different value
+	KeyAdded                     // member or item only in b
+	KeyRemoved                   // member or item only in a
+	RefChanged                   // reference on one side, inline on the other
+	OrderChanged                 // same members in a different order
+)
+
+func (k DiffKind) String() string {
+	switch k {
+	case TypeChanged:
+		return "type changed"
+	case ValueChanged:
+		return "value changed"
+	case KeyAdded:
+		return "key added"
+	case KeyRemoved:
+		return "key removed"
+	case RefChanged:
+		return "reference changed"
+	case OrderChanged:
+		return "order changed"
+	}
+	return "DiffKind(" + strconv.Itoa(int(k)) + ")"
+}
+
+// Difference is one difference found by Diff. Path is empty for the value
+// itself, and otherwise like "user.tags[0]". A and B are the values on each
+// side, except for RefChanged where they are "reference" or "inline" and for
+// OrderChanged where they are the member names in order.
+type Difference struct {
+	Path string
+	Kind DiffKind
+	A, B interface{}
+}
+
+func (d Difference) String() string {
+	path := d.Path
+	if path == "" {
+		path = "(root)"
+	}
+	switch d.Kind {
+	case KeyAdded:
+		return fmt.Sprintf("%s: %s: %s", path, d.Kind, formatDiffValue(d.B))
+	case KeyRemoved:
+		return fmt.Sprintf("%s: %s: %s", path, d.Kind, formatDiffValue(d.A))
+	case RefChanged, OrderChanged:
+		return fmt.Sprintf("%s: %s: %v -> %v", path, d.Kind, d.A, d.B)
+	}
+	return fmt.Sprintf("%s: %s: %s -> %s", path, d.Kind, formatDiffValue(d.A), formatDiffValue(d.B))
+}
+
+// formatDiffValue writes leaves in the notation of Format and containers by
+// type and size, as they may be large or cyclic.
+func formatDiffValue(v interface{}) string {
+	switch v := v.(type) {
+	case []interface{}:
+		return fmt.Sprintf("array[%d]", len(v))
+	case *OrderedObject:
+		return fmt.Sprintf("%s{%d}", diffType(v), v.Len())
+	case *OrderedECMAArray:
+		return fmt.Sprintf("ecma array{%d}", v.Len())
+	}
+	if s, err := Format(v); err == nil {
+		return s
+	}
+	return fmt.Sprint(v)
+}
+
+// Diff decodes the first value of a and of b and lists their differences.
+// References are detected for objects, arrays and byte arrays.
+func Diff(a, b []byte, version AMFVersion) ([]Difference, error) {
+	va, err := decodeOrdered(a, version)
+	if err != nil {
+		return nil, fmt.Errorf("a: %s", err)
+	}
+	vb, err := decodeOrdered(b, version)
+	if err != nil {
+		return nil, fmt.Errorf("b: %s", err)
+	}
+	d := &differ{
+		refsA:   make(map[string]bool),
+		refsB:   make(map[string]bool),
+		visited: make(map[[2]interface{}]bool),
+	}
+	findRefs(va, "", make(map[interface{}]bool), d.refsA)
+	findRefs(vb, "", make(map[interface{}]bool), d.refsB)
+	d.compare("", va, vb)
+	return d.result, nil
+}
+
+func decodeOrdered(data []byte, version AMFVersion) (interface{}, error) {
+	d := &Decoder{Ordered: true, KeepUndefined: true}
+	var v interface{}
+	var err error
+	switch version {
+	case AMF0:
+		v, _, err = d.decodeAMF0(data)
+	case AMF3:
+		v, _, err = d.decodeAMF3(data)
+	default:
+		err = fmt.Errorf("unsupported version %d", version)
+	}
+	return v, err
+}
+
+// memberPath and itemPath build the paths used by Diff.
+func memberPath(path, key string) string {
+	if !isTextIdent(key) {
+		return path + "[" + strconv.Quote(key) + "]"
+	}
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func itemPath(path string, i int) string {
+	return path + "[" + strconv.Itoa(i) + "]"
+}
+
+// findRefs records the paths of values that were already seen earlier in
+// wire order, that is, decoded from a reference.
+func findRefs(v interface{}, path string, seen map[interface{}]bool, refs map[string]bool) {
+	if id, ok := Identity(v); ok {
+		if seen[id] {
+			refs[path] = true
+			return
+		}
+		seen[id] = true
+	}
+	switch v := v.(type) {
+	case []interface{}:
+		for i, item := range v {
+			findRefs(item, itemPath(path, i), seen, refs)
+		}
+	case *OrderedObject:
+		for _, key := range v.keys {
+			findRefs(v.values[key], memberPath(path, key), seen, refs)
+		}
+	case *OrderedECMAArray:
+		for _, key := range v.keys {
+			findRefs(v.values[key], memberPath(path, key), seen, refs)
+		}
+	}
+}
+
+type differ struct {
+	refsA, refsB map[string]bool
+	visited      map[[2]interface{}]bool
+	result       []Difference
+}
+
+func (d *differ) add(path string, kind DiffKind, a, b interface{}) {
+	d.result = append(d.result, Difference{Path: path, Kind: kind, A: a, B: b})
+}
+
+func refName(ref bool) string {
+	if ref {
+		return "reference"
+	}
+	return "inline"
+}
+
+func diffType(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case Undefined:
+		return "undefined"
+	case bool:
+		return "boolean"
+	case int:
+		return "integer"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case time.Time:
+		return "date"
+	case []byte:
+		return "byte array"
+	case []interface{}:
+		return "array"
+	case *OrderedECMAArray:
+		return "ecma array"
+	case *OrderedObject:
+		if v.Class == "" && v.sealed() == 0 {
+			return "object"
+		}
+		s := "obj<" + v.Class + "> sealed=" + strconv.Itoa(v.sealed())
+		if v.dynamic() {
+			s += " dynamic"
+		}
+		return s
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+func (d *differ) compare(path string, a, b interface{}) {
+	refA, refB := d.refsA[path], d.refsB[path]
+	if refA != refB {
+		d.add(path, RefChanged, refName(refA), refName(refB))
+	}
+	// References are compared by their targets, which stop here once
+	// compared with each other.
+	ida, oka := Identity(a)
+	idb, okb := Identity(b)
+	if oka && okb {
+		pair := [2]interface{}{ida, idb}
+		if d.visited[pair] {
+			return
+		}
+		d.visited[pair] = true
+	}
+	if ta, tb := diffType(a), diffType(b); ta != tb {
+		d.add(path, TypeChanged, a, b)
+		return
+	}
+	switch a := a.(type) {
+	case []interface{}:
+		b := b.([]interface{})
+		for i := 0; i < max(len(a), len(b)); i++ {
+			switch {
+			case i >= len(b):
+				d.add(itemPath(path, i), KeyRemoved, a[i], nil)
+			case i >= len(a):
+				d.add(itemPath(path, i), KeyAdded, nil, b[i])
+			default:
+				d.compare(itemPath(path, i), a[i], b[i])
+			}
+		}
+	case *OrderedObject:
+		d.members(path, &a.orderedMap, &b.(*OrderedObject).orderedMap)
+	case *OrderedECMAArray:
+		d.members(path, &a.orderedMap, &b.(*OrderedECMAArray).orderedMap)
+	default:
+		if !diffEqual(a, b) {
+			d.add(path, ValueChanged, a, b)
+		}
+	}
+}
+
+func (d *differ) members(path string, a, b *orderedMap) {
+	var commonA, commonB []string
+	for _, key := range a.keys {
+		if _, ok := b.values[key]; ok {
+			commonA = append(commonA, key)
+			d.compare(memberPath(path, key), a.values[key], b.values[key])
+		} else {
+			d.add(memberPath(path, key), KeyRemoved, a.values[key], nil)
+		}
+	}
+	for _, key := range b.keys {
+		if _, ok := a.values[key]; ok {
+			commonB = append(commonB, key)
+		} else {
+			d.add(memberPath(path, key), KeyAdded, nil, b.values[key])
+		}
+	}
+	if !slices.Equal(commonA, commonB) {
+		d.add(path, OrderChanged, commonA, commonB)
+	}
+}
+
+func diffEqual(a, b interface{}) bool {
+	switch a := a.(type) {
+	case float64:
+		b := b.(float64)
+		return a == b || math.IsNaN(a) && math.IsNaN(b)
+	case time.Time:
+		return a.Equal(b.(time.Time))
+	case []byte:
+		return bytes.Equal(a, b.([]byte))
+	}
+	return a == b
+}
@@ -0,0 +1,115 @@
+package amf
+
+import (
+	"reflect"
+	"testing"
+)
+
+func mustEncodeText(t *testing.T, version AMFVersion, text string) []byte {
+	v, err := Parse(text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b []byte
+	if version == AMF0 {
+		b, err = AppendAMF0(nil, v)
+	} else {
+		b, err = AppendAMF3(nil, v)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+var diffTextCases = []struct {
+	version AMFVersion
+	a, b    string
+	want    []string
+}{
+	{AMF0, `{a: 1, b: "x"}`, `{a: 1, b: "x"}`, nil},
+	{AMF0, `{a: 1, b: "x"}`, `{b: "y", a: 1, c: true}`, []string{
+		`b: value changed: "x" -> "y"`,
+		`c: key added: true`,
+		`(root): order changed: [a b] -> [b a]`,
+	}},
+	{AMF3, `int(1)`, `1`, []string{`(root): type changed: int(1) -> 1`}},
+	{AMF3, `{a: [1, 2]}`, `{a: [1]}`, []string{`a[1]: key removed: 2`}},
+	{AMF3, `{"a b": {}}`, `{}`, []string{`["a b"]: key removed: object{0}`}},
+	{AMF3, `obj<Foo>{a: 1}`, `obj<Foo>{a: 1;}`, []string{
+		`(root): type changed: obj<Foo> sealed=1{1} -> obj<Foo> sealed=1 dynamic{1}`,
+	}},
+	{AMF0, `NaN`, `NaN`, nil},
+}
+
+func TestDiff(t *testing.T) {
+	for _, c := range diffTextCases {
+		diffs, err := Diff(mustEncodeText(t, c.version, c.a), mustEncodeText(t, c.version, c.b), c.version)
+		if err != nil {
+			t.Errorf("Diff(%s, %s): %s", c.a, c.b, err)
+			continue
+		}
+		var got []string
+		for _, d := range diffs {
+			got = append(got, d.String())
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("Diff(%s, %s) == %q, want %q", c.a, c.b, got, c.want)
+		}
+	}
+}
+
+func TestDiffReferences(t *testing.T) {
+	for _, c := range []struct {
+		version AMFVersion
+		a, b    []byte
+		want    []Difference
+	}{
+		{AMF0, []byte{0x0a, 0x00, 0x00, 0x00, 0x02,
+			0x03, 0x00, 0x00, 0x09,
+			0x07, 0x00, 0x01,
+		}, []byte{0x0a, 0x00, 0x00, 0x00, 0x02,
+			0x03, 0x00, 0x00, 0x09,
+			0x03, 0x00, 0x00, 0x09,
+		}, []Difference{{"[1]", RefChanged, "reference", "inline"}}},
+		// {self: <ref 0>} against itself and against {self: {}}
+		{AMF3, []byte{0x0a, 0x0b, 0x01, 0x09, 0x73, 0x65, 0x6c, 0x66, 0x0a, 0x00, 0x01},
+			[]byte{0x0a, 0x0b, 0x01, 0x09, 0x73, 0x65, 0x6c, 0x66, 0x0a, 0x00, 0x01}, nil},
+		{AMF3, []byte{0x0a, 0x0b, 0x01, 0x09, 0x73, 0x65, 0x6c, 0x66, 0x0a, 0x00, 0x01},
+			[]byte{0x0a, 0x0b, 0x01, 0x09, 0x73, 0x65, 0x6c, 0x66, 0x0a, 0x01, 0x01, 0x01}, []Difference{
+				{"self", RefChanged, "reference", "inline"},
+				{"self.self", KeyRemoved, nil, nil},
+			}},
+		// [{a: int(1)}, {a: int(2)}, <ref 1>] against the same ending in <ref 2>
+		{AMF3, []byte{0x09, 0x07, 0x01,
+			0x0a, 0x0b, 0x01, 0x03, 0x61, 0x04, 0x01, 0x01,
+			0x0a, 0x01, 0x00, 0x04, 0x02, 0x01,
+			0x0a, 0x02,
+		}, []byte{0x09, 0x07, 0x01,
+			0x0a, 0x0b, 0x01, 0x03, 0x61, 0x04, 0x01, 0x01,
+			0x0a, 0x01, 0x00, 0x04, 0x02, 0x01,
+			0x0a, 0x04,
+		}, []Difference{{"[2].a", ValueChanged, 1, 2}}},
+	} {
+		diffs, err := Diff(c.a, c.b, c.version)
+		if err != nil {
+			t.Errorf("Diff(%#v, %#v): %s", c.a, c.b, err)
+			continue
+		}
+		if len(diffs) != len(c.want) {
+			t.Errorf("Diff(%#v, %#v) == %v, want %v", c.a, c.b, diffs, c.want)
+			continue
+		}
+		for i, d := range diffs {
+			if d.Path != c.want[i].Path || d.Kind != c.want[i].Kind || c.want[i].A != nil && d.A != c.want[i].A {
+				t.Errorf("Diff(%#v, %#v)[%d] == %v, want %v", c.a, c.b, i, d, c.want[i])
+			}
+		}
+	}
+}
+
+func TestDiffInvalid(t *testing.T) {
+	if _, err := Diff([]byte{0x05}, []byte{0xff}, AMF0); err == nil {
+		t.Error("Diff of invalid data succeeded")
+	}
+}
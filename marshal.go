@@ -0,0 +1,271 @@
+package amf
+
+import (
+	"encoding"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AMF0Marshaler is implemented by types that encode as another value in AMF0,
+// for example a money type that encodes as a Number.
+type AMF0Marshaler interface {
+	MarshalAMF0() (interface{}, error)
+}
+
+// AMF3Marshaler is the AMF3 counterpart of AMF0Marshaler.
+type AMF3Marshaler interface {
+	MarshalAMF3() (interface{}, error)
+}
+
+// AMF0Unmarshaler is implemented by types that decode themselves from the
+// value produced by DecodeAMF0.
+type AMF0Unmarshaler interface {
+	UnmarshalAMF0(v interface{}) error
+}
+
+// AMF3Unmarshaler is the AMF3 counterpart of AMF0Unmarshaler.
+type AMF3Unmarshaler interface {
+	UnmarshalAMF3(v interface{}) error
+}
+
+// EncodeFrom encodes v in version, the counterpart of DecodeAs.
+func EncodeFrom[T any](v T, version AMFVersion) ([]byte, error) {
+	switch version {
+	case AMF0:
+		return AppendAMF0(nil, v)
+	case AMF3:
+		return AppendAMF3(nil, v)
+	}
+	return nil, fmt.Errorf("unsupported version %d", version)
+}
+
+// marshal replaces v by the value its Marshaler or TextMarshaler method
+// returns. ok is false if v implements neither.
+func marshal(version AMFVersion, v interface{}) (interface{}, bool, error) {
+	if m, ok := v.(AMF0Marshaler); ok && version == AMF0 {
+		mv, err := m.MarshalAMF0()
+		return mv, true, err
+	}
+	if m, ok := v.(AMF3Marshaler); ok && version == AMF3 {
+		mv, err := m.MarshalAMF3()
+		return mv, true, err
+	}
+	if m, ok := v.(encoding.TextMarshaler); ok {
+		text, err := m.MarshalText()
+		return string(text), true, err
+	}
+	return nil, false, nil
+}
+
+var marshalerTypes = []reflect.Type{
+	reflect.TypeFor[AMF0Marshaler](),
+	reflect.TypeFor[AMF3Marshaler](),
+	reflect.TypeFor[encoding.TextMarshaler](),
+}
+
+// pointerMarshaler reports whether the pointer type t has a marshaling method
+// that its element type lacks.
+func pointerMarshaler(t reflect.Type) bool {
+	for _, m := range marshalerTypes {
+		if t.Implements(m) && !t.Elem().Implements(m) {
+			return true
+		}
+	}
+	return false
+}
+
+// Field is a struct field encoded as a member.
+type Field struct {
+	Name      string // of the member
+	Index     int    // of the field in the struct
+	OmitEmpty bool
+}
+
+var fieldCache sync.Map // map[reflect.Type][]Field
+
+// structFields lists the exported fields of t with their AMF member names,
+// taken from the `amf:"name,omitempty"` tag or the field name.
+func structFields(t reflect.Type) []Field {
+	if fields, ok := fieldCache.Load(t); ok {
+		return fields.([]Field)
+	}
+	var fields []Field
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+		tag := sf.Tag.Get("amf")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if name == "" {
+			name = sf.Name
+		}
+		fields = append(fields, Field{Name: name, Index: i, OmitEmpty: opts == "omitempty"})
+	}
+	fieldCache.Store(t, fields)
+	return fields
+}
+
+// Fields returns the fields of the struct type t that are encoded as members,
+// in order, for tools generating code from Go types.
+func Fields(t reflect.Type) []Field {
+	return slices.Clone(structFields(t))
+}
+
+// reflectValue converts the Go value rv into one of the types handled by the
+// encoders. ok is false if there is no AMF form for it.
+func (e *Encoder) reflectValue(rv reflect.Value) (interface{}, bool) {
+	switch rv.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if rv.IsNil() {
+			return nil, true
+		}
+		return rv.Elem().Interface(), true
+	case reflect.String:
+		return rv.String(), true
+	case reflect.Bool:
+		return rv.Bool(), true
+	case reflect.Slice, reflect.Array:
+		if rv.Kind() == reflect.Slice && rv.IsNil() {
+			return nil, true
+		}
+		if rv.Kind() == reflect.Slice && rv.Type().Elem().Kind() == reflect.Uint8 {
+			return rv.Bytes(), true
+		}
+		result := make([]interface{}, rv.Len())
+		for i := range result {
+			result[i] = rv.Index(i).Interface()
+		}
+		return result, true
+	case reflect.Map:
+		if rv.Type().Key().Kind() != reflect.String {
+			return nil, false
+		}
+		if rv.IsNil() {
+			return nil, true
+		}
+		result := make(map[string]interface{}, rv.Len())
+		for it := rv.MapRange(); it.Next(); {
+			result[it.Key().String()] = it.Value().Interface()
+		}
+		return result, true
+	case reflect.Struct:
+		result := &OrderedObject{}
+		for _, f := range structFields(rv.Type()) {
+			fv := rv.Field(f.Index)
+			if f.OmitEmpty && fv.IsZero() {
+				continue
+			}
+			result.Set(f.Name, fv.Interface())
+		}
+		if class, ok := RegisteredClass(rv.Type()); ok {
+			result.Class, result.Sealed = class, result.Len()
+		}
+		return result, true
+	}
+	return nil, false
+}
+
+// encodeOther encodes the values not matched by the type switch of the
+// encoders: Marshalers, numbers of any Go type, then anything reflectValue
+// can convert.
+func (e *Encoder) encodeOther(b []byte, version AMFVersion, v interface{}, encode func([]byte, interface{}) ([]byte, error)) ([]byte, error) {
+	// Pointers encode as the values they point to, so that *time.Time is a
+	// Date rather than the text of MarshalText, unless only the pointer has a
+	// marshaling method.
+	if rv := reflect.ValueOf(v); rv.Kind() == reflect.Pointer && !rv.IsNil() && !pointerMarshaler(rv.Type()) {
+		return encode(b, rv.Elem().Interface())
+	}
+	if mv, ok, err := marshal(version, v); ok {
+		if err != nil {
+			return b, err
+		}
+		return encode(b, mv)
+	}
+	if n, ok := toNumber(v); ok {
+		if version == AMF3 {
+			return e.encodeNumber3(b, n)
+		}
+		f, err := e.float(n)
+		if err != nil {
+			return b, err
+		}
+		return encodeNumber(b, f), nil
+	}
+	if rv := reflect.ValueOf(v); version == AMF3 && e.Collection != Array && isCollection(rv) {
+		if e.Collection == Vector {
+			return e.encodeVector3(b, rv)
+		}
+		return e.encodeArrayCollection3(b, rv)
+	}
+	if rv, ok := e.reflectValue(reflect.ValueOf(v)); ok {
+		return encode(b, rv)
+	}
+	return b, fmt.Errorf("type %T not supported", v)
+}
+
+// isCollection reports whether rv is a slice or array that Encoder.Collection
+// applies to.
+func isCollection(rv reflect.Value) bool {
+	switch rv.Kind() {
+	case reflect.Slice:
+		return !rv.IsNil() && rv.Type().Elem().Kind() != reflect.Uint8
+	case reflect.Array:
+		return true
+	}
+	return false
+}
+
+var timeType = reflect.TypeFor[time.Time]()
+
+// vectorType returns the vector marker for items of type t, with the name of
+// the item type as ActionScript has it, which object vectors are written with.
+func vectorType(t reflect.Type) (byte, string) {
+	if t.Kind() == reflect.Pointer && !pointerMarshaler(t) {
+		return vectorType(t.Elem())
+	}
+	switch {
+	case t == timeType:
+		return amf3VectorObject, "Date"
+	case t.Implements(reflect.TypeFor[AMF0Marshaler]()) || t.Implements(reflect.TypeFor[AMF3Marshaler]()):
+		return amf3VectorObject, "*"
+	case t.Implements(reflect.TypeFor[encoding.TextMarshaler]()):
+		return amf3VectorObject, "String"
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return amf3VectorObject, "Boolean"
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint8, reflect.Uint16:
+		return amf3VectorInt, "int"
+	case reflect.Uint32:
+		return amf3VectorUint, "uint"
+	case reflect.Int, reflect.Int64, reflect.Uint, reflect.Uint64, reflect.Uintptr, reflect.Float32, reflect.Float64:
+		return amf3VectorDouble, "Number"
+	case reflect.String:
+		return amf3VectorObject, "String"
+	case reflect.Slice, reflect.Array:
+		switch {
+		case t == sliceType:
+			return amf3VectorObject, "Array"
+		case t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8:
+			return amf3VectorObject, "flash.utils.ByteArray"
+		}
+		_, item := vectorType(t.Elem())
+		return amf3VectorObject, "__AS3__.vec::Vector.<" + item + ">"
+	case reflect.Struct:
+		if class, ok := RegisteredClass(t); ok {
+			return amf3VectorObject, class
+		}
+		return amf3VectorObject, "Object"
+	case reflect.Map:
+		return amf3VectorObject, "Object"
+	}
+	return amf3VectorObject, "*"
+}
@@ -0,0 +1,249 @@
+package amf
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type money int64 // cents
+
+func (m money) MarshalAMF0() (interface{}, error) {
+	return float64(m) / 100, nil
+}
+
+func (m money) MarshalAMF3() (interface{}, error) {
+	return fmt.Sprintf("%d.%02d", m/100, m%100), nil
+}
+
+func (m *money) UnmarshalAMF0(v interface{}) error {
+	f, ok := v.(float64)
+	if !ok {
+		return fmt.Errorf("invalid money %#v", v)
+	}
+	*m = money(f*100 + 0.5)
+	return nil
+}
+
+func (m *money) UnmarshalAMF3(v interface{}) error {
+	s, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("invalid money %#v", v)
+	}
+	var units, cents int64
+	if _, err := fmt.Sscanf(s, "%d.%d", &units, &cents); err != nil {
+		return err
+	}
+	*m = money(units*100 + cents)
+	return nil
+}
+
+type userID struct {
+	n int
+}
+
+func (id userID) MarshalText() ([]byte, error) {
+	return []byte(fmt.Sprintf("u-%d", id.n)), nil
+}
+
+func (id *userID) UnmarshalText(text []byte) error {
+	_, err := fmt.Sscanf(string(text), "u-%d", &id.n)
+	return err
+}
+
+type account struct {
+	ID      userID
+	Balance money            `amf:"balance"`
+	Tags    []string         `amf:"tags,omitempty"`
+	Limits  map[string]int16 `amf:"limits,omitempty"`
+	Parent  *account         `amf:"parent"`
+	Created time.Time        `amf:"created"`
+	Secret  string           `amf:"-"`
+	hidden  int
+}
+
+var epoch1000 = time.UnixMilli(1000000)
+
+var marshalCases = []struct {
+	in   interface{}
+	amf0 []byte
+	amf3 []byte
+}{
+	{money(1250),
+		[]byte{0x00, 0x40, 0x29, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00},
+		[]byte{0x06, 0x0b, 0x31, 0x32, 0x2e, 0x35, 0x30}},
+	{userID{7},
+		[]byte{0x02, 0x00, 0x03, 0x75, 0x2d, 0x37},
+		[]byte{0x06, 0x07, 0x75, 0x2d, 0x37}},
+	{&epoch1000,
+		[]byte{0x0b, 0x41, 0x2e, 0x84, 0x80, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00},
+		[]byte{0x08, 0x01, 0x41, 0x2e, 0x84, 0x80, 0x00, 0x00, 0x00, 0x00}},
+	{[]int16{1, 2},
+		[]byte{0x0a, 0x00, 0x00, 0x00, 0x02,
+			0x00, 0x3f, 0xf0, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+			0x00, 0x40, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00},
+		[]byte{0x09, 0x05, 0x01, 0x04, 0x01, 0x04, 0x02}},
+	{struct {
+		B bool `amf:"b"`
+		A *int `amf:"a"`
+		c int
+	}{B: true},
+		[]byte{0x03,
+			0x00, 0x01, 0x62, 0x01, 0x01,
+			0x00, 0x01, 0x61, 0x05,
+			0x00, 0x00, 0x09},
+		[]byte{0x0a, 0x0b, 0x01,
+			0x03, 0x62, 0x03,
+			0x03, 0x61, 0x01,
+			0x01}},
+}
+
+func TestMarshal(t *testing.T) {
+	for _, c := range marshalCases {
+		got0, err := AppendAMF0(nil, c.in)
+		if err != nil {
+			t.Errorf("AppendAMF0(%#v): %s", c.in, err)
+		} else if !bytes.Equal(got0, c.amf0) {
+			t.Errorf("AppendAMF0(%#v) == %#v, want %#v", c.in, got0, c.amf0)
+		}
+		got3, err := AppendAMF3(nil, c.in)
+		if err != nil {
+			t.Errorf("AppendAMF3(%#v): %s", c.in, err)
+		} else if !bytes.Equal(got3, c.amf3) {
+			t.Errorf("AppendAMF3(%#v) == %#v, want %#v", c.in, got3, c.amf3)
+		}
+	}
+	if _, err := AppendAMF0(nil, make(chan int)); err == nil {
+		t.Errorf("AppendAMF0(chan) succeeded")
+	}
+}
+
+func TestUnmarshalRoundTrip(t *testing.T) {
+	in := account{
+		ID:      userID{42},
+		Balance: 1999,
+		Tags:    []string{"a", "b"},
+		Limits:  map[string]int16{"daily": 100},
+		Parent:  &account{ID: userID{1}, Balance: 5, Created: time.Unix(1400000000, 0)},
+		Created: time.Unix(1500000000, 0),
+		Secret:  "x",
+		hidden:  1,
+	}
+	want := in
+	want.Secret = ""
+	want.hidden = 0
+	for _, version := range []AMFVersion{AMF0, AMF3} {
+		var data []byte
+		var err error
+		if version == AMF0 {
+			data, err = AppendAMF0(nil, in)
+		} else {
+			data, err = AppendAMF3(nil, in)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got account
+		if err := Unmarshal(data, version, &got); err != nil {
+			t.Errorf("Unmarshal(%d): %s", version, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Unmarshal(%d) == %#v, want %#v", version, got, want)
+		}
+	}
+}
+
+type node struct {
+	Name     string  `amf:"name"`
+	Parent   *node   `amf:"parent"`
+	Children []*node `amf:"children"`
+}
+
+func TestUnmarshalGraph(t *testing.T) {
+	root := &node{Name: "root"}
+	for _, name := range []string{"a", "b"} {
+		root.Children = append(root.Children, &node{Name: name, Parent: root})
+	}
+	for _, version := range []AMFVersion{AMF0, AMF3} {
+		data, err := EncodeFrom(root, version)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got node
+		if err := Unmarshal(data, version, &got); err != nil {
+			t.Fatalf("Unmarshal(%d): %s", version, err)
+		}
+		if len(got.Children) != 2 || got.Children[0].Parent != &got || got.Children[1].Parent != &got ||
+			got.Children[1].Name != "b" {
+			t.Errorf("Unmarshal(%d) == %+v", version, got)
+		}
+		p, err := DecodeAs[*node](data, version)
+		if err != nil || len(p.Children) != 2 || p.Children[0].Parent != p {
+			t.Errorf("DecodeAs[*node](%d) == %+v, %v", version, p, err)
+		}
+	}
+
+	// {self: [<ref 0>]} can't be held in struct values.
+	type loop struct {
+		Self []loop `amf:"self"`
+	}
+	data := []byte{0x0a, 0x0b, 0x01, 0x09, 's', 'e', 'l', 'f', 0x09, 0x03, 0x01, 0x0a, 0x00, 0x01}
+	var l loop
+	if err := Unmarshal(data, AMF3, &l); err == nil || !strings.Contains(err.Error(), "cycle through") {
+		t.Errorf("Unmarshal of a cycle into struct values == %v", err)
+	}
+}
+
+func TestDecodeAs(t *testing.T) {
+	for _, version := range []AMFVersion{AMF0, AMF3} {
+		data, err := EncodeFrom(account{ID: userID{7}, Limits: map[string]int16{"daily": 5}}, version)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := DecodeAs[account](data, version)
+		if err != nil || got.ID.n != 7 || got.Limits["daily"] != 5 {
+			t.Errorf("DecodeAs[account](%d) == %#v, %v", version, got, err)
+		}
+	}
+	int3 := []byte{0x04, 0x7f}
+	double3 := []byte{0x05, 0x40, 0x5f, 0xc0, 0x00, 0x00, 0x00, 0x00, 0x00}
+	if v, err := DecodeAs[uint8](int3, AMF3); err != nil || v != 127 {
+		t.Errorf("DecodeAs[uint8](int) == %d, %v", v, err)
+	}
+	if v, err := DecodeAs[int64](double3, AMF3); err != nil || v != 127 {
+		t.Errorf("DecodeAs[int64](double) == %d, %v", v, err)
+	}
+	if v, err := DecodeAs[float32](int3, AMF3); err != nil || v != 127 {
+		t.Errorf("DecodeAs[float32](int) == %v, %v", v, err)
+	}
+	if v, err := DecodeAs[int8]([]byte{0x04, 0x81, 0x00}, AMF3); err == nil || v != 0 {
+		t.Errorf("DecodeAs[int8](128) == %d, %v, want overflow", v, err)
+	}
+	if _, err := EncodeFrom(1, 2); err == nil {
+		t.Error("EncodeFrom with version 2 succeeded")
+	}
+}
+
+func TestUnmarshalErrors(t *testing.T) {
+	for _, c := range []struct {
+		in     []byte
+		target interface{}
+		err    string
+	}{
+		{[]byte{0x04, 0x01}, new(string), "cannot unmarshal int into string"},
+		{[]byte{0x05, 0x40, 0x0c, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}, new(int), "not an integer"},
+		{[]byte{0x04, 0x82, 0x2c}, new(int8), "overflows int8"},
+		{[]byte{0x04, 0xff, 0xff, 0xff, 0xff}, new(uint), "overflows uint"},
+		{[]byte{0x09, 0x05, 0x01, 0x04, 0x01, 0x03}, new([]int), "[1]: cannot unmarshal bool into int"},
+		{[]byte{0x04, 0x01}, 0, "non-nil pointer"},
+	} {
+		err := Unmarshal(c.in, AMF3, c.target)
+		if err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("Unmarshal(%#v, %T) == %v, want %q", c.in, c.target, err, c.err)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package amf
+
+import "slices"
+
+type orderedMap struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+func (m *orderedMap) Len() int {
+	return len(m.keys)
+}
+
+// Keys returns the keys in insertion order. The slice must not be modified.
+func (m *orderedMap) Keys() []string {
+	return m.keys
+}
+
+func (m *orderedMap) Get(key string) (interface{}, bool) {
+	value, ok := m.values[key]
+	return value, ok
+}
+
+// Set adds or replaces a member. Replaced members keep their position.
+func (m *orderedMap) Set(key string, value interface{}) {
+	if m.values == nil {
+		m.values = make(map[string]interface{})
+	}
+	if _, ok := m.values[key]; !ok {
+		m.keys = append(m.keys, key)
+	}
+	m.values[key] = value
+}
+
+func (m *orderedMap) delete(key string) int {
+	if _, ok := m.values[key]; !ok {
+		return -1
+	}
+	delete(m.values, key)
+	i := slices.Index(m.keys, key)
+	m.keys = slices.Delete(m.keys, i, i+1)
+	return i
+}
+
+// OrderedObject is an object whose members are encoded in insertion order. A
+// Decoder with Ordered set produces it for objects and typed objects.
+//
+// For AMF3 the first Sealed members are written as sealed traits members and
+// the remaining ones as dynamic members. An anonymous object with neither
+// Sealed nor Dynamic set, as built by hand, is dynamic.
+type OrderedObject struct {
+	orderedMap
+	Class   string
+	Sealed  int
+	Dynamic bool
+}
+
+func (o *OrderedObject) Delete(key string) {
+	if i := o.delete(key); i >= 0 && i < o.Sealed {
+		o.Sealed--
+	}
+}
+
+func (o *OrderedObject) sealed() int {
+	return min(o.Sealed, o.Len())
+}
+
+func (o *OrderedObject) dynamic() bool {
+	return o.Dynamic || o.Len() > o.Sealed || o.Class == "" && o.Sealed == 0
+}
+
+// OrderedECMAArray is the ordered counterpart of ECMAArray.
+//
+// Count is the member count an AMF0 ECMA array declared, which Flash Player
+// often writes as 0. Decoders read members up to the end marker whatever the
+// count. The AMF0 encoder writes Count when it is not 0, so that a decoded
+// array is written back with its count, and the number of members otherwise.
+type OrderedECMAArray struct {
+	orderedMap
+	Count int
+}
+
+// count is the member count the AMF0 encoder writes.
+func (a *OrderedECMAArray) count() int {
+	if a.Count != 0 {
+		return a.Count
+	}
+	return a.Len()
+}
+
+func (a *OrderedECMAArray) Delete(key string) {
+	a.delete(key)
+}
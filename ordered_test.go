@@ -0,0 +1,126 @@
+package amf
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestOrderedObject(t *testing.T) {
+	o := &OrderedObject{Class: "Foo"}
+	o.Set("b", 1)
+	o.Set("a", 2)
+	o.Set("c", 3)
+	o.Sealed = 2
+	o.Set("b", 4)
+	if keys := o.Keys(); !reflect.DeepEqual(keys, []string{"b", "a", "c"}) {
+		t.Errorf("Keys() == %#v", keys)
+	}
+	if v, ok := o.Get("b"); !ok || v != 4 {
+		t.Errorf("Get(b) == %#v, %v", v, ok)
+	}
+	o.Delete("a")
+	o.Delete("missing")
+	if keys := o.Keys(); !reflect.DeepEqual(keys, []string{"b", "c"}) || o.Sealed != 1 {
+		t.Errorf("Keys() == %#v, Sealed == %d", keys, o.Sealed)
+	}
+	if _, ok := o.Get("a"); ok {
+		t.Errorf("Get(a) found deleted member")
+	}
+}
+
+var orderedCases = []struct {
+	version AMFVersion
+	in      []byte
+	keys    []string
+}{
+	{AMF0, []byte{0x03,
+		0x00, 0x01, 0x62, 0x01, 0x01,
+		0x00, 0x01, 0x61, 0x05,
+		0x00, 0x00, 0x09}, []string{"b", "a"}},
+	{AMF0, []byte{0x10, 0x00, 0x03, 0x46, 0x6f, 0x6f,
+		0x00, 0x01, 0x62, 0x01, 0x01,
+		0x00, 0x01, 0x61, 0x05,
+		0x00, 0x00, 0x09}, []string{"b", "a"}},
+	{AMF0, []byte{0x08, 0x00, 0x00, 0x00, 0x02,
+		0x00, 0x01, 0x32, 0x05,
+		0x00, 0x01, 0x31, 0x05,
+		0x00, 0x00, 0x09}, []string{"2", "1"}},
+	{AMF3, []byte{0x0a, 0x2b, 0x07, 0x46, 0x6f, 0x6f,
+		0x03, 0x62, 0x03, 0x61,
+		0x04, 0x01,
+		0x04, 0x02,
+		0x03, 0x63, 0x04, 0x03,
+		0x01}, []string{"b", "a", "c"}},
+	{AMF3, []byte{0x0a, 0x23, 0x07, 0x46, 0x6f, 0x6f,
+		0x03, 0x62, 0x03, 0x61,
+		0x04, 0x01,
+		0x04, 0x02}, []string{"b", "a"}},
+	{AMF3, []byte{0x0a, 0x0b, 0x01,
+		0x03, 0x62, 0x04, 0x01,
+		0x03, 0x61, 0x04, 0x02,
+		0x01}, []string{"b", "a"}},
+	{AMF3, []byte{0x09, 0x01,
+		0x03, 0x7a, 0x04, 0x01,
+		0x03, 0x79, 0x04, 0x02,
+		0x01}, []string{"z", "y"}},
+}
+
+func TestOrderedRoundTrip(t *testing.T) {
+	for _, c := range orderedCases {
+		d := &Decoder{Ordered: true}
+		var got interface{}
+		var err error
+		if c.version == AMF0 {
+			got, _, err = d.DecodeAMF0(c.in)
+		} else {
+			got, _, err = d.DecodeAMF3(c.in)
+		}
+		if err != nil {
+			t.Errorf("decode(%#v): %s", c.in, err)
+			continue
+		}
+		var keys []string
+		switch o := got.(type) {
+		case *OrderedObject:
+			keys = o.Keys()
+		case *OrderedECMAArray:
+			keys = o.Keys()
+		default:
+			t.Errorf("decode(%#v) == %T", c.in, got)
+			continue
+		}
+		if !reflect.DeepEqual(keys, c.keys) {
+			t.Errorf("decode(%#v) keys %#v, want %#v", c.in, keys, c.keys)
+		}
+		buf := &bytes.Buffer{}
+		if c.version == AMF0 {
+			_, err = EncodeAMF0(buf, got)
+		} else {
+			_, err = EncodeAMF3(buf, got)
+		}
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if !bytes.Equal(buf.Bytes(), c.in) {
+			t.Errorf("encode(%#v) == %#v, want %#v", got, buf.Bytes(), c.in)
+		}
+	}
+}
+
+func TestEncoderKeyOrder(t *testing.T) {
+	e := &Encoder{KeyOrder: func(a, b string) int { return -strings.Compare(a, b) }}
+	got, err := e.AppendAMF0(nil, map[string]interface{}{"a": nil, "b": nil})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{0x03,
+		0x00, 0x01, 0x62, 0x05,
+		0x00, 0x01, 0x61, 0x05,
+		0x00, 0x00, 0x09}
+	if !bytes.Equal(got, want) {
+		t.Errorf("AppendAMF0 == %#v, want %#v", got, want)
+	}
+}
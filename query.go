@@ -0,0 +1,658 @@
+package amf
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+)
+
+// Query decodes the value at path within the first value of data, skipping
+// over the other values without decoding them. A path lists member names and
+// array indices as in Diff, such as body[0].user.id or headers["Content-Type"];
+// the empty path selects the value itself.
+func Query(data []byte, version AMFVersion, path string) (interface{}, error) {
+	v, err := QueryRaw(data, version, path)
+	if err != nil {
+		return nil, err
+	}
+	return v.Decode()
+}
+
+// QueryRaw is Query returning the undecoded value.
+func QueryRaw(data []byte, version AMFVersion, path string) (RawValue, error) {
+	w := &rawWalker{data: data}
+	v, err := w.raw(version, 0)
+	if err != nil {
+		return RawValue{}, err
+	}
+	return v.Query(path)
+}
+
+// RawValue is a value left undecoded within its message. Finding one walks
+// the markers before it, recording where referenced values start rather than
+// decoding them, so that Decode can resolve references out of the value.
+type RawValue struct {
+	version     AMFVersion
+	data        []byte
+	offset, end int
+	tables      rawTables
+}
+
+// Version returns the encoding of the value, AMF3 for values after an AVM+
+// marker.
+func (v RawValue) Version() AMFVersion {
+	return v.version
+}
+
+// Bytes returns the encoding of the value, which may refer to strings and
+// objects before it.
+func (v RawValue) Bytes() []byte {
+	return v.data[v.offset:v.end]
+}
+
+// Query returns the value at path within v. References are followed, so the
+// result of a path through a reference is the referenced value.
+func (v RawValue) Query(path string) (RawValue, error) {
+	steps, err := parsePath(path)
+	if err != nil {
+		return RawValue{}, err
+	}
+	// One walker serves every step, as its tables only grow along the path.
+	w := &rawWalker{data: v.data, rawTables: v.tables.clone()}
+	at := ""
+	for _, s := range steps {
+		if s.index < 0 {
+			at = memberPath(at, s.key)
+		} else {
+			at = itemPath(at, s.index)
+		}
+		var offset int
+		if v.version == AMF0 {
+			offset, err = w.value0(v.offset, &s)
+		} else {
+			offset, err = w.value3(v.offset, &s)
+		}
+		if err == nil {
+			v, err = w.raw(v.version, offset)
+		}
+		if err != nil {
+			return RawValue{}, fmt.Errorf("%s: %s", at, err)
+		}
+	}
+	v.tables = v.tables.clone()
+	if err := v.setEnd(); err != nil {
+		return RawValue{}, err
+	}
+	return v, nil
+}
+
+// setEnd walks the value to find where it ends.
+func (v *RawValue) setEnd() error {
+	w := &rawWalker{data: v.data, rawTables: v.tables.clone()}
+	var err error
+	if v.version == AMF0 {
+		v.end, err = w.value0(v.offset, nil)
+	} else {
+		v.end, err = w.value3(v.offset, nil)
+	}
+	return err
+}
+
+// Decode decodes the value, along with the values it refers to.
+func (v RawValue) Decode() (interface{}, error) {
+	return v.decode(NewDecoder())
+}
+
+func (v RawValue) decode(d *Decoder) (interface{}, error) {
+	if v.data == nil {
+		return nil, fmt.Errorf("EOF")
+	}
+	d.raw = &v
+	var value interface{}
+	var err error
+	if v.version == AMF0 {
+		d.objects0 = make([]interface{}, len(v.tables.objects0))
+		for i := range d.objects0 {
+			d.objects0[i] = lazyRef{}
+		}
+		value, _, err = d.decodeAMF0(v.data[v.offset:])
+	} else {
+		for _, s := range v.tables.strings3 {
+			d.strings3 = append(d.strings3, string(s))
+		}
+		for _, t := range v.tables.traits3 {
+			d.traits3 = append(d.traits3, t.traits())
+		}
+		d.objects3 = make([]interface{}, len(v.tables.objects3))
+		for i := range d.objects3 {
+			d.objects3[i] = lazyRef{}
+		}
+		value, _, err = d.decodeAMF3(v.data[v.offset:])
+	}
+	return value, err
+}
+
+// ref returns the referenced value before v with the reference table index.
+func (v *RawValue) ref(index int) RawValue {
+	ref := RawValue{version: v.version, data: v.data}
+	if v.version == AMF0 {
+		ref.offset = v.tables.objects0[index].offset
+		ref.tables.objects0 = v.tables.objects0[:index:index]
+	} else {
+		r := v.tables.objects3[index]
+		ref.offset = r.offset
+		ref.tables.strings3 = v.tables.strings3[:r.strings:r.strings]
+		ref.tables.traits3 = v.tables.traits3[:r.traits:r.traits]
+		ref.tables.objects3 = v.tables.objects3[:index:index]
+	}
+	return ref
+}
+
+// lazyRef stands in the reference table of a Decoder for a value before the
+// RawValue it decodes, and is decoded when referenced.
+type lazyRef struct{}
+
+// resolve returns the reference table entry ref, decoding a lazyRef.
+func (d *Decoder) resolve(table []interface{}, ref int) (interface{}, error) {
+	if _, ok := table[ref].(lazyRef); !ok {
+		return table[ref], nil
+	}
+	value, err := d.raw.ref(ref).decode(&Decoder{Ordered: d.Ordered, KeepUndefined: d.KeepUndefined})
+	if err != nil {
+		return nil, err
+	}
+	table[ref] = value
+	return value, nil
+}
+
+// pathStep is a member name, or an array index when index >= 0. With visit
+// set, the walk calls it for every member, or every item when index >= 0,
+// with the tables as they were at the start of the value.
+type pathStep struct {
+	key   string
+	index int
+	visit func(key []byte, offset, end int, tables rawTables) error
+}
+
+func parsePath(path string) ([]pathStep, error) {
+	var steps []pathStep
+	for i := 0; i < len(path); {
+		switch {
+		case path[i] == '[' && i+1 < len(path) && path[i+1] == '"':
+			quoted, err := strconv.QuotedPrefix(path[i+1:])
+			if err != nil {
+				return nil, fmt.Errorf("invalid path %q", path)
+			}
+			key, _ := strconv.Unquote(quoted)
+			i += 1 + len(quoted)
+			if i >= len(path) || path[i] != ']' {
+				return nil, fmt.Errorf("invalid path %q", path)
+			}
+			steps = append(steps, pathStep{key: key, index: -1})
+			i++
+		case path[i] == '[':
+			j := i + 1
+			for j < len(path) && path[j] >= '0' && path[j] <= '9' {
+				j++
+			}
+			index, err := strconv.Atoi(path[i+1 : j])
+			if err != nil || j >= len(path) || path[j] != ']' {
+				return nil, fmt.Errorf("invalid path %q", path)
+			}
+			steps = append(steps, pathStep{index: index})
+			i = j + 1
+		default:
+			if len(steps) > 0 {
+				if path[i] != '.' {
+					return nil, fmt.Errorf("invalid path %q", path)
+				}
+				i++
+			}
+			j := i
+			for j < len(path) && isIdentChar(path[j], j == i) {
+				j++
+			}
+			if j == i {
+				return nil, fmt.Errorf("invalid path %q", path)
+			}
+			steps = append(steps, pathStep{key: path[i:j], index: -1})
+			i = j
+		}
+	}
+	return steps, nil
+}
+
+// rawRef is where a referenced value starts, with the sizes of the string and
+// traits tables before it.
+type rawRef struct {
+	offset, strings, traits int
+}
+
+type rawTraits struct {
+	class          []byte
+	sealed         [][]byte
+	dynamic        bool
+	externalizable bool
+}
+
+func (t rawTraits) traits() traits3 {
+	result := traits3{class: string(t.class), dynamic: t.dynamic, externalizable: t.externalizable}
+	for _, name := range t.sealed {
+		result.sealed = append(result.sealed, string(name))
+	}
+	return result
+}
+
+// rawTables mirror the reference tables of a Decoder, holding offsets and
+// slices of the message instead of decoded values.
+type rawTables struct {
+	objects0 []rawRef
+	strings3 [][]byte
+	objects3 []rawRef
+	traits3  []rawTraits
+}
+
+// clone caps the tables so that appending to them leaves the original alone.
+func (t rawTables) clone() rawTables {
+	return rawTables{
+		objects0: t.objects0[:len(t.objects0):len(t.objects0)],
+		strings3: t.strings3[:len(t.strings3):len(t.strings3)],
+		objects3: t.objects3[:len(t.objects3):len(t.objects3)],
+		traits3:  t.traits3[:len(t.traits3):len(t.traits3)],
+	}
+}
+
+// rawWalker walks over encoded values, filling its tables as a Decoder would.
+// Given a path step, its value methods return the offset of the matching
+// member or item; otherwise they return the end of the value.
+type rawWalker struct {
+	data []byte
+	rawTables
+	// referenced is set once a walked value refers to a table entry.
+	referenced bool
+}
+
+// raw returns the value at offset, following references and AVM+ markers to
+// the encoding of the value itself.
+func (w *rawWalker) raw(version AMFVersion, offset int) (RawValue, error) {
+	if offset >= len(w.data) {
+		return RawValue{}, fmt.Errorf("EOF")
+	}
+	if version == AMF0 {
+		switch w.data[offset] {
+		case amf0Reference:
+			if offset+3 > len(w.data) {
+				return RawValue{}, fmt.Errorf("EOF")
+			}
+			ref := int(binary.BigEndian.Uint16(w.data[offset+1 : offset+3]))
+			if ref >= len(w.objects0) {
+				return RawValue{}, fmt.Errorf("invalid reference %d", ref)
+			}
+			offset = w.objects0[ref].offset
+			w.objects0 = w.objects0[:ref]
+		case amf0AVMPlus:
+			version, offset = AMF3, offset+1
+			w.strings3, w.objects3, w.traits3 = nil, nil, nil
+		}
+	}
+	if version == AMF3 && offset < len(w.data) {
+		switch w.data[offset] {
+		case amf3Date, amf3Array, amf3Object, amf3ByteArray, amf3VectorInt, amf3VectorUint, amf3VectorDouble, amf3VectorObject:
+			ref, _, err := decodeU29(w.data[offset+1:])
+			if err != nil {
+				return RawValue{}, err
+			}
+			if ref&1 == 0 {
+				ref >>= 1
+				if ref >= len(w.objects3) {
+					return RawValue{}, fmt.Errorf("invalid object ref %d", ref)
+				}
+				r := w.objects3[ref]
+				offset = r.offset
+				w.strings3, w.traits3, w.objects3 = w.strings3[:r.strings], w.traits3[:r.traits], w.objects3[:ref]
+			}
+		}
+	}
+	return RawValue{version: version, data: w.data, offset: offset, tables: w.rawTables.clone()}, nil
+}
+
+func (w *rawWalker) need(offset, n int) error {
+	if offset+n > len(w.data) {
+		return fmt.Errorf("EOF")
+	}
+	return nil
+}
+
+func (w *rawWalker) value0(offset int, step *pathStep) (int, error) {
+	if err := w.need(offset, 1); err != nil {
+		return 0, err
+	}
+	start := offset
+	size := 0
+	switch w.data[offset] {
+	case amf0Number:
+		size = 9
+	case amf0Boolean:
+		size = 2
+	case amf0String:
+		if err := w.need(offset, 3); err != nil {
+			return 0, err
+		}
+		size = 3 + int(binary.BigEndian.Uint16(w.data[offset+1:]))
+	case amf0StringExt:
+		if err := w.need(offset, 5); err != nil {
+			return 0, err
+		}
+		size = 5 + int(binary.BigEndian.Uint32(w.data[offset+1:]))
+	case amf0Null, amf0Undefined:
+		size = 1
+	case amf0Reference:
+		w.referenced = true
+		size = 3
+	case amf0Date:
+		size = 11
+	case amf0Object:
+		w.objects0 = append(w.objects0, rawRef{offset: start})
+		return w.members0(offset+1, step)
+	case amf0TypedObject:
+		if err := w.need(offset, 3); err != nil {
+			return 0, err
+		}
+		w.objects0 = append(w.objects0, rawRef{offset: start})
+		return w.members0(offset+3+int(binary.BigEndian.Uint16(w.data[offset+1:])), step)
+	case amf0Array:
+		w.objects0 = append(w.objects0, rawRef{offset: start})
+		return w.members0(offset+5, step)
+	case amf0StrictArr:
+		if err := w.need(offset, 5); err != nil {
+			return 0, err
+		}
+		w.objects0 = append(w.objects0, rawRef{offset: start})
+		num := int(binary.BigEndian.Uint32(w.data[offset+1:]))
+		return w.items(offset+5, num, step, w.value0)
+	case amf0AVMPlus:
+		w.strings3, w.objects3, w.traits3 = nil, nil, nil
+		return w.value3(offset+1, step)
+	default:
+		return 0, fmt.Errorf("unsupported type 0x%0X", w.data[offset])
+	}
+	if step != nil {
+		return 0, fmt.Errorf("not an object or array")
+	}
+	if err := w.need(offset, size); err != nil {
+		return 0, err
+	}
+	return offset + size, nil
+}
+
+func (w *rawWalker) members0(offset int, step *pathStep) (int, error) {
+	if step != nil && step.index >= 0 {
+		return 0, fmt.Errorf("not an array")
+	}
+	for {
+		if err := w.need(offset, 2); err != nil {
+			return 0, err
+		}
+		klen := int(binary.BigEndian.Uint16(w.data[offset:]))
+		if err := w.need(offset, 2+klen); err != nil {
+			return 0, err
+		}
+		key := w.data[offset+2 : offset+2+klen]
+		offset += 2 + klen
+		if klen == 0 {
+			if offset >= len(w.data) || w.data[offset] != byte(amf0ObjectEnd) {
+				return 0, fmt.Errorf("invalid end of object")
+			}
+			return offset + 1, w.noMember(step)
+		}
+		end, found, err := w.member(step, key, offset, w.value0)
+		if found || err != nil {
+			return end, err
+		}
+		offset = end
+	}
+}
+
+// member returns the offset of the value with key if step looks for it, or
+// else the end of the value, visited if step visits members.
+func (w *rawWalker) member(step *pathStep, key []byte, offset int, value func(int, *pathStep) (int, error)) (int, bool, error) {
+	if step != nil && step.visit == nil && step.index < 0 && string(key) == step.key {
+		return offset, true, nil
+	}
+	tables := w.rawTables
+	end, err := value(offset, nil)
+	if err == nil && step != nil && step.visit != nil {
+		err = step.visit(key, offset, end, tables)
+	}
+	return end, false, err
+}
+
+func (w *rawWalker) noMember(step *pathStep) error {
+	if step != nil && step.visit == nil {
+		return fmt.Errorf("no member %q", step.key)
+	}
+	return nil
+}
+
+// items walks num values of a strict array, or up to the item of step.
+func (w *rawWalker) items(offset, num int, step *pathStep, value func(int, *pathStep) (int, error)) (int, error) {
+	if step != nil && step.index < 0 {
+		return 0, fmt.Errorf("not an object")
+	}
+	if step != nil && step.visit == nil {
+		if step.index >= num {
+			return 0, fmt.Errorf("index out of range [0:%d]", num)
+		}
+		num = step.index
+	}
+	for i := 0; i < num; i++ {
+		end, _, err := w.member(step, nil, offset, value)
+		if err != nil {
+			return 0, err
+		}
+		offset = end
+	}
+	return offset, nil
+}
+
+func (w *rawWalker) u29(offset int) (int, int, error) {
+	if offset > len(w.data) {
+		return 0, 0, fmt.Errorf("EOF")
+	}
+	n, l, err := decodeU29(w.data[offset:])
+	return n, offset + l, err
+}
+
+// str walks a UTF-8-vr string, returning it and its end.
+func (w *rawWalker) str(offset int) ([]byte, int, error) {
+	ref, offset, err := w.u29(offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	if ref&1 == 0 {
+		w.referenced = true
+		ref >>= 1
+		if ref >= len(w.strings3) {
+			return nil, 0, fmt.Errorf("invalid string ref %d", ref)
+		}
+		return w.strings3[ref], offset, nil
+	}
+	if err := w.need(offset, ref>>1); err != nil {
+		return nil, 0, err
+	}
+	s := w.data[offset : offset+ref>>1]
+	if len(s) > 0 {
+		w.strings3 = append(w.strings3, s)
+	}
+	return s, offset + len(s), nil
+}
+
+func (w *rawWalker) value3(offset int, step *pathStep) (int, error) {
+	if err := w.need(offset, 1); err != nil {
+		return 0, err
+	}
+	start := rawRef{offset: offset, strings: len(w.strings3), traits: len(w.traits3)}
+	marker := w.data[offset]
+	switch marker {
+	case amf3Undefined, amf3Null, amf3False, amf3True, amf3Integer, amf3Double, amf3String:
+		if step != nil {
+			return 0, fmt.Errorf("not an object or array")
+		}
+	}
+	switch marker {
+	case amf3Undefined, amf3Null, amf3False, amf3True:
+		return offset + 1, nil
+	case amf3Integer:
+		_, end, err := w.u29(offset + 1)
+		return end, err
+	case amf3Double:
+		return offset + 9, w.need(offset, 9)
+	case amf3String:
+		_, end, err := w.str(offset + 1)
+		return end, err
+	case amf3Date, amf3ByteArray:
+		ref, end, err := w.u29(offset + 1)
+		if err != nil || ref&1 == 0 {
+			w.referenced = true
+			return end, err
+		}
+		if step != nil {
+			return 0, fmt.Errorf("not an object or array")
+		}
+		w.objects3 = append(w.objects3, start)
+		size := 8
+		if marker == amf3ByteArray {
+			size = ref >> 1
+		}
+		return end + size, w.need(end, size)
+	case amf3Array:
+		return w.array3(start, step)
+	case amf3Object:
+		return w.object3(start, step)
+	case amf3VectorInt, amf3VectorUint, amf3VectorDouble, amf3VectorObject:
+		return w.vector3(start, step)
+	}
+	return 0, fmt.Errorf("unsupported type 0x%0X", marker)
+}
+
+func (w *rawWalker) vector3(start rawRef, step *pathStep) (int, error) {
+	ref, offset, err := w.u29(start.offset + 1)
+	if err != nil || ref&1 == 0 {
+		w.referenced = true
+		return offset, err
+	}
+	w.objects3 = append(w.objects3, start)
+	// The fixed-length flag.
+	if err := w.need(offset, 1); err != nil {
+		return 0, err
+	}
+	offset++
+	size := 4
+	switch w.data[start.offset] {
+	case amf3VectorObject:
+		if _, offset, err = w.str(offset); err != nil {
+			return 0, err
+		}
+		return w.items(offset, ref>>1, step, w.value3)
+	case amf3VectorDouble:
+		size = 8
+	}
+	if step != nil {
+		return 0, fmt.Errorf("items of a vector of numbers are not values")
+	}
+	if ref>>1 > (len(w.data)-offset)/size {
+		return 0, fmt.Errorf("EOF")
+	}
+	return offset + ref>>1*size, nil
+}
+
+func (w *rawWalker) array3(start rawRef, step *pathStep) (int, error) {
+	num, offset, err := w.u29(start.offset + 1)
+	if err != nil || num&1 == 0 {
+		w.referenced = true
+		return offset, err
+	}
+	w.objects3 = append(w.objects3, start)
+	// As in decodeArray3, an array has either associative or dense members.
+	if num == 1 && (offset >= len(w.data) || w.data[offset] != 0x01) {
+		if step != nil && step.index >= 0 {
+			return 0, fmt.Errorf("not an array")
+		}
+		return w.dynamic3(offset, step)
+	}
+	if offset >= len(w.data) || w.data[offset] != 0x01 {
+		return 0, fmt.Errorf("invalid strict array")
+	}
+	return w.items(offset+1, num>>1, step, w.value3)
+}
+
+func (w *rawWalker) object3(start rawRef, step *pathStep) (int, error) {
+	ref, offset, err := w.u29(start.offset + 1)
+	if err != nil || ref&1 == 0 {
+		w.referenced = true
+		return offset, err
+	}
+	var t rawTraits
+	if ref&2 == 0 {
+		w.referenced = true
+		if ref>>2 >= len(w.traits3) {
+			return 0, fmt.Errorf("invalid traits ref %d", ref>>2)
+		}
+		t = w.traits3[ref>>2]
+	} else {
+		t.dynamic, t.externalizable = ref&8 != 0, ref&4 != 0
+		if t.class, offset, err = w.str(offset); err != nil {
+			return 0, err
+		}
+		for i := 0; i < ref>>4 && !t.externalizable; i++ {
+			var name []byte
+			if name, offset, err = w.str(offset); err != nil {
+				return 0, err
+			}
+			t.sealed = append(t.sealed, name)
+		}
+		w.traits3 = append(w.traits3, t)
+	}
+	if t.externalizable {
+		if string(t.class) != arrayCollectionClass {
+			return 0, fmt.Errorf("unsupported externalizable class %s", t.class)
+		}
+		// As in decodeArrayCollection3, the collection is its array.
+		w.objects3 = append(w.objects3, start)
+		return w.value3(offset, step)
+	}
+	if step != nil && step.index >= 0 {
+		return 0, fmt.Errorf("not an array")
+	}
+	w.objects3 = append(w.objects3, start)
+	for _, name := range t.sealed {
+		end, found, err := w.member(step, name, offset, w.value3)
+		if found || err != nil {
+			return end, err
+		}
+		offset = end
+	}
+	if !t.dynamic {
+		return offset, w.noMember(step)
+	}
+	return w.dynamic3(offset, step)
+}
+
+// dynamic3 walks name and value pairs up to the empty name.
+func (w *rawWalker) dynamic3(offset int, step *pathStep) (int, error) {
+	for {
+		key, end, err := w.str(offset)
+		if err != nil {
+			return 0, err
+		}
+		offset = end
+		if len(key) == 0 {
+			return offset, w.noMember(step)
+		}
+		end, found, err := w.member(step, key, offset, w.value3)
+		if found || err != nil {
+			return end, err
+		}
+		offset = end
+	}
+}
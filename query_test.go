@@ -0,0 +1,130 @@
+package amf
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestQuery(t *testing.T) {
+	user := &OrderedObject{Class: "User"}
+	user.Set("id", 7)
+	user.Set("name", "ann")
+	user.Sealed = 2
+	shared := &OrderedObject{}
+	shared.Set("user", user)
+	root := &OrderedObject{}
+	root.Set("body", []interface{}{shared, "x"})
+	root.Set("copy", shared)
+	root.Set("other", []interface{}{user, &OrderedECMAArray{}})
+	root.Set("a b", true)
+	amf3, err := AppendAMF3(nil, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		version AMFVersion
+		data    []byte
+		path    string
+		want    string
+	}{
+		{AMF3, amf3, "body[0].user.id", `int(7)`},
+		{AMF3, amf3, "body[1]", `"x"`},
+		{AMF3, amf3, "copy.user.name", `"ann"`},
+		{AMF3, amf3, "other[0]", `obj<User>{id: int(7), name: "ann"}`},
+		{AMF3, amf3, "other", `[obj<User>{id: int(7), name: "ann"}, []]`},
+		{AMF3, amf3, `["a b"]`, `true`},
+		{AMF0, mustEncodeText(t, AMF0, `{body: [1, {user: obj<User>{id: 2}}], "a.b": null}`), "body[1].user.id", `2`},
+		{AMF0, mustEncodeText(t, AMF0, `{body: [1, {user: obj<User>{id: 2}}], "a.b": null}`), `["a.b"]`, `null`},
+		{AMF0, mustEncodeText(t, AMF0, `ecma{a: "x"}`), "", `ecma{a: "x"}`},
+		// {a: {}, b: [<ref 1>]}
+		{AMF0, []byte{0x03,
+			0x00, 0x01, 0x61, 0x03, 0x00, 0x00, 0x09,
+			0x00, 0x01, 0x62, 0x0a, 0x00, 0x00, 0x00, 0x01, 0x07, 0x00, 0x01,
+			0x00, 0x00, 0x09}, "b", `[{}]`},
+		// {v: <AVM+> {id: int(3)}}
+		{AMF0, []byte{0x03,
+			0x00, 0x01, 0x76, 0x11, 0x0a, 0x0b, 0x01, 0x05, 0x69, 0x64, 0x04, 0x03, 0x01,
+			0x00, 0x00, 0x09}, "v.id", `int(3)`},
+		{AMF3, mustEncodeCollection(t, Vector, []*OrderedObject{user}), "[0].name", `"ann"`},
+		{AMF3, mustEncodeCollection(t, ArrayCollection, [][]int32{{1}, {2}}), "[1][0]", `int(2)`},
+		{AMF3, mustEncodeCollection(t, ArrayCollection, [][]int32{{1}}), "", `[[int(1)]]`},
+	} {
+		v, err := Query(c.data, c.version, c.path)
+		if err != nil {
+			t.Errorf("Query(%q): %s", c.path, err)
+			continue
+		}
+		if got, err := Format(v); err != nil || got != c.want {
+			t.Errorf("Query(%q) == %s, %v, want %s", c.path, got, err, c.want)
+		}
+	}
+}
+
+func mustEncodeCollection(t *testing.T, collection Collection, v interface{}) []byte {
+	b, err := (&Encoder{Collection: collection}).AppendAMF3(nil, v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestQueryRaw(t *testing.T) {
+	// {self: <ref 0>, n: int(1)}
+	data := []byte{0x0a, 0x0b, 0x01,
+		0x09, 0x73, 0x65, 0x6c, 0x66, 0x0a, 0x00,
+		0x03, 0x6e, 0x04, 0x01,
+		0x01}
+	v, err := QueryRaw(data, AMF3, "self.self")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Version() != AMF3 || !bytes.Equal(v.Bytes(), data) {
+		t.Errorf("QueryRaw(self.self) == % x, want % x", v.Bytes(), data)
+	}
+	n, err := v.Query("self.n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := n.Decode(); err != nil || got != 1 {
+		t.Errorf("self.n == %#v, %v, want 1", got, err)
+	}
+}
+
+func TestQueryInvalid(t *testing.T) {
+	data := mustEncodeText(t, AMF3, `{a: [1, "x"], b: ecma{c: true}}`)
+	for _, path := range []string{
+		"c",
+		"a[2]",
+		"a.b",
+		"b[0]",
+		"a[1].x",
+		"a[",
+		"a..b",
+		".a",
+		`["a]`,
+	} {
+		if v, err := Query(data, AMF3, path); err == nil {
+			t.Errorf("Query(%q) == %#v, want error", path, v)
+		}
+	}
+	if _, err := Query([]byte{0x0a, 0x0b}, AMF3, "a"); err == nil {
+		t.Error("Query of truncated data succeeded")
+	}
+}
+
+func BenchmarkQuery(b *testing.B) {
+	items := make([]interface{}, 1000)
+	for i := range items {
+		items[i] = benchValue
+	}
+	data, err := AppendAMF3(nil, map[string]interface{}{"body": items})
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := Query(data, AMF3, "body[999].code"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,270 @@
+package amf
+
+import (
+	"encoding"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// RawAMF0 holds an AMF0 value undecoded, like json.RawMessage. As the target
+// of Unmarshal it captures the value with the reference tables before it, so
+// that it can be decoded later. When encoded it is written verbatim if its
+// references still point to the same values, and encoded again otherwise. A
+// value after an AVM+ marker is captured as its AMF3 encoding.
+type RawAMF0 struct {
+	RawValue
+}
+
+// RawAMF3 is the AMF3 counterpart of RawAMF0. In an AMF0 message it captures
+// the value after an AVM+ marker.
+type RawAMF3 struct {
+	RawValue
+}
+
+var (
+	rawAMF0Type = reflect.TypeFor[RawAMF0]()
+	rawAMF3Type = reflect.TypeFor[RawAMF3]()
+)
+
+var rawCache sync.Map // map[reflect.Type]bool
+
+// hasRaw reports whether values of t can hold a RawAMF0 or RawAMF3 filled by
+// Unmarshal, which then walks the message instead of decoding it.
+func hasRaw(t reflect.Type) bool {
+	if raw, ok := rawCache.Load(t); ok {
+		return raw.(bool)
+	}
+	raw := findRaw(t, make(map[reflect.Type]bool))
+	rawCache.Store(t, raw)
+	return raw
+}
+
+func findRaw(t reflect.Type, seen map[reflect.Type]bool) bool {
+	if t == rawAMF0Type || t == rawAMF3Type {
+		return true
+	}
+	if seen[t] {
+		return false
+	}
+	seen[t] = true
+	pt := reflect.PointerTo(t)
+	if pt.Implements(reflect.TypeFor[AMF0Unmarshaler]()) || pt.Implements(reflect.TypeFor[AMF3Unmarshaler]()) ||
+		pt.Implements(reflect.TypeFor[encoding.TextUnmarshaler]()) {
+		return false
+	}
+	switch t.Kind() {
+	case reflect.Pointer, reflect.Slice, reflect.Map:
+		return findRaw(t.Elem(), seen)
+	case reflect.Struct:
+		for _, f := range structFields(t) {
+			if findRaw(t.Field(f.Index).Type, seen) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// unmarshalRaw is unmarshalValue for types holding raw values, walking raw
+// and decoding only the values stored in other types.
+func (d *Decoder) unmarshalRaw(version AMFVersion, raw RawValue, rv reflect.Value) error {
+	switch rv.Type() {
+	case rawAMF0Type:
+		if raw.version != AMF0 {
+			return fmt.Errorf("cannot unmarshal AMF3 value into %s", rv.Type())
+		}
+		rv.Set(reflect.ValueOf(RawAMF0{raw}))
+		return nil
+	case rawAMF3Type:
+		if raw.version != AMF3 {
+			return fmt.Errorf("cannot unmarshal AMF0 value into %s", rv.Type())
+		}
+		rv.Set(reflect.ValueOf(RawAMF3{raw}))
+		return nil
+	}
+	if !hasRaw(rv.Type()) {
+		value, err := raw.decode(&Decoder{Ordered: d.Ordered, KeepUndefined: d.KeepUndefined})
+		if err != nil {
+			return err
+		}
+		return unmarshalValue(version, value, rv)
+	}
+	if raw.isNull() {
+		rv.SetZero()
+		return nil
+	}
+	switch rv.Kind() {
+	case reflect.Pointer:
+		if rv.IsNil() {
+			rv.Set(reflect.New(rv.Type().Elem()))
+		}
+		return d.unmarshalRaw(version, raw, rv.Elem())
+	case reflect.Slice:
+		result := reflect.MakeSlice(rv.Type(), 0, 0)
+		err := raw.each(true, func(_ string, item RawValue) error {
+			result = reflect.Append(result, reflect.New(rv.Type().Elem()).Elem())
+			if err := d.unmarshalRaw(version, item, result.Index(result.Len()-1)); err != nil {
+				return fmt.Errorf("[%d]: %s", result.Len()-1, err)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		rv.Set(result)
+	case reflect.Map:
+		if rv.Type().Key().Kind() != reflect.String {
+			return fmt.Errorf("cannot unmarshal into %s", rv.Type())
+		}
+		result := reflect.MakeMap(rv.Type())
+		err := raw.each(false, func(key string, member RawValue) error {
+			item := reflect.New(rv.Type().Elem()).Elem()
+			if err := d.unmarshalRaw(version, member, item); err != nil {
+				return fmt.Errorf("%s: %s", key, err)
+			}
+			result.SetMapIndex(reflect.ValueOf(key).Convert(rv.Type().Key()), item)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		rv.Set(result)
+	default:
+		fields := make(map[string]int)
+		for _, f := range structFields(rv.Type()) {
+			fields[f.Name] = f.Index
+		}
+		return raw.each(false, func(key string, member RawValue) error {
+			index, ok := fields[key]
+			if !ok {
+				return nil
+			}
+			if err := d.unmarshalRaw(version, member, rv.Field(index)); err != nil {
+				return fmt.Errorf("%s: %s", key, err)
+			}
+			return nil
+		})
+	}
+	return nil
+}
+
+func (v RawValue) isNull() bool {
+	if v.offset >= len(v.data) {
+		return false
+	}
+	if v.version == AMF0 {
+		return v.data[v.offset] == amf0Null || v.data[v.offset] == amf0Undefined
+	}
+	return v.data[v.offset] == amf3Null || v.data[v.offset] == amf3Undefined
+}
+
+// each calls fn with the items of an array, or the members of an object or
+// ECMA array.
+func (v RawValue) each(items bool, fn func(key string, v RawValue) error) error {
+	w := &rawWalker{data: v.data, rawTables: v.tables.clone()}
+	step := &pathStep{index: -1}
+	if items {
+		step.index = 0
+	}
+	step.visit = func(key []byte, offset, end int, tables rawTables) error {
+		item := &rawWalker{data: v.data, rawTables: tables}
+		value, err := item.raw(v.version, offset)
+		if err != nil {
+			return err
+		}
+		if value.offset >= offset {
+			value.end = end
+		} else if err := value.setEnd(); err != nil {
+			return err
+		}
+		return fn(string(key), value)
+	}
+	var err error
+	if v.version == AMF0 {
+		_, err = w.value0(v.offset, step)
+	} else {
+		_, err = w.value3(v.offset, step)
+	}
+	return err
+}
+
+// encodeRaw0 writes v verbatim when its references, if any, still point to
+// the same values, and otherwise decodes and encodes it again. An AMF3 value
+// follows an AVM+ marker, which empties the AMF3 tables.
+func (e *Encoder) encodeRaw0(b []byte, v RawValue) ([]byte, error) {
+	if v.data == nil {
+		return encodeNull(b), nil
+	}
+	if v.version == AMF3 {
+		b = append(b, amf0AVMPlus)
+		if _, ok := v.verbatim(true); ok {
+			return append(b, v.Bytes()...), nil
+		}
+		value, err := v.Decode()
+		if err != nil {
+			return b, err
+		}
+		return e.avmPlus().encodeAMF3(b, value)
+	} else if tables, ok := v.verbatim(e.nobjects0 == 0); ok {
+		e.nobjects0 += len(tables.objects0)
+		return append(b, v.Bytes()...), nil
+	}
+	value, err := v.Decode()
+	if err != nil {
+		return b, err
+	}
+	return e.encodeAMF0(b, value)
+}
+
+// avmPlus returns an encoder for a value after an AVM+ marker, with the
+// options of e and empty tables.
+func (e *Encoder) avmPlus() *Encoder {
+	return &Encoder{KeyOrder: e.KeyOrder, ExactIntegers: e.ExactIntegers, NoReferences: e.NoReferences, SubMillisecond: e.SubMillisecond}
+}
+
+// encodeRaw3 is the AMF3 counterpart of encodeRaw0. The strings, traits and
+// objects of a value written verbatim are added to the encoder's tables, as a
+// decoder adds them to its own.
+func (e *Encoder) encodeRaw3(b []byte, v RawValue) ([]byte, error) {
+	if v.data == nil {
+		return encodeNull3(b), nil
+	}
+	if v.version != AMF3 {
+		return b, fmt.Errorf("cannot encode an AMF0 raw value in AMF3")
+	}
+	if tables, ok := v.verbatim(e.nstrings3 == 0 && e.ntraits3 == 0 && e.nobjects3 == 0); ok {
+		for _, s := range tables.strings3 {
+			e.addString3(string(s))
+		}
+		for _, t := range tables.traits3 {
+			t := t.traits()
+			e.addTraits3(traitsID3(t.class, t.sealed, t.dynamic))
+		}
+		e.nobjects3 += len(tables.objects3)
+		return append(b, v.Bytes()...), nil
+	}
+	value, err := v.Decode()
+	if err != nil {
+		return b, err
+	}
+	return e.encodeAMF3(b, value)
+}
+
+// verbatim walks the bytes of v alone and returns the tables they fill. They
+// can be copied if they use no references, or if both the tables before v in
+// its message and those they are written after, as empty reports, are empty,
+// so that their references are to entries of their own.
+func (v RawValue) verbatim(empty bool) (rawTables, bool) {
+	w := &rawWalker{data: v.Bytes()}
+	var end int
+	var err error
+	if v.version == AMF0 {
+		end, err = w.value0(0, nil)
+		empty = empty && len(v.tables.objects0) == 0
+	} else {
+		end, err = w.value3(0, nil)
+		empty = empty && len(v.tables.strings3) == 0 && len(v.tables.traits3) == 0 && len(v.tables.objects3) == 0
+	}
+	return w.rawTables, err == nil && end == len(w.data) && (!w.referenced || empty)
+}
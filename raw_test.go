@@ -0,0 +1,204 @@
+package amf
+
+import (
+	"bytes"
+	"testing"
+)
+
+type rawMessage3 struct {
+	Target string  `amf:"target"`
+	Body   RawAMF3 `amf:"body"`
+	After  string  `amf:"after,omitempty"`
+}
+
+func TestUnmarshalRaw(t *testing.T) {
+	// The body refers to the string "target" written before it.
+	data := mustEncodeText(t, AMF3, `{target: "svc.get", body: {target: "x", user: {id: int(1)}}}`)
+	var m rawMessage3
+	if err := Unmarshal(data, AMF3, &m); err != nil {
+		t.Fatal(err)
+	}
+	if m.Target != "svc.get" || m.Body.Version() != AMF3 {
+		t.Errorf("Unmarshal == %+v", m)
+	}
+	body, err := m.Body.Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := Format(body); got != `{target: "x", user: {id: int(1)}}` {
+		t.Errorf("body == %s", got)
+	}
+	// The body can't be written verbatim, so it is encoded again.
+	b, err := AppendAMF3(nil, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, _, err := (&Decoder{Ordered: true}).DecodeAMF3(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := Format(v); got != `{target: "svc.get", body: {target: "x", user: {id: int(1)}}}` {
+		t.Errorf("re-encoded == %s", got)
+	}
+}
+
+func TestEncodeRawVerbatim(t *testing.T) {
+	body := mustEncodeText(t, AMF3, `obj<Foo>{a: "x"}`)
+	var raw RawAMF3
+	if err := Unmarshal(body, AMF3, &raw); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(raw.Bytes(), body) {
+		t.Fatalf("raw == % x, want % x", raw.Bytes(), body)
+	}
+	// Strings and traits after the raw body refer to those in it.
+	m := []interface{}{rawMessage3{Target: "t", Body: raw, After: "x"}, obj("Foo", "a", "y"),
+		"z", "z", obj("Bar", "b", 1), obj("Bar", "b", 2)}
+	b, err := AppendAMF3(nil, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(b, body) {
+		t.Errorf("% x does not contain % x", b, body)
+	}
+	v, _, err := (&Decoder{Ordered: true}).DecodeAMF3(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `[{target: "t", body: obj<Foo>{a: "x"}, after: "x"}, obj<Foo>{a: "y"}, "z", "z", obj<Bar>{b: int(1)}, obj<Bar>{b: int(2)}]`
+	if got, _ := Format(v); got != want {
+		t.Errorf("decoded %s, want %s", got, want)
+	}
+}
+
+func obj(class string, kv ...interface{}) *OrderedObject {
+	o := &OrderedObject{Class: class}
+	for i := 0; i < len(kv); i += 2 {
+		o.Set(kv[i].(string), kv[i+1])
+	}
+	o.Sealed = o.Len()
+	return o
+}
+
+func TestUnmarshalRawAMF0(t *testing.T) {
+	type item struct {
+		ID   int     `amf:"id"`
+		Data RawAMF0 `amf:"data"`
+	}
+	var items []item
+	data := mustEncodeText(t, AMF0, `[{id: 1, data: {a: [true]}}, {data: null, id: 2}]`)
+	if err := Unmarshal(data, AMF0, &items); err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 2 || items[0].ID != 1 || items[1].ID != 2 {
+		t.Fatalf("Unmarshal == %+v", items)
+	}
+	for i, want := range []string{`{a: [true]}`, `null`} {
+		v, err := items[i].Data.Decode()
+		if got, _ := Format(v); err != nil || got != want {
+			t.Errorf("items[%d].data == %s, %v, want %s", i, got, err, want)
+		}
+	}
+	b, err := AppendAMF0(nil, items)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, _, err := (&Decoder{Ordered: true}).DecodeAMF0(b)
+	if got, _ := Format(v); err != nil || got != `[{id: 1, data: {a: [true]}}, {id: 2, data: null}]` {
+		t.Errorf("re-encoded == %s, %v", got, err)
+	}
+
+	// {body: <AVM+> [int(1)]}
+	avm := []byte{0x03, 0x00, 0x04, 0x62, 0x6f, 0x64, 0x79, 0x11, 0x09, 0x03, 0x01, 0x04, 0x01, 0x00, 0x00, 0x09}
+	var m struct {
+		Body RawAMF3 `amf:"body"`
+	}
+	if err := Unmarshal(avm, AMF0, &m); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(m.Body.Bytes(), avm[8:13]) {
+		t.Errorf("body == % x, want % x", m.Body.Bytes(), avm[8:13])
+	}
+	if b, err := AppendAMF0(nil, m); err != nil || !bytes.Equal(b, avm) {
+		t.Errorf("AppendAMF0 == % x, %v, want % x", b, err, avm)
+	}
+}
+
+func TestRawInvalid(t *testing.T) {
+	var raw0 RawAMF0
+	if err := Unmarshal([]byte{0x04, 0x01}, AMF3, &raw0); err == nil {
+		t.Error("Unmarshal of AMF3 into RawAMF0 succeeded")
+	}
+	if err := Unmarshal([]byte{0x00, 0x3f, 0xf0, 0, 0, 0, 0, 0, 0}, AMF0, &raw0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := AppendAMF3(nil, raw0); err == nil {
+		t.Error("AMF3 encoding of RawAMF0 succeeded")
+	}
+	if b, err := AppendAMF3(nil, RawAMF3{}); err != nil || !bytes.Equal(b, []byte{amf3Null}) {
+		t.Errorf("AppendAMF3(RawAMF3{}) == % x, %v", b, err)
+	}
+	d := NewDecoder()
+	if _, _, err := d.DecodeAMF3([]byte{0x06, 0x03, 0x61}); err != nil {
+		t.Fatal(err)
+	}
+	var raw3 RawAMF3
+	if err := d.Unmarshal([]byte{0x06, 0x00}, AMF3, &raw3); err == nil {
+		t.Error("Unmarshal of a raw value after other values succeeded")
+	}
+}
+
+func TestEncodeRawReferences(t *testing.T) {
+	// ["hello", {k: <string ref 0>}]: out of its message, the reference
+	// would be to "k".
+	var items []RawAMF3
+	data := []byte{0x09, 0x05, 0x01, 0x06, 0x0b, 'h', 'e', 'l', 'l', 'o', 0x0a, 0x0b, 0x01, 0x03, 'k', 0x06, 0x00, 0x01}
+	if err := Unmarshal(data, AMF3, &items); err != nil {
+		t.Fatal(err)
+	}
+	checkRaw3(t, items[1], `{k: "hello"}`)
+
+	// {k: <string ref 0>} refers to its own key, which is no longer the
+	// first string after "zzz".
+	var self RawAMF3
+	if err := Unmarshal(data[10:], AMF3, &self); err != nil {
+		t.Fatal(err)
+	}
+	checkRaw3(t, self, `{k: "k"}`)
+	if b, err := AppendAMF3(nil, self); err != nil || !bytes.Equal(b, data[10:]) {
+		t.Errorf("AppendAMF3 == % x, %v, want % x", b, err, data[10:])
+	}
+	checkRaw3(t, []interface{}{"zzz", self}, `["zzz", {k: "k"}]`)
+	if b, err := AppendAMF0(nil, []interface{}{"zzz", self}); err != nil || !bytes.Contains(b, data[10:]) {
+		t.Errorf("AppendAMF0 == % x, %v, want AVM+ % x", b, err, data[10:])
+	}
+
+	// {self: <ref 0>} in an array, where reference 0 is the array.
+	cyclic := []byte{0x03, 0x00, 0x04, 's', 'e', 'l', 'f', 0x07, 0x00, 0x00, 0x00, 0x00, 0x09}
+	var raw0 RawAMF0
+	if err := Unmarshal(cyclic, AMF0, &raw0); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := AppendAMF0(nil, raw0); err != nil || !bytes.Equal(b, cyclic) {
+		t.Errorf("AppendAMF0 == % x, %v, want % x", b, err, cyclic)
+	}
+	want := []byte{0x0a, 0x00, 0x00, 0x00, 0x01,
+		0x03, 0x00, 0x04, 's', 'e', 'l', 'f', 0x07, 0x00, 0x01, 0x00, 0x00, 0x09}
+	if b, err := AppendAMF0(nil, []interface{}{raw0}); err != nil || !bytes.Equal(b, want) {
+		t.Errorf("AppendAMF0 in an array == % x, %v, want % x", b, err, want)
+	}
+}
+
+// checkRaw3 encodes v holding raw values in AMF3 and compares its decoding
+// with want.
+func checkRaw3(t *testing.T, v interface{}, want string) {
+	t.Helper()
+	b, err := AppendAMF3(nil, v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, _, err := (&Decoder{Ordered: true}).DecodeAMF3(b)
+	if got, _ := Format(decoded); err != nil || got != want {
+		t.Errorf("decoded %s, %v, want %s", got, err, want)
+	}
+}
@@ -0,0 +1,84 @@
+package amf
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+var registry struct {
+	sync.RWMutex
+	types   map[string]reflect.Type // by alias
+	aliases map[reflect.Type]string // by struct type
+}
+
+// RegisterClass registers the type of v, a struct or a pointer to one, under
+// an ActionScript class alias, as registerClassAlias does in Flash. Structs of
+// that type encode as typed objects of the class, and Unmarshal decodes
+// objects of the class into a new value of the type where the target is an
+// empty interface. RegisterClass panics if the alias or type is already
+// registered differently.
+func RegisterClass(alias string, v interface{}) {
+	t := reflect.TypeOf(v)
+	st := t
+	if st != nil && st.Kind() == reflect.Pointer {
+		st = st.Elem()
+	}
+	if alias == "" || st == nil || st.Kind() != reflect.Struct {
+		panic(fmt.Sprintf("amf: invalid class registration %q for %T", alias, v))
+	}
+	registry.Lock()
+	defer registry.Unlock()
+	if other, ok := registry.types[alias]; ok && other != t {
+		panic(fmt.Sprintf("amf: class %s registered for both %s and %s", alias, other, t))
+	}
+	if other, ok := registry.aliases[st]; ok && other != alias {
+		panic(fmt.Sprintf("amf: %s registered as both %s and %s", st, other, alias))
+	}
+	if registry.types == nil {
+		registry.types = make(map[string]reflect.Type)
+		registry.aliases = make(map[reflect.Type]string)
+	}
+	registry.types[alias] = t
+	registry.aliases[st] = alias
+}
+
+// RegisteredClass returns the alias the struct type t is registered under.
+func RegisteredClass(t reflect.Type) (string, bool) {
+	registry.RLock()
+	defer registry.RUnlock()
+	alias, ok := registry.aliases[t]
+	return alias, ok
+}
+
+// RegisteredClasses returns the registered aliases and their types.
+func RegisteredClasses() map[string]reflect.Type {
+	registry.RLock()
+	defer registry.RUnlock()
+	result := make(map[string]reflect.Type, len(registry.types))
+	for alias, t := range registry.types {
+		result[alias] = t
+	}
+	return result
+}
+
+func registeredType(class string) (reflect.Type, bool) {
+	if class == "" {
+		return nil, false
+	}
+	registry.RLock()
+	defer registry.RUnlock()
+	t, ok := registry.types[class]
+	return t, ok
+}
+
+// className returns the class of a decoded typed object.
+func className(value interface{}) string {
+	switch v := value.(type) {
+	case TypedObject:
+		return v.Class
+	case *OrderedObject:
+		return v.Class
+	}
+	return ""
+}
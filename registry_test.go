@@ -0,0 +1,76 @@
+package amf
+
+import (
+	"reflect"
+	"testing"
+)
+
+type registeredUser struct {
+	ID   int    `amf:"id"`
+	Name string `amf:"name"`
+}
+
+type registeredGroup struct {
+	Owner   interface{}   `amf:"owner"`
+	Members []interface{} `amf:"members"`
+}
+
+func init() {
+	RegisterClass("test.User", &registeredUser{})
+	RegisterClass("test.Group", registeredGroup{})
+}
+
+func TestRegisterClass(t *testing.T) {
+	in := registeredGroup{Owner: &registeredUser{1, "ann"}, Members: []interface{}{&registeredUser{2, "bob"}}}
+	for _, version := range []AMFVersion{AMF0, AMF3} {
+		data, err := EncodeFrom(in, version)
+		if err != nil {
+			t.Fatal(err)
+		}
+		v, _, err := (&Decoder{Ordered: true}).DecodeAMF3(data)
+		if version == AMF0 {
+			v, _, err = (&Decoder{Ordered: true}).DecodeAMF0(data)
+		}
+		want := `obj<test.Group>{owner: obj<test.User>{id: 1, name: "ann"}, members: [obj<test.User>{id: 2, name: "bob"}]}`
+		if version == AMF3 {
+			want = `obj<test.Group>{owner: obj<test.User>{id: int(1), name: "ann"}, members: [obj<test.User>{id: int(2), name: "bob"}]}`
+		}
+		if got, _ := Format(v); err != nil || got != want {
+			t.Errorf("decode(%d) == %s, %v, want %s", version, got, err, want)
+		}
+		var got interface{}
+		if err := Unmarshal(data, version, &got); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, in) {
+			t.Errorf("Unmarshal(%d) == %#v, want %#v", version, got, in)
+		}
+	}
+	if alias, ok := RegisteredClass(reflect.TypeFor[registeredUser]()); !ok || alias != "test.User" {
+		t.Errorf("RegisteredClass(registeredUser) == %q, %v", alias, ok)
+	}
+	if c := RegisteredClasses(); c["test.Group"] != reflect.TypeFor[registeredGroup]() {
+		t.Errorf("RegisteredClasses() == %v", c)
+	}
+}
+
+func TestRegisterClassInvalid(t *testing.T) {
+	for _, c := range []struct {
+		alias string
+		v     interface{}
+	}{
+		{"", registeredUser{}},
+		{"test.Int", 1},
+		{"test.User", registeredGroup{}},
+		{"test.Other", registeredUser{}},
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("RegisterClass(%q, %T) did not panic", c.alias, c.v)
+				}
+			}()
+			RegisterClass(c.alias, c.v)
+		}()
+	}
+}
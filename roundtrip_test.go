@@ -0,0 +1,260 @@
+package amf
+
+import (
+	"bytes"
+	"flag"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+)
+
+var (
+	roundTripSeed  = flag.Uint64("amf.seed", 0, "first seed of the round trip tests, 0 for a random one")
+	roundTripCount = flag.Int("amf.count", 500, "number of values generated by the round trip tests")
+	roundTripDepth = flag.Int("amf.depth", 4, "maximum nesting depth of generated values")
+)
+
+// valueGen generates random values of the ordered model for one version,
+// sometimes reusing containers it already generated so that the encoded
+// values include shared references.
+type valueGen struct {
+	r       *rand.Rand
+	version AMFVersion
+	shared  []interface{}
+	strings []string
+}
+
+func newValueGen(seed uint64, version AMFVersion) *valueGen {
+	return &valueGen{r: rand.New(rand.NewPCG(seed, 0)), version: version}
+}
+
+var specialNumbers = []float64{0, math.Copysign(0, -1), 1, -1, math.MaxFloat64, math.SmallestNonzeroFloat64, math.Inf(1), math.Inf(-1), math.NaN(), 1 << 53}
+
+var specialIntegers = []int{0, 1, -1, 127, 128, 16383, 16384, 2097151, 2097152, amf3MaxInt, amf3MinInt}
+
+func (g *valueGen) value(depth int) interface{} {
+	kinds := 8
+	if depth > 0 {
+		kinds += 4
+		if len(g.shared) > 0 && g.r.IntN(8) == 0 {
+			return g.shared[g.r.IntN(len(g.shared))]
+		}
+	}
+	switch g.r.IntN(kinds) {
+	case 0:
+		return nil
+	case 1:
+		return Undefined{}
+	case 2:
+		return g.r.IntN(2) == 0
+	case 3:
+		if g.r.IntN(4) == 0 {
+			return specialNumbers[g.r.IntN(len(specialNumbers))]
+		}
+		return g.r.NormFloat64() * math.Pow(10, float64(g.r.IntN(20)))
+	case 4:
+		if g.version == AMF0 {
+			return float64(g.r.Int32())
+		}
+		if g.r.IntN(2) == 0 {
+			return specialIntegers[g.r.IntN(len(specialIntegers))]
+		}
+		return g.r.IntN(amf3MaxInt-amf3MinInt+1) + amf3MinInt
+	case 5:
+		return g.string()
+	case 6:
+		ms := g.r.Int64N(200*365*24*3600*1000) - 100*365*24*3600*1000
+		return time.UnixMilli(ms)
+	case 7:
+		b := make([]byte, g.r.IntN(8))
+		for i := range b {
+			b[i] = byte(g.r.Uint32())
+		}
+		return b
+	}
+	var v interface{}
+	switch g.r.IntN(4) {
+	case 0:
+		items := make([]interface{}, g.r.IntN(5))
+		for i := range items {
+			items[i] = g.value(depth - 1)
+		}
+		v = items
+	case 1:
+		o := &OrderedObject{}
+		g.members(o.Set, depth)
+		if g.version == AMF3 && g.r.IntN(2) == 0 {
+			o.Sealed = g.r.IntN(o.Len() + 1)
+			o.Dynamic = g.r.IntN(2) == 0
+		}
+		v = o
+	case 2:
+		o := &OrderedObject{Class: "com.example.C" + strconv.Itoa(g.r.IntN(3))}
+		g.members(o.Set, depth)
+		o.Sealed = o.Len()
+		if g.version == AMF3 && g.r.IntN(2) == 0 {
+			o.Sealed = g.r.IntN(o.Len() + 1)
+			o.Dynamic = true
+		}
+		v = o
+	default:
+		a := &OrderedECMAArray{}
+		g.members(a.Set, depth)
+		if g.version == AMF0 && g.r.IntN(2) == 0 {
+			a.Count = g.r.IntN(10)
+		}
+		v = a
+	}
+	g.shared = append(g.shared, v)
+	return v
+}
+
+func (g *valueGen) members(set func(string, interface{}), depth int) {
+	for i := g.r.IntN(5); i > 0; i-- {
+		set(g.string()+"k", g.value(depth-1))
+	}
+}
+
+// string returns a random string, often one returned before so that AMF3
+// string references are used.
+func (g *valueGen) string() string {
+	if len(g.strings) > 0 && g.r.IntN(3) == 0 {
+		return g.strings[g.r.IntN(len(g.strings))]
+	}
+	alphabet := []rune("abcXYZ019 _.é€😀\u0000\n")
+	s := make([]rune, g.r.IntN(8))
+	for i := range s {
+		s[i] = alphabet[g.r.IntN(len(alphabet))]
+	}
+	g.strings = append(g.strings, string(s))
+	return string(s)
+}
+
+func testRoundTrip(t *testing.T, version AMFVersion) {
+	seed := *roundTripSeed
+	if seed == 0 {
+		seed = rand.Uint64()
+	}
+	for i := 0; i < *roundTripCount; i++ {
+		if !testRoundTripSeed(t, version, seed+uint64(i)) {
+			t.Logf("reproduce with: go test -run %s -amf.seed %d -amf.count 1 -amf.depth %d", t.Name(), seed+uint64(i), *roundTripDepth)
+			return
+		}
+	}
+}
+
+// testRoundTripSeed checks Decode(Encode(v)) == v and Encode(Decode(b)) == b
+// for the value generated from seed, comparing values by their text notation.
+func testRoundTripSeed(t *testing.T, version AMFVersion, seed uint64) bool {
+	v := newValueGen(seed, version).value(*roundTripDepth)
+	want, err := Format(v)
+	if err != nil {
+		t.Errorf("seed %d: Format: %s", seed, err)
+		return false
+	}
+	encode := AppendAMF0
+	d := &Decoder{Ordered: true, KeepUndefined: true}
+	decode := d.DecodeAMF0
+	if version == AMF3 {
+		encode, decode = AppendAMF3, d.DecodeAMF3
+	}
+	b, err := encode(nil, v)
+	if err != nil {
+		t.Errorf("seed %d: encode %s: %s", seed, want, err)
+		return false
+	}
+	decoded, n, err := decode(b)
+	if err != nil || n != len(b) {
+		t.Errorf("seed %d: decode % x: %d of %d bytes, %v", seed, b, n, len(b), err)
+		return false
+	}
+	if version == AMF3 {
+		// An empty AMF3 associative array is written as an empty array.
+		if want, err = Format(emptyECMAAsArray(v)); err != nil {
+			t.Errorf("seed %d: Format: %s", seed, err)
+			return false
+		}
+	}
+	if got, err := Format(decoded); err != nil || got != want {
+		t.Errorf("seed %d: Decode(Encode(v)) == %s, %v, want %s", seed, got, err, want)
+		return false
+	}
+	// The text notation has no ECMA array counts.
+	if got, want := ecmaCounts(decoded, nil), ecmaCounts(v, nil); version == AMF0 && !slices.Equal(got, want) {
+		t.Errorf("seed %d: Decode(Encode(v)) has ECMA array counts %v, want %v", seed, got, want)
+		return false
+	}
+	again, err := encode(nil, decoded)
+	if err != nil || !bytes.Equal(again, b) {
+		t.Errorf("seed %d: Encode(Decode(b)) == % x, %v, want % x", seed, again, err, b)
+		return false
+	}
+	return true
+}
+
+// emptyECMAAsArray returns a copy of v with its empty ECMA arrays replaced by
+// empty arrays.
+func emptyECMAAsArray(v interface{}) interface{} {
+	switch v := v.(type) {
+	case []interface{}:
+		items := make([]interface{}, len(v))
+		for i, item := range v {
+			items[i] = emptyECMAAsArray(item)
+		}
+		return items
+	case *OrderedObject:
+		o := &OrderedObject{Class: v.Class, Sealed: v.Sealed, Dynamic: v.Dynamic}
+		for _, key := range v.Keys() {
+			value, _ := v.Get(key)
+			o.Set(key, emptyECMAAsArray(value))
+		}
+		return o
+	case *OrderedECMAArray:
+		if v.Len() == 0 {
+			return []interface{}{}
+		}
+		a := &OrderedECMAArray{Count: v.Count}
+		for _, key := range v.Keys() {
+			value, _ := v.Get(key)
+			a.Set(key, emptyECMAAsArray(value))
+		}
+		return a
+	}
+	return v
+}
+
+// ecmaCounts appends the counts the AMF0 encoder writes for the ECMA arrays
+// in v, in the order they are met.
+func ecmaCounts(v interface{}, counts []int) []int {
+	var m *orderedMap
+	switch v := v.(type) {
+	case []interface{}:
+		for _, item := range v {
+			counts = ecmaCounts(item, counts)
+		}
+		return counts
+	case *OrderedObject:
+		m = &v.orderedMap
+	case *OrderedECMAArray:
+		counts = append(counts, v.count())
+		m = &v.orderedMap
+	default:
+		return counts
+	}
+	for _, key := range m.Keys() {
+		value, _ := m.Get(key)
+		counts = ecmaCounts(value, counts)
+	}
+	return counts
+}
+
+func TestRoundTripAMF0(t *testing.T) {
+	testRoundTrip(t, AMF0)
+}
+
+func TestRoundTripAMF3(t *testing.T) {
+	testRoundTrip(t, AMF3)
+}
@@ -0,0 +1,252 @@
+package amf
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SchemaKind is the kind of value a Schema accepts.
+type SchemaKind int
+
+const (
+	AnyKind       SchemaKind = iota // any value, including null
+	BooleanKind                     // bool
+	NumberKind                      // float64 or int
+	IntegerKind                     // int, or an integral float64 as AMF0 has
+	StringKind                      // string
+	DateKind                        // time.Time
+	ByteArrayKind                   // []byte
+	ArrayKind                       // []interface{}
+	ObjectKind                      // anonymous or typed object
+	ECMAArrayKind                   // ECMA array or AMF3 associative array
+)
+
+func (k SchemaKind) String() string {
+	switch k {
+	case AnyKind:
+		return "any"
+	case BooleanKind:
+		return "boolean"
+	case NumberKind:
+		return "number"
+	case IntegerKind:
+		return "integer"
+	case StringKind:
+		return "string"
+	case DateKind:
+		return "date"
+	case ByteArrayKind:
+		return "byte array"
+	case ArrayKind:
+		return "array"
+	case ObjectKind:
+		return "object"
+	case ECMAArrayKind:
+		return "ecma array"
+	}
+	return "SchemaKind(" + strconv.Itoa(int(k)) + ")"
+}
+
+// Schema describes the expected shape of a value decoded by DecodeAMF0 or
+// DecodeAMF3, with or without Ordered. Schemas are usually declared once per
+// class alias and shared by the schemas of the members holding the class,
+// which may refer back to them.
+type Schema struct {
+	Kind SchemaKind
+	// Nullable accepts null and undefined.
+	Nullable bool
+	// Class is the class alias objects must have, if not empty.
+	Class string
+	// Members holds the schemas of the members of objects and ECMA arrays,
+	// Required those that must be present and Closed rejects members that
+	// are not in Members.
+	Members  map[string]*Schema
+	Required []string
+	Closed   bool
+	// Items is the schema of the items of arrays.
+	Items *Schema
+}
+
+// ValidationError is a mismatch found by Validate. Path is empty for the value
+// itself, and otherwise like "user.tags[0]", as for Diff.
+type ValidationError struct {
+	Path    string
+	Message string
+}
+
+func (e ValidationError) Error() string {
+	path := e.Path
+	if path == "" {
+		path = "(root)"
+	}
+	return path + ": " + e.Message
+}
+
+// ValidationErrors lists every mismatch found by Validate.
+type ValidationErrors []ValidationError
+
+func (e ValidationErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Validate checks value against schema, returning ValidationErrors listing
+// every mismatch, or nil.
+func Validate(value interface{}, schema *Schema) error {
+	v := &validator{visited: make(map[validated]bool)}
+	v.validate("", value, schema)
+	if len(v.errors) > 0 {
+		return v.errors
+	}
+	return nil
+}
+
+// validated is a value already checked against a schema, as values may
+// refer to themselves.
+type validated struct {
+	value  interface{}
+	schema *Schema
+}
+
+type validator struct {
+	visited map[validated]bool
+	errors  ValidationErrors
+}
+
+func (v *validator) fail(path, format string, args ...interface{}) {
+	v.errors = append(v.errors, ValidationError{Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+func (v *validator) validate(path string, value interface{}, schema *Schema) {
+	if schema == nil || schema.Kind == AnyKind && schema.Class == "" && schema.Members == nil && schema.Items == nil &&
+		schema.Required == nil && !schema.Closed {
+		return
+	}
+	switch value.(type) {
+	case nil, Undefined:
+		if !schema.Nullable && schema.Kind != AnyKind {
+			v.fail(path, "expected %s, found null", schema.Kind)
+		}
+		return
+	}
+	if id, ok := Identity(value); ok {
+		key := validated{id, schema}
+		if v.visited[key] {
+			return
+		}
+		v.visited[key] = true
+	}
+	if schema.Kind != AnyKind && !schemaKindMatches(schema.Kind, value) {
+		v.fail(path, "expected %s, found %s", schema.Kind, valueType(value))
+		return
+	}
+	if schema.Class != "" && !schemaKindMatches(ObjectKind, value) {
+		v.fail(path, "expected class %s, found %s", schema.Class, valueType(value))
+		return
+	}
+	switch value := value.(type) {
+	case []interface{}:
+		if schema.Items != nil {
+			for i, item := range value {
+				v.validate(itemPath(path, i), item, schema.Items)
+			}
+		}
+		return
+	case ECMAArray, *OrderedECMAArray:
+		v.members(path, value, schema)
+		return
+	}
+	if members, ok := objectMembers(value); ok {
+		if class := className(value); schema.Class != "" && class != schema.Class {
+			if class == "" {
+				v.fail(path, "expected class %s, found anonymous object", schema.Class)
+			} else {
+				v.fail(path, "expected class %s, found %s", schema.Class, class)
+			}
+			return
+		}
+		v.members(path, members, schema)
+	}
+}
+
+// members checks the members of an object or ECMA array, in wire order when
+// it is known and sorted otherwise.
+func (v *validator) members(path string, value interface{}, schema *Schema) {
+	var keys []string
+	var values map[string]interface{}
+	switch value := value.(type) {
+	case *OrderedObject:
+		keys, values = value.keys, value.values
+	case *OrderedECMAArray:
+		keys, values = value.keys, value.values
+	default:
+		values, _ = objectMembers(value)
+		for key := range values {
+			keys = append(keys, key)
+		}
+		slices.Sort(keys)
+	}
+	for _, key := range schema.Required {
+		if _, ok := values[key]; !ok {
+			v.fail(memberPath(path, key), "missing required member")
+		}
+	}
+	for _, key := range keys {
+		member, ok := schema.Members[key]
+		if !ok {
+			if schema.Closed {
+				v.fail(memberPath(path, key), "unexpected member")
+			}
+			continue
+		}
+		v.validate(memberPath(path, key), values[key], member)
+	}
+}
+
+func schemaKindMatches(kind SchemaKind, value interface{}) bool {
+	switch value := value.(type) {
+	case bool:
+		return kind == BooleanKind
+	case int:
+		return kind == NumberKind || kind == IntegerKind
+	case float64:
+		return kind == NumberKind || kind == IntegerKind && value == math.Trunc(value) && !math.IsInf(value, 0)
+	case string:
+		return kind == StringKind
+	case time.Time:
+		return kind == DateKind
+	case []byte:
+		return kind == ByteArrayKind
+	case []interface{}:
+		return kind == ArrayKind
+	case ECMAArray, *OrderedECMAArray:
+		return kind == ECMAArrayKind
+	case map[string]interface{}, TypedObject, *OrderedObject:
+		return kind == ObjectKind
+	}
+	return false
+}
+
+// valueType names the type of a decoded value in messages.
+func valueType(value interface{}) string {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		return "object"
+	case ECMAArray:
+		return "ecma array"
+	case TypedObject:
+		return "obj<" + value.Class + ">"
+	case *OrderedObject:
+		if value.Class != "" {
+			return "obj<" + value.Class + ">"
+		}
+	}
+	return diffType(value)
+}
@@ -0,0 +1,107 @@
+package amf
+
+import (
+	"errors"
+	"testing"
+)
+
+func userSchema() *Schema {
+	user := &Schema{
+		Kind:     ObjectKind,
+		Class:    "com.example.User",
+		Required: []string{"id", "name"},
+		Members: map[string]*Schema{
+			"id":   {Kind: IntegerKind},
+			"name": {Kind: StringKind},
+			"tags": {Kind: ArrayKind, Items: &Schema{Kind: StringKind}},
+			"meta": {Kind: ECMAArrayKind, Closed: true, Members: map[string]*Schema{"v": {Kind: NumberKind}}},
+		},
+	}
+	user.Members["friend"] = &Schema{Kind: ObjectKind, Nullable: true, Class: user.Class, Members: user.Members}
+	return user
+}
+
+func TestValidate(t *testing.T) {
+	schema := userSchema()
+	for _, c := range []struct {
+		version AMFVersion
+		text    string
+		want    string // empty if valid
+	}{
+		{AMF3, `obj<com.example.User>{id: int(1), name: "ann", tags: ["a"], friend: null}`, ``},
+		{AMF0, `obj<com.example.User>{id: 1, name: "ann", meta: ecma{v: 2}}`, ``},
+		{AMF3, `obj<com.example.User>{id: 1.5, tags: ["a", 2], meta: ecma{v: "x", w: 1}}`,
+			`name: missing required member; id: expected integer, found number; meta.v: expected number, found string; ` +
+				`meta.w: unexpected member; tags[1]: expected string, found number`},
+		{AMF3, `obj<com.example.User>{id: int(1), name: "ann", friend: obj<com.example.Admin>{}}`,
+			`friend: expected class com.example.User, found com.example.Admin`},
+		{AMF3, `{id: int(1), name: "ann"}`, `(root): expected class com.example.User, found anonymous object`},
+		{AMF3, `[]`, `(root): expected object, found array`},
+		{AMF3, `null`, `(root): expected object, found null`},
+	} {
+		data := mustEncodeText(t, c.version, c.text)
+		for _, ordered := range []bool{false, true} {
+			d := &Decoder{Ordered: ordered}
+			var v interface{}
+			var err error
+			if c.version == AMF0 {
+				v, _, err = d.DecodeAMF0(data)
+			} else {
+				v, _, err = d.DecodeAMF3(data)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = Validate(v, schema)
+			if got := ""; err != nil {
+				got = err.Error()
+				if got != c.want {
+					t.Errorf("Validate(%s) with Ordered %v == %s, want %s", c.text, ordered, got, c.want)
+				}
+			} else if c.want != "" {
+				t.Errorf("Validate(%s) with Ordered %v succeeded, want %s", c.text, ordered, c.want)
+			}
+			var errs ValidationErrors
+			if err != nil && (!errors.As(err, &errs) || len(errs) == 0) {
+				t.Errorf("Validate(%s) == %#v, want ValidationErrors", c.text, err)
+			}
+		}
+	}
+}
+
+func TestValidateAnyKind(t *testing.T) {
+	for _, c := range []struct {
+		value  interface{}
+		schema *Schema
+		want   string // empty if valid
+	}{
+		{map[string]interface{}{"x": 1.0}, &Schema{}, ``},
+		{map[string]interface{}{"x": 1.0}, &Schema{Required: []string{"id"}, Closed: true},
+			`id: missing required member; x: unexpected member`},
+		{"a", &Schema{Class: "com.example.User"}, `(root): expected class com.example.User, found string`},
+		{ECMAArray{}, &Schema{Class: "com.example.User"}, `(root): expected class com.example.User, found ecma array`},
+		{TypedObject{Class: "com.example.User"}, &Schema{Class: "com.example.User"}, ``},
+	} {
+		got := ""
+		if err := Validate(c.value, c.schema); err != nil {
+			got = err.Error()
+		}
+		if got != c.want {
+			t.Errorf("Validate(%#v, %+v) == %s, want %s", c.value, c.schema, got, c.want)
+		}
+	}
+}
+
+func TestValidateCycle(t *testing.T) {
+	// {id: int(1), name: "ann", friend: <ref 0>}
+	data := []byte{0x0a, 0x33, 0x21, 0x63, 0x6f, 0x6d, 0x2e, 0x65, 0x78, 0x61, 0x6d, 0x70, 0x6c, 0x65, 0x2e, 0x55, 0x73, 0x65, 0x72,
+		0x05, 0x69, 0x64, 0x09, 0x6e, 0x61, 0x6d, 0x65, 0x0d, 0x66, 0x72, 0x69, 0x65, 0x6e, 0x64,
+		0x04, 0x01, 0x06, 0x07, 0x61, 0x6e, 0x6e, 0x0a, 0x00}
+	v, err := DecodeAMF3(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Validate(v, userSchema()); err != nil {
+		t.Errorf("Validate == %v", err)
+	}
+}
@@ -0,0 +1,148 @@
+// Package sol reads and writes Flash Local Shared Object (.sol) files.
+package sol
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+
+	amf "github.com/TatoExp/go-amf"
+)
+
+var (
+	magic     = []byte{0x00, 0xbf}
+	signature = []byte{'T', 'C', 'S', 'O', 0x00, 0x04, 0x00, 0x00, 0x00, 0x00}
+)
+
+type File struct {
+	Name    string
+	Version amf.AMFVersion
+	Data    map[string]interface{}
+}
+
+func Read(r io.Reader) (*File, error) {
+	v, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(v) < 6 || !bytes.Equal(v[:2], magic) {
+		return nil, fmt.Errorf("invalid header")
+	}
+	size := int(binary.BigEndian.Uint32(v[2:6]))
+	if size != len(v)-6 {
+		return nil, fmt.Errorf("invalid length %d, have %d", size, len(v)-6)
+	}
+	offset := 6
+	if len(v) < offset+len(signature)+2 || !bytes.Equal(v[offset:offset+len(signature)], signature) {
+		return nil, fmt.Errorf("invalid signature")
+	}
+	offset += len(signature)
+	name, n, err := readUTF8(v[offset:])
+	if err != nil {
+		return nil, err
+	}
+	offset += n
+	if len(v) < offset+4 {
+		return nil, fmt.Errorf("EOF")
+	}
+	f := &File{Name: name, Data: make(map[string]interface{})}
+	switch binary.BigEndian.Uint32(v[offset : offset+4]) {
+	case 0:
+		f.Version = amf.AMF0
+	case 3:
+		f.Version = amf.AMF3
+	default:
+		return nil, fmt.Errorf("unsupported version %d", binary.BigEndian.Uint32(v[offset:offset+4]))
+	}
+	offset += 4
+	dec := amf.NewDecoder()
+	for offset < len(v) {
+		var key string
+		var value interface{}
+		if f.Version == amf.AMF0 {
+			key, n, err = readUTF8(v[offset:])
+			if err != nil {
+				return nil, err
+			}
+			offset += n
+			value, n, err = dec.DecodeAMF0(v[offset:])
+		} else {
+			key, n, err = dec.DecodeString3(v[offset:])
+			if err != nil {
+				return nil, err
+			}
+			offset += n
+			value, n, err = dec.DecodeAMF3(v[offset:])
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", key, err)
+		}
+		offset += n
+		if offset >= len(v) || v[offset] != 0x00 {
+			return nil, fmt.Errorf("%s: invalid end of entry", key)
+		}
+		offset++
+		f.Data[key] = value
+	}
+	return f, nil
+}
+
+func Write(w io.Writer, f *File) error {
+	body := &bytes.Buffer{}
+	body.Write(signature)
+	writeUTF8(body, f.Name)
+	switch f.Version {
+	case amf.AMF0:
+		binary.Write(body, binary.BigEndian, uint32(0))
+	case amf.AMF3:
+		binary.Write(body, binary.BigEndian, uint32(3))
+	default:
+		return fmt.Errorf("unsupported version %d", f.Version)
+	}
+	var keys []string
+	for k := range f.Data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	enc := amf.NewEncoder()
+	for _, key := range keys {
+		var err error
+		if f.Version == amf.AMF0 {
+			writeUTF8(body, key)
+			_, err = enc.EncodeAMF0(body, f.Data[key])
+		} else {
+			enc.EncodeString3(body, key)
+			_, err = enc.EncodeAMF3(body, f.Data[key])
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %s", key, err)
+		}
+		body.WriteByte(0x00)
+	}
+	header := make([]byte, 6)
+	copy(header, magic)
+	binary.BigEndian.PutUint32(header[2:], uint32(body.Len()))
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	_, err := body.WriteTo(w)
+	return err
+}
+
+func readUTF8(v []byte) (string, int, error) {
+	if len(v) < 2 {
+		return "", 0, fmt.Errorf("EOF")
+	}
+	strlen := int(binary.BigEndian.Uint16(v[:2]))
+	if len(v) < 2+strlen {
+		return "", 0, fmt.Errorf("EOF")
+	}
+	return string(v[2 : 2+strlen]), 2 + strlen, nil
+}
+
+func writeUTF8(w io.Writer, v string) {
+	binary.Write(w, binary.BigEndian, uint16(len(v)))
+	io.WriteString(w, v)
+}
@@ -0,0 +1,86 @@
+package sol
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	amf "github.com/TatoExp/go-amf"
+)
+
+type solTestCase struct {
+	file *File
+	data []byte
+}
+
+var shared = []interface{}{1.0}
+
+var solCases = []solTestCase{
+	{&File{"test", amf.AMF0, map[string]interface{}{
+		"a": 1.0,
+		"b": "x"}}, []byte{0x00, 0xbf,
+		0x00, 0x00, 0x00, 0x29,
+		0x54, 0x43, 0x53, 0x4f, 0x00, 0x04, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x04, 0x74, 0x65, 0x73, 0x74,
+		0x00, 0x00, 0x00, 0x00,
+		0x00, 0x01, 0x61, 0x00, 0x3f, 0xf0, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x01, 0x62, 0x02, 0x00, 0x01, 0x78, 0x00}},
+	// Entries share the reference table.
+	{&File{"test", amf.AMF0, map[string]interface{}{
+		"a": shared,
+		"b": shared}}, []byte{0x00, 0xbf,
+		0x00, 0x00, 0x00, 0x2d,
+		0x54, 0x43, 0x53, 0x4f, 0x00, 0x04, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x04, 0x74, 0x65, 0x73, 0x74,
+		0x00, 0x00, 0x00, 0x00,
+		0x00, 0x01, 0x61, 0x0a, 0x00, 0x00, 0x00, 0x01, 0x00, 0x3f, 0xf0, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x01, 0x62, 0x07, 0x00, 0x00, 0x00}},
+	{&File{"test", amf.AMF3, map[string]interface{}{
+		"a": map[string]interface{}{"b": 1},
+		"b": "a"}}, []byte{0x00, 0xbf,
+		0x00, 0x00, 0x00, 0x23,
+		0x54, 0x43, 0x53, 0x4f, 0x00, 0x04, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x04, 0x74, 0x65, 0x73, 0x74,
+		0x00, 0x00, 0x00, 0x03,
+		0x03, 0x61, 0x0a, 0x0b, 0x01, 0x03, 0x62, 0x04, 0x01, 0x01, 0x00,
+		0x02, 0x06, 0x00, 0x00}},
+}
+
+func TestWrite(t *testing.T) {
+	for _, c := range solCases {
+		buf := &bytes.Buffer{}
+		if err := Write(buf, c.file); err != nil {
+			t.Error(err)
+			continue
+		}
+		if !bytes.Equal(buf.Bytes(), c.data) {
+			t.Errorf("Write(%#v) == %#v, want %#v", c.file, buf.Bytes(), c.data)
+		}
+	}
+}
+
+func TestRead(t *testing.T) {
+	for _, c := range solCases {
+		got, err := Read(bytes.NewReader(c.data))
+		if err != nil {
+			t.Errorf("Read(%#v): %s", c.data, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, c.file) {
+			t.Errorf("Read(%#v) == %#v, want %#v", c.data, got, c.file)
+		}
+	}
+}
+
+func TestReadInvalid(t *testing.T) {
+	data := solCases[0].data
+	for _, in := range [][]byte{
+		data[:4],
+		data[:len(data)-1],
+		append([]byte{0x00, 0xbe}, data[2:]...),
+	} {
+		if _, err := Read(bytes.NewReader(in)); err == nil {
+			t.Errorf("Read(%#v) succeeded", in)
+		}
+	}
+}
@@ -0,0 +1,494 @@
+package amf
+
+import (
+	"encoding/hex"
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Format returns the text notation of a value as produced by the decoders,
+// meant for test fixtures that are easier to read and diff than bytes:
+//
+//	null  undefined  true  false
+//	1.5  NaN  -Inf          Number (AMF0) or double (AMF3)
+//	int(3)                  AMF3 integer
+//	"text"                  string, with Go escapes
+//	date("2006-01-02T15:04:05.000Z")
+//	bytes("0a0b")           ByteArray
+//	[1, "two"]              strict array
+//	{name: "x", "a b": 1}   anonymous object
+//	obj<>{id: 1; x: 2}      anonymous object with sealed members
+//	ecma{"0": 1}            ECMA array
+//	obj<com.acme.User>{id: int(1); extra: true}
+//
+// In typed objects and obj<> the members before ";" are sealed and the ones
+// after it dynamic; without ";" the object is not dynamic. Maps are written in
+// key order. Values referred to more than once are written each time, and a
+// value containing itself is an error.
+func Format(v interface{}) (string, error) {
+	return FormatIndent(v, "")
+}
+
+// FormatIndent is like Format but puts each member and array item on its own
+// line, indented by indent per level.
+func FormatIndent(v interface{}, indent string) (string, error) {
+	f := &formatter{indent: indent, visiting: make(map[interface{}]bool)}
+	b, err := f.appendText(nil, v, 0)
+	return string(b), err
+}
+
+// formatter holds the containers being written, to stop at cycles.
+type formatter struct {
+	indent   string
+	visiting map[interface{}]bool
+}
+
+const textDateLayout = "2006-01-02T15:04:05.000Z07:00"
+
+func (f *formatter) appendText(b []byte, v interface{}, depth int) ([]byte, error) {
+	if id, ok := Identity(v); ok {
+		if f.visiting[id] {
+			return b, fmt.Errorf("cycle through %T", v)
+		}
+		f.visiting[id] = true
+		defer delete(f.visiting, id)
+	}
+	indent := f.indent
+	switch v := v.(type) {
+	case nil:
+		return append(b, "null"...), nil
+	case Undefined:
+		return append(b, "undefined"...), nil
+	case bool:
+		return strconv.AppendBool(b, v), nil
+	case int:
+		b = append(b, "int("...)
+		b = strconv.AppendInt(b, int64(v), 10)
+		return append(b, ')'), nil
+	case float64:
+		if math.IsInf(v, 1) {
+			return append(b, "Inf"...), nil
+		}
+		return strconv.AppendFloat(b, v, 'g', -1, 64), nil
+	case string:
+		return strconv.AppendQuote(b, v), nil
+	case time.Time:
+		b = append(b, "date("...)
+		b = strconv.AppendQuote(b, v.UTC().Format(textDateLayout))
+		return append(b, ')'), nil
+	case []byte:
+		b = append(b, "bytes(\""...)
+		b = hex.AppendEncode(b, v)
+		return append(b, "\")"...), nil
+	case []interface{}:
+		b = append(b, '[')
+		for i, item := range v {
+			b = appendSeparator(b, i, ',', indent, depth+1)
+			var err error
+			if b, err = f.appendText(b, item, depth+1); err != nil {
+				return b, err
+			}
+		}
+		return appendClose(b, len(v), ']', indent, depth), nil
+	case map[string]interface{}:
+		return f.appendMembers(b, slices.Sorted(maps.Keys(v)), v, -1, depth)
+	case ECMAArray:
+		b = append(b, "ecma"...)
+		return f.appendMembers(b, slices.Sorted(maps.Keys(v)), v, -1, depth)
+	case TypedObject:
+		b = appendClass(b, v.Class)
+		return f.appendMembers(b, slices.Sorted(maps.Keys(v.Members)), v.Members, -1, depth)
+	case *OrderedECMAArray:
+		b = append(b, "ecma"...)
+		return f.appendMembers(b, v.keys, v.values, -1, depth)
+	case *OrderedObject:
+		if v.Class == "" && v.sealed() == 0 {
+			return f.appendMembers(b, v.keys, v.values, -1, depth)
+		}
+		b = appendClass(b, v.Class)
+		sealed := -1
+		if v.dynamic() {
+			sealed = v.sealed()
+		}
+		return f.appendMembers(b, v.keys, v.values, sealed, depth)
+	}
+	return b, fmt.Errorf("type %T not supported", v)
+}
+
+func appendClass(b []byte, class string) []byte {
+	b = append(b, "obj<"...)
+	if isTextClass(class) || class == "" {
+		b = append(b, class...)
+	} else {
+		b = strconv.AppendQuote(b, class)
+	}
+	return append(b, '>')
+}
+
+// appendMembers writes the members in keys order. If sealed is not negative
+// a ";" follows the first sealed members, marking the object as dynamic.
+func (f *formatter) appendMembers(b []byte, keys []string, v map[string]interface{}, sealed int, depth int) ([]byte, error) {
+	indent := f.indent
+	b = append(b, '{')
+	if sealed == 0 {
+		b = append(b, ';')
+		if len(keys) > 0 && indent == "" {
+			b = append(b, ' ')
+		}
+	}
+	var err error
+	for i, key := range keys {
+		sep := byte(',')
+		if i == sealed {
+			sep = ';'
+		}
+		b = appendSeparator(b, i, sep, indent, depth+1)
+		if isTextIdent(key) {
+			b = append(b, key...)
+		} else {
+			b = strconv.AppendQuote(b, key)
+		}
+		b = append(b, ": "...)
+		if b, err = f.appendText(b, v[key], depth+1); err != nil {
+			return b, fmt.Errorf("%s: %s", key, err)
+		}
+	}
+	if sealed > 0 && sealed == len(keys) {
+		b = append(b, ';')
+	}
+	return appendClose(b, len(keys), '}', indent, depth), nil
+}
+
+func appendSeparator(b []byte, i int, sep byte, indent string, depth int) []byte {
+	if i > 0 {
+		b = append(b, sep)
+	}
+	if indent != "" {
+		return appendNewline(b, indent, depth)
+	}
+	if i > 0 {
+		b = append(b, ' ')
+	}
+	return b
+}
+
+func appendClose(b []byte, n int, c byte, indent string, depth int) []byte {
+	if n > 0 && indent != "" {
+		b = appendNewline(b, indent, depth)
+	}
+	return append(b, c)
+}
+
+func appendNewline(b []byte, indent string, depth int) []byte {
+	b = append(b, '\n')
+	for i := 0; i < depth; i++ {
+		b = append(b, indent...)
+	}
+	return b
+}
+
+func isIdentChar(c byte, first bool) bool {
+	return c == '_' || c == '$' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || !first && c >= '0' && c <= '9'
+}
+
+func isClassChar(c byte) bool {
+	return isIdentChar(c, false) || c == '.' || c == ':'
+}
+
+func isTextIdent(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if !isIdentChar(s[i], i == 0) {
+			return false
+		}
+	}
+	return s != ""
+}
+
+func isTextClass(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if !isClassChar(s[i]) {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// Parse reads a value written in the notation of Format. Objects and ECMA
+// arrays are returned as OrderedObject and OrderedECMAArray, as a Decoder
+// with Ordered and KeepUndefined set would return them. Line comments
+// starting with "//" are allowed.
+func Parse(text string) (interface{}, error) {
+	p := &textParser{s: text}
+	v, err := p.value()
+	if err != nil {
+		return nil, err
+	}
+	if p.skip(); p.pos < len(p.s) {
+		return nil, p.errorf("unexpected %q after value", p.s[p.pos])
+	}
+	return v, nil
+}
+
+type textParser struct {
+	s   string
+	pos int
+}
+
+func (p *textParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("offset %d: %s", p.pos, fmt.Sprintf(format, args...))
+}
+
+// skip moves past white space and comments.
+func (p *textParser) skip() {
+	for p.pos < len(p.s) {
+		switch {
+		case strings.HasPrefix(p.s[p.pos:], "//"):
+			for p.pos < len(p.s) && p.s[p.pos] != '\n' {
+				p.pos++
+			}
+		case strings.ContainsRune(" \t\r\n", rune(p.s[p.pos])):
+			p.pos++
+		default:
+			return
+		}
+	}
+}
+
+// next returns the next character after white space, or 0 at the end.
+func (p *textParser) next() byte {
+	p.skip()
+	if p.pos >= len(p.s) {
+		return 0
+	}
+	return p.s[p.pos]
+}
+
+func (p *textParser) expect(c byte) error {
+	if p.next() != c {
+		if p.pos >= len(p.s) {
+			return p.errorf("EOF, want %q", c)
+		}
+		return p.errorf("unexpected %q, want %q", p.s[p.pos], c)
+	}
+	p.pos++
+	return nil
+}
+
+func (p *textParser) ident() string {
+	start := p.pos
+	for p.pos < len(p.s) && isIdentChar(p.s[p.pos], p.pos == start) {
+		p.pos++
+	}
+	return p.s[start:p.pos]
+}
+
+func (p *textParser) str() (string, error) {
+	if p.next() != '"' {
+		return "", p.errorf("string expected")
+	}
+	start := p.pos
+	for p.pos++; p.pos < len(p.s) && p.s[p.pos] != '"'; p.pos++ {
+		if p.s[p.pos] == '\\' {
+			p.pos++
+		}
+	}
+	if p.pos >= len(p.s) {
+		return "", p.errorf("unterminated string")
+	}
+	p.pos++
+	s, err := strconv.Unquote(p.s[start:p.pos])
+	if err != nil {
+		p.pos = start
+		return "", p.errorf("invalid string")
+	}
+	return s, nil
+}
+
+// call reads the parenthesized argument of int, date and bytes.
+func (p *textParser) call(name string) (interface{}, error) {
+	if err := p.expect('('); err != nil {
+		return nil, err
+	}
+	var result interface{}
+	var err error
+	start := p.pos
+	if name == "int" {
+		p.next()
+		start = p.pos
+		for p.pos < len(p.s) && strings.ContainsRune("+-0123456789", rune(p.s[p.pos])) {
+			p.pos++
+		}
+		result, err = strconv.Atoi(p.s[start:p.pos])
+	} else {
+		var s string
+		if s, err = p.str(); err != nil {
+			return nil, err
+		}
+		if name == "date" {
+			result, err = time.Parse(time.RFC3339Nano, s)
+		} else {
+			result, err = hex.DecodeString(s)
+		}
+	}
+	if err != nil {
+		p.pos = start
+		return nil, p.errorf("invalid %s", name)
+	}
+	return result, p.expect(')')
+}
+
+func (p *textParser) value() (interface{}, error) {
+	c := p.next()
+	switch {
+	case c == '"':
+		return p.str()
+	case c == '[':
+		p.pos++
+		result := []interface{}{}
+		for p.next() != ']' {
+			v, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, v)
+			if p.next() != ']' {
+				if err := p.expect(','); err != nil {
+					return nil, err
+				}
+			}
+		}
+		p.pos++
+		return result, nil
+	case c == '{':
+		result := &OrderedObject{}
+		return result, p.members(result.Set, nil)
+	case c == '-' || c == '+' || c >= '0' && c <= '9' || c == '.':
+		start := p.pos
+		p.pos++
+		if strings.HasPrefix(p.s[p.pos:], "Inf") {
+			p.pos += 3
+			return strconv.ParseFloat(p.s[start:p.pos], 64)
+		}
+		for p.pos < len(p.s) && strings.ContainsRune("+-.0123456789eE", rune(p.s[p.pos])) {
+			p.pos++
+		}
+		f, err := strconv.ParseFloat(p.s[start:p.pos], 64)
+		if err != nil {
+			p.pos = start
+			return nil, p.errorf("invalid number")
+		}
+		return f, nil
+	case c == 0:
+		return nil, p.errorf("EOF")
+	}
+	start := p.pos
+	switch name := p.ident(); name {
+	case "null":
+		return nil, nil
+	case "undefined":
+		return Undefined{}, nil
+	case "true", "false":
+		return name == "true", nil
+	case "NaN":
+		return math.NaN(), nil
+	case "Inf":
+		return math.Inf(1), nil
+	case "int", "date", "bytes":
+		return p.call(name)
+	case "ecma":
+		result := &OrderedECMAArray{}
+		return result, p.members(result.Set, nil)
+	case "obj":
+		return p.object()
+	}
+	p.pos = start
+	return nil, p.errorf("unexpected %q", c)
+}
+
+func (p *textParser) object() (interface{}, error) {
+	if err := p.expect('<'); err != nil {
+		return nil, err
+	}
+	result := &OrderedObject{Sealed: -1}
+	if p.next() == '"' {
+		class, err := p.str()
+		if err != nil {
+			return nil, err
+		}
+		result.Class = class
+	} else {
+		start := p.pos
+		for p.pos < len(p.s) && isClassChar(p.s[p.pos]) {
+			p.pos++
+		}
+		result.Class = p.s[start:p.pos]
+	}
+	if err := p.expect('>'); err != nil {
+		return nil, err
+	}
+	err := p.members(result.Set, func() error {
+		if result.Dynamic {
+			return p.errorf("duplicate \";\"")
+		}
+		result.Sealed, result.Dynamic = result.Len(), true
+		return nil
+	})
+	if !result.Dynamic {
+		result.Sealed = result.Len()
+	}
+	if err == nil && result.Class == "" && result.Sealed == 0 {
+		return nil, p.errorf("anonymous object without sealed members")
+	}
+	return result, err
+}
+
+// members reads "{key: value, ...}". dynamic is called for ";", which is
+// only allowed in typed objects.
+func (p *textParser) members(set func(string, interface{}), dynamic func() error) error {
+	if err := p.expect('{'); err != nil {
+		return err
+	}
+	for {
+		switch c := p.next(); c {
+		case '}':
+			p.pos++
+			return nil
+		case ';':
+			if dynamic == nil {
+				return p.errorf("unexpected \";\"")
+			}
+			if err := dynamic(); err != nil {
+				return err
+			}
+			p.pos++
+			continue
+		}
+		var key string
+		if p.next() == '"' {
+			var err error
+			if key, err = p.str(); err != nil {
+				return err
+			}
+		} else if key = p.ident(); key == "" {
+			return p.errorf("member name expected")
+		}
+		if err := p.expect(':'); err != nil {
+			return err
+		}
+		v, err := p.value()
+		if err != nil {
+			return fmt.Errorf("%s: %s", key, err)
+		}
+		set(key, v)
+		if c := p.next(); c != '}' && c != ';' {
+			if err := p.expect(','); err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package amf
+
+import (
+	"bytes"
+	"testing"
+)
+
+var textCases = []string{
+	`null`,
+	`undefined`,
+	`true`,
+	`int(-3)`,
+	`1.5`,
+	`-2e+300`,
+	`NaN`,
+	`Inf`,
+	`-Inf`,
+	`"a\"b\né"`,
+	`date("2009-02-13T23:31:30.123Z")`,
+	`bytes("0a0b")`,
+	`[]`,
+	`[1, [int(2)], {}]`,
+	`{b: 1, a: "x", "a b": null, "0": true}`,
+	`ecma{"1": 1, "0": 2}`,
+	`obj<com.acme.User>{id: int(1), name: "x"}`,
+	`obj<Foo>{a: 1; b: 2}`,
+	`obj<Foo>{a: 1;}`,
+	`obj<Foo>{; b: 2}`,
+	`obj<Foo>{;}`,
+	`obj<"a b">{}`,
+	`obj<>{a: int(1)}`,
+	`obj<>{a: 1; b: 2}`,
+}
+
+func TestFormatParse(t *testing.T) {
+	for _, text := range textCases {
+		v, err := Parse(text)
+		if err != nil {
+			t.Errorf("Parse(%s): %s", text, err)
+			continue
+		}
+		if got, err := Format(v); err != nil || got != text {
+			t.Errorf("Format(Parse(%s)) == %s, %v", text, got, err)
+		}
+	}
+}
+
+func TestFormatIndent(t *testing.T) {
+	v, err := Parse(`
+		obj<Foo>{
+			a: [1, 2], // sealed
+			b: {};
+			c: ecma{x: null},
+		}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "obj<Foo>{\n\ta: [\n\t\t1,\n\t\t2\n\t],\n\tb: {};\n\tc: ecma{\n\t\tx: null\n\t}\n}"
+	if got, err := FormatIndent(v, "\t"); err != nil || got != want {
+		t.Errorf("FormatIndent() == %q, %v, want %q", got, err, want)
+	}
+}
+
+func TestFormatCycle(t *testing.T) {
+	// {self: <ref 0>}
+	v, err := DecodeAMF3([]byte{0x0a, 0x0b, 0x01, 0x09, 0x73, 0x65, 0x6c, 0x66, 0x0a, 0x00, 0x01})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := Format(v); err == nil {
+		t.Errorf("Format of a cyclic value == %s", got)
+	}
+	shared := []interface{}{1.0}
+	if got, err := Format([]interface{}{shared, shared}); err != nil || got != `[[1], [1]]` {
+		t.Errorf("Format of a shared value == %s, %v", got, err)
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, text := range []string{
+		``,
+		`nul`,
+		`1 2`,
+		`[1,`,
+		`[1 2]`,
+		`{a 1}`,
+		`{a: 1; b: 2}`,
+		`obj<Foo>{a: 1; b: 2; c: 3}`,
+		`obj<>{}`,
+		`obj<>{; a: 1}`,
+		`int(1.5)`,
+		`date("yesterday")`,
+		`bytes("0")`,
+		`"abc`,
+	} {
+		if v, err := Parse(text); err == nil {
+			t.Errorf("Parse(%s) == %#v", text, v)
+		}
+	}
+}
+
+// Golden fixtures written as text.
+var textGoldenCases = []struct {
+	version AMFVersion
+	text    string
+	want    []byte
+}{
+	{AMF0, `{b: true, a: null}`, []byte{0x03,
+		0x00, 0x01, 0x62, 0x01, 0x01,
+		0x00, 0x01, 0x61, 0x05,
+		0x00, 0x00, 0x09}},
+	{AMF0, `obj<Foo>{a: 1}`, []byte{0x10, 0x00, 0x03, 0x46, 0x6f, 0x6f,
+		0x00, 0x01, 0x61, 0x00, 0x3f, 0xf0, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x09}},
+	{AMF0, `ecma{"0": undefined}`, []byte{0x08, 0x00, 0x00, 0x00, 0x01,
+		0x00, 0x01, 0x30, 0x06,
+		0x00, 0x00, 0x09}},
+	{AMF3, `obj<Foo>{b: int(1), a: int(2); c: int(3)}`, []byte{0x0a, 0x2b, 0x07, 0x46, 0x6f, 0x6f,
+		0x03, 0x62, 0x03, 0x61,
+		0x04, 0x01,
+		0x04, 0x02,
+		0x03, 0x63, 0x04, 0x03,
+		0x01}},
+	{AMF3, `[bytes("01"), "a", "a"]`, []byte{0x09, 0x07, 0x01,
+		0x0c, 0x03, 0x01,
+		0x06, 0x03, 0x61,
+		0x06, 0x00}},
+}
+
+func TestTextGolden(t *testing.T) {
+	for _, c := range textGoldenCases {
+		v, err := Parse(c.text)
+		if err != nil {
+			t.Errorf("Parse(%s): %s", c.text, err)
+			continue
+		}
+		buf := &bytes.Buffer{}
+		d := &Decoder{Ordered: true, KeepUndefined: true}
+		var decoded interface{}
+		var derr error
+		if c.version == AMF0 {
+			_, err = EncodeAMF0(buf, v)
+			decoded, _, derr = d.DecodeAMF0(c.want)
+		} else {
+			_, err = EncodeAMF3(buf, v)
+			decoded, _, derr = d.DecodeAMF3(c.want)
+		}
+		if err != nil || !bytes.Equal(buf.Bytes(), c.want) {
+			t.Errorf("encode %s == %#v, %v, want %#v", c.text, buf.Bytes(), err, c.want)
+		}
+		if derr != nil {
+			t.Errorf("decode %s: %s", c.text, derr)
+			continue
+		}
+		if got, err := Format(decoded); err != nil || got != c.text {
+			t.Errorf("Format(decode %#v) == %s, %v, want %s", c.want, got, err, c.text)
+		}
+	}
+}
@@ -0,0 +1,638 @@
+package amf
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+)
+
+// TranscodeAMF0ToAMF3 rewrites consecutive AMF0 values, such as the
+// arguments of an RTMP command, as AMF3 values sharing their reference
+// tables. ECMA arrays become associative arrays, typed objects sealed traits,
+// anonymous objects dynamic ones, strict arrays dense arrays and XML
+// documents AMF3 XML documents. Numbers that are AMF3 integers become
+// integers, and references are still references. Values after an AVM+ marker
+// are copied with their references renumbered.
+func TranscodeAMF0ToAMF3(data []byte) ([]byte, error) {
+	return transcode(data, AMF0, AMF3)
+}
+
+// TranscodeAMF3ToAMF0 is the inverse of TranscodeAMF0ToAMF3. The dynamic
+// members of typed objects follow their sealed members, integers become
+// Numbers and both kinds of XML become XML documents. Arrays with named
+// members become ECMA arrays, their dense items following as members "0",
+// "1" and so on, vectors become strict arrays and ArrayCollections the
+// arrays they wrap. Byte arrays switch to AMF3, as AMF0 has none, and
+// dictionaries are an error. Dates, XML and byte arrays referred to again
+// are written again, as AMF0 has no references to them.
+func TranscodeAMF3ToAMF0(data []byte) ([]byte, error) {
+	return transcode(data, AMF3, AMF0)
+}
+
+// transcode walks the encoding of the values, writing each one as it is read
+// so that the objects of both versions are numbered in the same order.
+func transcode(data []byte, from, to AMFVersion) ([]byte, error) {
+	t := &transcoder{data: data, to: to}
+	for offset := 0; offset < len(data); {
+		var end int
+		var err error
+		if from == AMF0 {
+			end, err = t.value0(offset)
+		} else {
+			end, err = t.value3(offset)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("offset %d: %s", offset, err)
+		}
+		offset = end
+	}
+	return t.b, nil
+}
+
+// transcoder maps the reference tables of the values read to those of the
+// values written. The Decoder holds the strings and traits read from AMF3,
+// and the Encoder those written in AMF3.
+type transcoder struct {
+	data []byte
+	to   AMFVersion
+	b    []byte
+	d    Decoder
+	e    Encoder
+	// The objects read, as written.
+	objects0, objects3 []target
+	// The number of objects written.
+	nobjects int
+	// The member names of AMF0 typed objects scanned, by offset.
+	keys map[int][]string
+}
+
+// target is an object read, as a reference to the object written for it, or
+// for values the output can't refer to, their encoding to write again.
+type target struct {
+	index  int
+	marker byte
+	value  []byte
+}
+
+func (t *transcoder) need(offset, n int) error {
+	if offset+n > len(t.data) {
+		return fmt.Errorf("EOF")
+	}
+	return nil
+}
+
+// add numbers an object written with marker.
+func (t *transcoder) add(marker byte) target {
+	t.nobjects++
+	return target{index: t.nobjects - 1, marker: marker}
+}
+
+// ref writes a reference to an object read before.
+func (t *transcoder) ref(objects []target, ref int) error {
+	if ref >= len(objects) {
+		return fmt.Errorf("invalid reference %d", ref)
+	}
+	r := objects[ref]
+	switch {
+	case r.value != nil:
+		t.b = append(t.b, r.value...)
+	case t.to == AMF0:
+		if r.index > 0xffff {
+			return fmt.Errorf("reference %d beyond AMF0 references", r.index)
+		}
+		t.b = append(t.b, amf0Reference)
+		t.b = binary.BigEndian.AppendUint16(t.b, uint16(r.index))
+	default:
+		t.b = append(t.b, r.marker)
+		t.b = encodeU29(t.b, r.index<<1)
+	}
+	return nil
+}
+
+// value0 writes the AMF0 value at offset in AMF3.
+func (t *transcoder) value0(offset int) (int, error) {
+	if err := t.need(offset, 1); err != nil {
+		return 0, err
+	}
+	switch t.data[offset] {
+	case amf0Number:
+		f, n, err := decodeNumber(t.data[offset:])
+		if err != nil {
+			return 0, err
+		}
+		if f == math.Trunc(f) && f >= amf3MinInt && f <= amf3MaxInt && !(f == 0 && math.Signbit(f)) {
+			t.b = encodeInteger3(t.b, int(f))
+		} else {
+			t.b = encodeDouble3(t.b, f)
+		}
+		return offset + n, nil
+	case amf0Boolean:
+		v, n, err := t.d.decodeBoolean(t.data[offset:])
+		t.b = encodeBoolean3(t.b, v)
+		return offset + n, err
+	case amf0String, amf0StringExt:
+		s, n, err := decodeString(t.data[offset:])
+		t.b = t.e.encodeString3(t.b, s)
+		return offset + n, err
+	case amf0XMLDocument:
+		if err := t.need(offset, 5); err != nil {
+			return 0, err
+		}
+		size := int(binary.BigEndian.Uint32(t.data[offset+1:]))
+		if err := t.need(offset+5, size); err != nil {
+			return 0, err
+		}
+		t.add(amf3XMLDocument)
+		t.b = append(t.b, amf3XMLDocument)
+		t.b = encodeU29(t.b, size<<1|1)
+		t.b = append(t.b, t.data[offset+5:offset+5+size]...)
+		return offset + 5 + size, nil
+	case amf0Null:
+		t.b = encodeNull3(t.b)
+		return offset + 1, nil
+	case amf0Undefined, amf0Unsupported:
+		t.b = append(t.b, amf3Undefined)
+		return offset + 1, nil
+	case amf0Reference:
+		if err := t.need(offset, 3); err != nil {
+			return 0, err
+		}
+		return offset + 3, t.ref(t.objects0, int(binary.BigEndian.Uint16(t.data[offset+1:])))
+	case amf0Date:
+		if err := t.need(offset, 11); err != nil {
+			return 0, err
+		}
+		t.add(amf3Date)
+		t.b = append(t.b, amf3Date, 0x01)
+		t.b = append(t.b, t.data[offset+1:offset+9]...)
+		return offset + 11, nil
+	case amf0Object:
+		t.objects0 = append(t.objects0, t.add(amf3Object))
+		t.b = append(t.b, amf3Object)
+		t.b = t.e.encodeTraits3(t.b, "", nil, true)
+		return t.members0(offset+1, true)
+	case amf0TypedObject:
+		keys, err := t.keys0(offset)
+		if err != nil {
+			return 0, err
+		}
+		class, n, err := decodeUTF8(t.data[offset+1:])
+		if err != nil {
+			return 0, err
+		}
+		offset += 1 + n
+		t.objects0 = append(t.objects0, t.add(amf3Object))
+		t.b = append(t.b, amf3Object)
+		t.b = t.e.encodeTraits3(t.b, class, keys, false)
+		return t.members0(offset, false)
+	case amf0Array:
+		if err := t.need(offset, 5); err != nil {
+			return 0, err
+		}
+		t.objects0 = append(t.objects0, t.add(amf3Array))
+		t.b = append(t.b, amf3Array, 0x01)
+		return t.members0(offset+5, true)
+	case amf0StrictArr:
+		if err := t.need(offset, 5); err != nil {
+			return 0, err
+		}
+		num := int(binary.BigEndian.Uint32(t.data[offset+1:]))
+		t.objects0 = append(t.objects0, t.add(amf3Array))
+		t.b = append(t.b, amf3Array)
+		t.b = encodeU29(t.b, num<<1|1)
+		t.b = append(t.b, 0x01)
+		return t.items(offset+5, num, t.value0)
+	case amf0AVMPlus:
+		t.d.strings3, t.d.traits3, t.objects3 = nil, nil, nil
+		return t.value3(offset + 1)
+	}
+	return 0, fmt.Errorf("unsupported type 0x%0X", t.data[offset])
+}
+
+// keys0 returns the member names of the AMF0 typed object at offset, as
+// sealed traits list them before the values. The first typed object of a
+// value is scanned whole, recording the names of those inside it, so that
+// nested typed objects are not scanned again.
+func (t *transcoder) keys0(offset int) ([]string, error) {
+	if _, ok := t.keys[offset]; !ok {
+		if _, err := t.scan0(offset); err != nil {
+			return nil, err
+		}
+	}
+	keys := t.keys[offset]
+	delete(t.keys, offset)
+	return keys, nil
+}
+
+// scan0 walks the AMF0 value at offset without writing it, recording the
+// member names of the typed objects in it.
+func (t *transcoder) scan0(offset int) (int, error) {
+	if err := t.need(offset, 1); err != nil {
+		return 0, err
+	}
+	switch t.data[offset] {
+	case amf0Object:
+		return t.scanMembers0(offset+1, nil)
+	case amf0TypedObject:
+		_, n, err := decodeUTF8(t.data[offset+1:])
+		if err != nil {
+			return 0, err
+		}
+		keys := []string{}
+		end, err := t.scanMembers0(offset+1+n, &keys)
+		if t.keys == nil {
+			t.keys = make(map[int][]string)
+		}
+		t.keys[offset] = keys
+		return end, err
+	case amf0Array, amf0StrictArr, amf0XMLDocument:
+		if err := t.need(offset, 5); err != nil {
+			return 0, err
+		}
+	case amf0Unsupported:
+		return offset + 1, nil
+	}
+	switch t.data[offset] {
+	case amf0Array:
+		return t.scanMembers0(offset+5, nil)
+	case amf0StrictArr:
+		num := int(binary.BigEndian.Uint32(t.data[offset+1:]))
+		offset += 5
+		for i := 0; i < num; i++ {
+			var err error
+			if offset, err = t.scan0(offset); err != nil {
+				return 0, err
+			}
+		}
+		return offset, nil
+	case amf0XMLDocument:
+		size := int(binary.BigEndian.Uint32(t.data[offset+1:]))
+		return offset + 5 + size, t.need(offset+5, size)
+	}
+	w := rawWalker{data: t.data}
+	return w.value0(offset, nil)
+}
+
+func (t *transcoder) scanMembers0(offset int, keys *[]string) (int, error) {
+	for {
+		key, n, err := decodeUTF8(t.data[offset:])
+		if err != nil {
+			return 0, err
+		}
+		offset += n
+		if key == "" {
+			if offset >= len(t.data) || t.data[offset] != amf0ObjectEnd {
+				return 0, fmt.Errorf("invalid end of object")
+			}
+			return offset + 1, nil
+		}
+		if keys != nil {
+			*keys = append(*keys, key)
+		}
+		if offset, err = t.scan0(offset); err != nil {
+			return 0, fmt.Errorf("%s: %s", key, err)
+		}
+	}
+}
+
+// members0 writes the members of an AMF0 object, with their names when they
+// are dynamic members in AMF3.
+func (t *transcoder) members0(offset int, names bool) (int, error) {
+	for {
+		key, n, err := decodeUTF8(t.data[offset:])
+		if err != nil {
+			return 0, err
+		}
+		offset += n
+		if key == "" {
+			if offset >= len(t.data) || t.data[offset] != amf0ObjectEnd {
+				return 0, fmt.Errorf("invalid end of object")
+			}
+			if names {
+				t.b = append(t.b, 0x01)
+			}
+			return offset + 1, nil
+		}
+		if names {
+			t.b = t.e.encodeUTF8VR(t.b, key)
+		}
+		if offset, err = t.value0(offset); err != nil {
+			return 0, fmt.Errorf("%s: %s", key, err)
+		}
+	}
+}
+
+func (t *transcoder) items(offset, num int, value func(int) (int, error)) (int, error) {
+	var err error
+	for i := 0; i < num; i++ {
+		if offset, err = value(offset); err != nil {
+			return 0, fmt.Errorf("[%d]: %s", i, err)
+		}
+	}
+	return offset, nil
+}
+
+// value3 writes the AMF3 value at offset in the output version.
+func (t *transcoder) value3(offset int) (int, error) {
+	if err := t.need(offset, 1); err != nil {
+		return 0, err
+	}
+	marker := t.data[offset]
+	switch marker {
+	case amf3Undefined, amf3Null, amf3False, amf3True:
+		if t.to == AMF3 {
+			t.b = append(t.b, marker)
+		} else if marker == amf3Undefined {
+			t.b = append(t.b, amf0Undefined)
+		} else if marker == amf3Null {
+			t.b = encodeNull(t.b)
+		} else {
+			t.b = encodeBoolean(t.b, marker == amf3True)
+		}
+		return offset + 1, nil
+	case amf3Integer:
+		v, n, err := decodeInteger3(t.data[offset:])
+		if t.to == AMF0 {
+			t.b = encodeNumber(t.b, float64(v))
+		} else {
+			t.b = encodeInteger3(t.b, v)
+		}
+		return offset + n, err
+	case amf3Double:
+		v, n, err := decodeDouble3(t.data[offset:])
+		if t.to == AMF0 {
+			t.b = encodeNumber(t.b, v)
+		} else {
+			t.b = encodeDouble3(t.b, v)
+		}
+		return offset + n, err
+	case amf3String:
+		s, n, err := t.d.decodeString3(t.data[offset:])
+		if t.to == AMF0 {
+			t.b = encodeString(t.b, s)
+		} else {
+			t.b = t.e.encodeString3(t.b, s)
+		}
+		return offset + n, err
+	}
+	ref, n, err := decodeU29(t.data[offset+1:])
+	if err != nil {
+		return 0, err
+	}
+	if ref&1 == 0 {
+		return offset + 1 + n, t.ref(t.objects3, ref>>1)
+	}
+	offset += 1 + n
+	switch marker {
+	case amf3Date:
+		return t.bytes3(marker, ref, offset, 8)
+	case amf3XMLDocument, amf3XML, amf3ByteArray:
+		return t.bytes3(marker, ref, offset, ref>>1)
+	case amf3Array:
+		return t.array3(ref, offset)
+	case amf3Object:
+		return t.object3(ref, offset)
+	case amf3VectorInt, amf3VectorUint, amf3VectorDouble, amf3VectorObject:
+		return t.vector3(marker, ref, offset)
+	case amf3Dictionary:
+		return t.dictionary3(ref, offset)
+	}
+	return 0, fmt.Errorf("unsupported type 0x%0X", marker)
+}
+
+// bytes3 writes a date, XML or byte array of size bytes.
+func (t *transcoder) bytes3(marker byte, ref, offset, size int) (int, error) {
+	if err := t.need(offset, size); err != nil {
+		return 0, err
+	}
+	data := t.data[offset : offset+size]
+	if t.to == AMF3 {
+		t.objects3 = append(t.objects3, t.add(marker))
+		t.b = append(t.b, marker)
+		t.b = encodeU29(t.b, ref)
+		t.b = append(t.b, data...)
+		return offset + size, nil
+	}
+	var value []byte
+	switch marker {
+	case amf3Date:
+		value = append([]byte{amf0Date}, data...)
+		value = append(value, 0x00, 0x00)
+	case amf3ByteArray:
+		value = encodeByteArray3([]byte{amf0AVMPlus}, data)
+	default:
+		value = binary.BigEndian.AppendUint32([]byte{amf0XMLDocument}, uint32(size))
+		value = append(value, data...)
+	}
+	t.objects3 = append(t.objects3, target{value: value})
+	t.b = append(t.b, value...)
+	return offset + size, nil
+}
+
+func (t *transcoder) array3(ref, offset int) (int, error) {
+	num := ref >> 1
+	var r target
+	// As in decodeArray3, an array without named members or items is a
+	// strict array.
+	named := offset < len(t.data) && t.data[offset] != 0x01
+	countAt := -1
+	switch {
+	case t.to == AMF3:
+		r = t.add(amf3Array)
+		t.b = append(t.b, amf3Array)
+		t.b = encodeU29(t.b, ref)
+	case named:
+		r = t.add(amf0Array)
+		t.b = append(t.b, amf0Array)
+		countAt = len(t.b)
+		t.b = append(t.b, 0, 0, 0, 0)
+	default:
+		r = t.add(amf0StrictArr)
+		t.b = append(t.b, amf0StrictArr)
+		t.b = binary.BigEndian.AppendUint32(t.b, uint32(num))
+	}
+	t.objects3 = append(t.objects3, r)
+	offset, count, err := t.dynamic3(offset)
+	if err != nil {
+		return 0, err
+	}
+	if countAt < 0 {
+		return t.items(offset, num, t.value3)
+	}
+	for i := 0; i < num; i++ {
+		key := strconv.Itoa(i)
+		t.b = encodeUTF8(t.b, key)
+		if offset, err = t.value3(offset); err != nil {
+			return 0, fmt.Errorf("[%d]: %s", i, err)
+		}
+	}
+	binary.BigEndian.PutUint32(t.b[countAt:], uint32(count+num))
+	t.b = append(t.b, 0x00, 0x00, amf0ObjectEnd)
+	return offset, nil
+}
+
+func (t *transcoder) object3(ref, offset int) (int, error) {
+	traits, n, err := t.d.decodeTraits3(t.data[offset:], ref)
+	if err != nil {
+		return 0, err
+	}
+	offset += n
+	if traits.externalizable {
+		if traits.class != arrayCollectionClass {
+			return 0, fmt.Errorf("unsupported externalizable class %s", traits.class)
+		}
+		return t.arrayCollection3(offset)
+	}
+	switch {
+	case t.to == AMF3:
+		t.objects3 = append(t.objects3, t.add(amf3Object))
+		t.b = append(t.b, amf3Object)
+		t.b = t.e.encodeTraits3(t.b, traits.class, traits.sealed, traits.dynamic)
+	case traits.class == "":
+		t.objects3 = append(t.objects3, t.add(amf0Object))
+		t.b = append(t.b, amf0Object)
+	default:
+		t.objects3 = append(t.objects3, t.add(amf0TypedObject))
+		t.b = append(t.b, amf0TypedObject)
+		t.b = encodeUTF8(t.b, traits.class)
+	}
+	for _, name := range traits.sealed {
+		if t.to == AMF0 {
+			t.b = encodeUTF8(t.b, name)
+		}
+		if offset, err = t.value3(offset); err != nil {
+			return 0, fmt.Errorf("%s: %s", name, err)
+		}
+	}
+	if traits.dynamic {
+		if offset, _, err = t.dynamic3(offset); err != nil {
+			return 0, err
+		}
+	}
+	if t.to == AMF0 {
+		t.b = append(t.b, 0x00, 0x00, amf0ObjectEnd)
+	}
+	return offset, nil
+}
+
+// arrayCollection3 writes an ArrayCollection, which in AMF0 is the array it
+// wraps, references to the collection standing for the array.
+func (t *transcoder) arrayCollection3(offset int) (int, error) {
+	index := len(t.objects3)
+	if t.to == AMF3 {
+		t.objects3 = append(t.objects3, t.add(amf3Object))
+		t.b = append(t.b, amf3Object)
+		t.b = t.e.encodeExternalizableTraits3(t.b, arrayCollectionClass)
+		return t.value3(offset)
+	}
+	t.objects3 = append(t.objects3, target{})
+	next, start := t.nobjects, len(t.b)
+	end, err := t.value3(offset)
+	if err != nil {
+		return 0, err
+	}
+	if t.nobjects > next {
+		t.objects3[index] = target{index: next}
+	} else {
+		t.objects3[index] = target{value: slices.Clone(t.b[start:])}
+	}
+	return end, nil
+}
+
+// dynamic3 writes name and value pairs up to the empty name, which ends them
+// in AMF3 but not in AMF0, and returns their number.
+func (t *transcoder) dynamic3(offset int) (int, int, error) {
+	for count := 0; ; count++ {
+		key, n, err := t.d.decodeUTF8VR(t.data[offset:])
+		if err != nil {
+			return 0, 0, err
+		}
+		offset += n
+		if key == "" {
+			if t.to == AMF3 {
+				t.b = append(t.b, 0x01)
+			}
+			return offset, count, nil
+		}
+		if t.to == AMF0 {
+			t.b = encodeUTF8(t.b, key)
+		} else {
+			t.b = t.e.encodeUTF8VR(t.b, key)
+		}
+		if offset, err = t.value3(offset); err != nil {
+			return 0, 0, fmt.Errorf("%s: %s", key, err)
+		}
+	}
+}
+
+func (t *transcoder) vector3(marker byte, ref, offset int) (int, error) {
+	num := ref >> 1
+	if err := t.need(offset, 1); err != nil {
+		return 0, err
+	}
+	fixed := t.data[offset]
+	offset++
+	if t.to == AMF3 {
+		t.objects3 = append(t.objects3, t.add(marker))
+		t.b = append(t.b, marker)
+		t.b = encodeU29(t.b, ref)
+		t.b = append(t.b, fixed)
+	} else {
+		t.objects3 = append(t.objects3, t.add(amf0StrictArr))
+		t.b = append(t.b, amf0StrictArr)
+		t.b = binary.BigEndian.AppendUint32(t.b, uint32(num))
+	}
+	if marker == amf3VectorObject {
+		class, n, err := t.d.decodeUTF8VR(t.data[offset:])
+		if err != nil {
+			return 0, err
+		}
+		offset += n
+		if t.to == AMF3 {
+			t.b = t.e.encodeUTF8VR(t.b, class)
+		}
+		return t.items(offset, num, t.value3)
+	}
+	size := 4
+	if marker == amf3VectorDouble {
+		size = 8
+	}
+	if num > (len(t.data)-offset)/size {
+		return 0, fmt.Errorf("EOF")
+	}
+	items := t.data[offset : offset+num*size]
+	if t.to == AMF3 {
+		t.b = append(t.b, items...)
+		return offset + len(items), nil
+	}
+	for i := 0; i < num; i++ {
+		var f float64
+		switch marker {
+		case amf3VectorInt:
+			f = float64(int32(binary.BigEndian.Uint32(items[i*4:])))
+		case amf3VectorUint:
+			f = float64(binary.BigEndian.Uint32(items[i*4:]))
+		default:
+			f = math.Float64frombits(binary.BigEndian.Uint64(items[i*8:]))
+		}
+		t.b = encodeNumber(t.b, f)
+	}
+	return offset + len(items), nil
+}
+
+func (t *transcoder) dictionary3(ref, offset int) (int, error) {
+	if t.to == AMF0 {
+		return 0, fmt.Errorf("dictionary has no AMF0 counterpart")
+	}
+	if err := t.need(offset, 1); err != nil {
+		return 0, err
+	}
+	t.objects3 = append(t.objects3, t.add(amf3Dictionary))
+	t.b = append(t.b, amf3Dictionary)
+	t.b = encodeU29(t.b, ref)
+	t.b = append(t.b, t.data[offset])
+	// Keys and values alternate.
+	return t.items(offset+1, ref>>1*2, t.value3)
+}
@@ -0,0 +1,172 @@
+package amf
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestTranscode(t *testing.T) {
+	for _, c := range []struct {
+		from AMFVersion
+		in   string
+		want string
+	}{
+		{AMF0, `1`, `int(1)`},
+		{AMF0, `-268435456`, `int(-268435456)`},
+		{AMF0, `268435456`, `268435456`},
+		{AMF0, `1.5`, `1.5`},
+		{AMF0, `undefined`, `undefined`},
+		{AMF0, `ecma{"0": "a", b: 2}`, `ecma{"0": "a", b: int(2)}`},
+		{AMF0, `obj<com.example.User>{id: 7, tags: ["a", 2]}`, `obj<com.example.User>{id: int(7), tags: ["a", int(2)]}`},
+		{AMF0, `{b: null, a: date("2009-02-13T23:31:30.123Z")}`, `{b: null, a: date("2009-02-13T23:31:30.123Z")}`},
+		{AMF3, `int(1)`, `1`},
+		{AMF3, `ecma{b: int(2)}`, `ecma{b: 2}`},
+		{AMF3, `obj<com.example.User>{id: int(7); extra: bytes("0a0b")}`, `obj<com.example.User>{id: 7, extra: bytes("0a0b")}`},
+		{AMF3, `{b: undefined, a: [true]}`, `{b: undefined, a: [true]}`},
+	} {
+		to, transcodeFn := AMF3, TranscodeAMF0ToAMF3
+		if c.from == AMF3 {
+			to, transcodeFn = AMF0, TranscodeAMF3ToAMF0
+		}
+		got, err := transcodeFn(mustEncodeText(t, c.from, c.in))
+		if err != nil {
+			t.Errorf("transcoding %s: %s", c.in, err)
+			continue
+		}
+		if want := mustEncodeText(t, to, c.want); !bytes.Equal(got, want) {
+			t.Errorf("transcoding %s == %x, want %x (%s)", c.in, got, want, c.want)
+		}
+	}
+}
+
+func TestTranscodeReferences(t *testing.T) {
+	shared := &OrderedObject{Class: "com.example.Group", Sealed: 1}
+	shared.Set("name", "admins")
+	tags := []interface{}{"a", "b"}
+	cyclic := &OrderedObject{}
+	cyclic.Set("self", cyclic)
+	// Values after the first refer to objects in it, as in the arguments of
+	// an RTMP command.
+	values := []interface{}{[]interface{}{shared, shared, tags, tags, cyclic}, shared}
+	var amf0, amf3 []byte
+	e0, e3 := NewEncoder(), NewEncoder()
+	for _, v := range values {
+		var err error
+		if amf0, err = e0.AppendAMF0(amf0, v); err != nil {
+			t.Fatal(err)
+		}
+		if amf3, err = e3.AppendAMF3(amf3, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, err := TranscodeAMF0ToAMF3(amf0); err != nil || !bytes.Equal(got, amf3) {
+		t.Errorf("TranscodeAMF0ToAMF3 == %x, %v, want %x", got, err, amf3)
+	}
+	if got, err := TranscodeAMF3ToAMF0(amf3); err != nil || !bytes.Equal(got, amf0) {
+		t.Errorf("TranscodeAMF3ToAMF0 == %x, %v, want %x", got, err, amf0)
+	}
+}
+
+func TestTranscodeMarkers(t *testing.T) {
+	for _, c := range []struct {
+		from    AMFVersion
+		in, out []byte
+	}{
+		// Shared empty arrays, in both directions.
+		{AMF0, []byte{0x0a, 0x00, 0x00, 0x00, 0x02, 0x0a, 0x00, 0x00, 0x00, 0x00, 0x07, 0x00, 0x01},
+			[]byte{0x09, 0x05, 0x01, 0x09, 0x01, 0x01, 0x09, 0x02}},
+		{AMF3, []byte{0x09, 0x05, 0x01, 0x09, 0x01, 0x01, 0x09, 0x02},
+			[]byte{0x0a, 0x00, 0x00, 0x00, 0x02, 0x0a, 0x00, 0x00, 0x00, 0x00, 0x07, 0x00, 0x01}},
+		// A typed object referring to itself.
+		{AMF0, []byte{0x10, 0x00, 0x01, 0x41, 0x00, 0x01, 0x61, 0x07, 0x00, 0x00, 0x00, 0x00, 0x09},
+			[]byte{0x0a, 0x13, 0x03, 0x41, 0x03, 0x61, 0x0a, 0x00}},
+		{AMF0, []byte{0x0c, 0x00, 0x00, 0x00, 0x01, 0x61}, []byte{0x06, 0x03, 0x61}},
+		{AMF0, []byte{0x0d}, []byte{0x00}},
+		{AMF0, []byte{0x0f, 0x00, 0x00, 0x00, 0x02, 0x3c, 0x61}, []byte{0x07, 0x05, 0x3c, 0x61}},
+		// ["a", <AVM+> ["b", "b", [], <ref 1>]]: the strings and objects
+		// after the AVM+ marker are renumbered.
+		{AMF0, []byte{0x0a, 0x00, 0x00, 0x00, 0x02, 0x02, 0x00, 0x01, 0x61,
+			0x11, 0x09, 0x09, 0x01, 0x06, 0x03, 0x62, 0x06, 0x00, 0x09, 0x01, 0x01, 0x09, 0x02},
+			[]byte{0x09, 0x05, 0x01, 0x06, 0x03, 0x61,
+				0x09, 0x09, 0x01, 0x06, 0x03, 0x62, 0x06, 0x02, 0x09, 0x01, 0x01, 0x09, 0x04}},
+		{AMF0, []byte{0x11, 0x0d, 0x05, 0x01, 0xff, 0xff, 0xff, 0xff, 0x00, 0x00, 0x00, 0x01},
+			[]byte{0x0d, 0x05, 0x01, 0xff, 0xff, 0xff, 0xff, 0x00, 0x00, 0x00, 0x01}},
+		{AMF0, []byte{0x11, 0x11, 0x03, 0x00, 0x06, 0x03, 0x61, 0x0b, 0x03, 0x62},
+			[]byte{0x11, 0x03, 0x00, 0x06, 0x03, 0x61, 0x0b, 0x03, 0x62}},
+		{AMF3, []byte{0x07, 0x05, 0x3c, 0x61}, []byte{0x0f, 0x00, 0x00, 0x00, 0x02, 0x3c, 0x61}},
+		// XML, dates and byte arrays are written again for references.
+		{AMF3, []byte{0x09, 0x05, 0x01, 0x0b, 0x03, 0x61, 0x0b, 0x02},
+			[]byte{0x0a, 0x00, 0x00, 0x00, 0x02,
+				0x0f, 0x00, 0x00, 0x00, 0x01, 0x61, 0x0f, 0x00, 0x00, 0x00, 0x01, 0x61}},
+		{AMF3, []byte{0x09, 0x05, 0x01, 0x08, 0x01, 0x3f, 0xf0, 0, 0, 0, 0, 0, 0, 0x08, 0x02},
+			[]byte{0x0a, 0x00, 0x00, 0x00, 0x02,
+				0x0b, 0x3f, 0xf0, 0, 0, 0, 0, 0, 0, 0x00, 0x00, 0x0b, 0x3f, 0xf0, 0, 0, 0, 0, 0, 0, 0x00, 0x00}},
+		{AMF3, []byte{0x09, 0x05, 0x01, 0x0c, 0x03, 0x01, 0x0c, 0x02},
+			[]byte{0x0a, 0x00, 0x00, 0x00, 0x02, 0x11, 0x0c, 0x03, 0x01, 0x11, 0x0c, 0x03, 0x01}},
+		// [int(2); a: int(1)]
+		{AMF3, []byte{0x09, 0x03, 0x03, 0x61, 0x04, 0x01, 0x01, 0x04, 0x02},
+			[]byte{0x08, 0x00, 0x00, 0x00, 0x02,
+				0x00, 0x01, 0x61, 0x00, 0x3f, 0xf0, 0, 0, 0, 0, 0, 0,
+				0x00, 0x01, 0x30, 0x00, 0x40, 0x00, 0, 0, 0, 0, 0, 0,
+				0x00, 0x00, 0x09}},
+		{AMF3, []byte{0x0d, 0x05, 0x00, 0xff, 0xff, 0xff, 0xff, 0x00, 0x00, 0x00, 0x01},
+			[]byte{0x0a, 0x00, 0x00, 0x00, 0x02, 0x00, 0xbf, 0xf0, 0, 0, 0, 0, 0, 0, 0x00, 0x3f, 0xf0, 0, 0, 0, 0, 0, 0}},
+		{AMF3, []byte{0x0e, 0x03, 0x00, 0xff, 0xff, 0xff, 0xff},
+			[]byte{0x0a, 0x00, 0x00, 0x00, 0x01, 0x00, 0x41, 0xef, 0xff, 0xff, 0xff, 0xe0, 0x00, 0x00}},
+		{AMF3, []byte{0x0f, 0x03, 0x00, 0x3f, 0xf8, 0, 0, 0, 0, 0, 0},
+			[]byte{0x0a, 0x00, 0x00, 0x00, 0x01, 0x00, 0x3f, 0xf8, 0, 0, 0, 0, 0, 0}},
+		// A Vector.<Object> holding itself.
+		{AMF3, []byte{0x10, 0x05, 0x00, 0x01, 0x06, 0x03, 0x61, 0x10, 0x00},
+			[]byte{0x0a, 0x00, 0x00, 0x00, 0x02, 0x02, 0x00, 0x01, 0x61, 0x07, 0x00, 0x00}},
+		// [<ArrayCollection> [], <ref 1>]
+		{AMF3, append(append([]byte{0x09, 0x05, 0x01, 0x0a, 0x07, 0x43}, "flex.messaging.io.ArrayCollection"...), 0x09, 0x01, 0x01, 0x0a, 0x02),
+			[]byte{0x0a, 0x00, 0x00, 0x00, 0x02, 0x0a, 0x00, 0x00, 0x00, 0x00, 0x07, 0x00, 0x01}},
+	} {
+		transcodeFn := TranscodeAMF0ToAMF3
+		if c.from == AMF3 {
+			transcodeFn = TranscodeAMF3ToAMF0
+		}
+		if got, err := transcodeFn(c.in); err != nil || !bytes.Equal(got, c.out) {
+			t.Errorf("transcoding % x == % x, %v, want % x", c.in, got, err, c.out)
+		}
+	}
+	collections := append(append([]byte{0x09, 0x05, 0x01, 0x0a, 0x07, 0x43}, "flex.messaging.io.ArrayCollection"...),
+		0x09, 0x01, 0x01, 0x0a, 0x01, 0x09, 0x01, 0x01)
+	if got, err := transcode(collections, AMF3, AMF3); err != nil || !bytes.Equal(got, collections) {
+		t.Errorf("transcoding ArrayCollections == % x, %v, want % x", got, err, collections)
+	}
+	if _, err := TranscodeAMF3ToAMF0([]byte{0x11, 0x01, 0x00}); err == nil {
+		t.Error("TranscodeAMF3ToAMF0 of a dictionary succeeded")
+	}
+}
+
+func TestTranscodeError(t *testing.T) {
+	if _, err := TranscodeAMF0ToAMF3([]byte{0x05, 0x07, 0x00, 0x01}); err == nil || err.Error() != "offset 1: invalid reference 1" {
+		t.Errorf("TranscodeAMF0ToAMF3 error == %v", err)
+	}
+}
+
+func TestTranscodeNestedTypedObjects(t *testing.T) {
+	// Each typed object is scanned for its member names once, whatever its
+	// depth, so deep nesting transcodes in linear time.
+	const depth = 10000
+	var in []byte
+	for i := 0; i < depth; i++ {
+		in = append(in, 0x10, 0x00, 0x01, 0x41, 0x00, 0x01, 0x61)
+	}
+	in = append(in, 0x05)
+	for i := 0; i < depth; i++ {
+		in = append(in, 0x00, 0x00, 0x09)
+	}
+	v, _, err := DecodeAMF0(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := AppendAMF3(nil, v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := TranscodeAMF0ToAMF3(in); err != nil || !bytes.Equal(got, want) {
+		t.Errorf("TranscodeAMF0ToAMF3 of %d nested typed objects == %d bytes, %v, want %d", depth, len(got), err, len(want))
+	}
+}
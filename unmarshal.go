@@ -0,0 +1,326 @@
+package amf
+
+import (
+	"encoding"
+	"fmt"
+	"math"
+	"reflect"
+	"time"
+)
+
+// Unmarshal decodes one value from data and stores it in the value pointed
+// to by v. Types implementing AMF0Unmarshaler or AMF3Unmarshaler receive the
+// decoded value, and encoding.TextUnmarshaler is used for strings. Objects
+// fill structs by the same member names used for encoding, and objects of a
+// class registered with RegisterClass become values of its type in empty
+// interfaces. A value referred to more than once is stored once, in the same
+// pointer, slice or map, and a cycle through struct values is an error.
+//
+// Targets holding a RawAMF0 or RawAMF3 are filled by walking data, decoding
+// only the values stored in other types. As raw values are captured relative
+// to data, this needs a Decoder that has not decoded earlier values.
+func Unmarshal(data []byte, version AMFVersion, v interface{}) error {
+	return NewDecoder().Unmarshal(data, version, v)
+}
+
+// DecodeAs is Unmarshal returning the value, converting numbers to the
+// numeric kind of T.
+func DecodeAs[T any](data []byte, version AMFVersion) (T, error) {
+	var v T
+	if err := Unmarshal(data, version, &v); err != nil {
+		var zero T
+		return zero, err
+	}
+	return v, nil
+}
+
+func (d *Decoder) Unmarshal(data []byte, version AMFVersion, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return fmt.Errorf("unmarshal target must be a non-nil pointer, not %T", v)
+	}
+	if hasRaw(rv.Elem().Type()) && (version == AMF0 || version == AMF3) {
+		if len(d.objects0) > 0 || len(d.strings3) > 0 || len(d.objects3) > 0 || len(d.traits3) > 0 {
+			return fmt.Errorf("cannot unmarshal raw values after other values")
+		}
+		raw, err := (&rawWalker{data: data}).raw(version, 0)
+		if err == nil {
+			err = raw.setEnd()
+		}
+		if err == nil {
+			err = d.trailing(data, raw.end)
+		}
+		if err != nil {
+			return err
+		}
+		return d.unmarshalRaw(version, raw, rv.Elem())
+	}
+	var value interface{}
+	var n int
+	var err error
+	switch version {
+	case AMF0:
+		value, n, err = d.decodeAMF0(data)
+	case AMF3:
+		value, n, err = d.decodeAMF3(data)
+	default:
+		return fmt.Errorf("unsupported version %d", version)
+	}
+	if err == nil {
+		err = d.trailing(data, n)
+	}
+	if err != nil {
+		return err
+	}
+	return unmarshalValue(version, value, rv.Elem())
+}
+
+func unmarshaler(version AMFVersion, rv reflect.Value, value interface{}) (bool, error) {
+	if !rv.CanAddr() {
+		return false, nil
+	}
+	target := rv.Addr().Interface()
+	if u, ok := target.(AMF0Unmarshaler); ok && version == AMF0 {
+		return true, u.UnmarshalAMF0(value)
+	}
+	if u, ok := target.(AMF3Unmarshaler); ok && version == AMF3 {
+		return true, u.UnmarshalAMF3(value)
+	}
+	if u, ok := target.(encoding.TextUnmarshaler); ok {
+		if s, ok := value.(string); ok {
+			return true, u.UnmarshalText([]byte(s))
+		}
+	}
+	return false, nil
+}
+
+// objectMembers returns the members of any decoded object-like value.
+func objectMembers(value interface{}) (map[string]interface{}, bool) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return v, true
+	case ECMAArray:
+		return v, true
+	case TypedObject:
+		return v.Members, true
+	case *OrderedObject:
+		return v.values, true
+	case *OrderedECMAArray:
+		return v.values, true
+	}
+	return nil, false
+}
+
+func unmarshalValue(version AMFVersion, value interface{}, rv reflect.Value) error {
+	u := &unmarshaling{version: version, built: make(map[built]reflect.Value), building: make(map[built]bool)}
+	return u.value(value, rv)
+}
+
+// unmarshaling maps the decoded objects and arrays already stored to the
+// pointers, slices and maps built for them, so that references to them share
+// those values and cycles end.
+type unmarshaling struct {
+	version AMFVersion
+	built   map[built]reflect.Value
+	// building holds the values being stored in structs, which can't
+	// contain themselves.
+	building map[built]bool
+}
+
+// built is a decoded value, as returned by identity, stored in a Go type.
+type built struct {
+	id  interface{}
+	typ reflect.Type
+}
+
+// shared returns the value already built for value in rv's type.
+func (u *unmarshaling) shared(value interface{}, rv reflect.Value) (built, bool) {
+	id, ok := Identity(value)
+	if !ok {
+		return built{}, false
+	}
+	key := built{id, rv.Type()}
+	if v, ok := u.built[key]; ok {
+		rv.Set(v)
+		return key, true
+	}
+	return key, false
+}
+
+func (u *unmarshaling) value(value interface{}, rv reflect.Value) error {
+	if ok, err := unmarshaler(u.version, rv, value); ok {
+		return err
+	}
+	if rv.Kind() == reflect.Pointer {
+		if value == nil {
+			rv.SetZero()
+			return nil
+		}
+		ref, ok := u.shared(value, rv)
+		if ok {
+			return nil
+		}
+		if rv.IsNil() {
+			rv.Set(reflect.New(rv.Type().Elem()))
+		}
+		if ref.id != nil {
+			u.built[ref] = reflect.ValueOf(rv.Interface())
+		}
+		return u.value(value, rv.Elem())
+	}
+	if value == nil {
+		rv.SetZero()
+		return nil
+	}
+	if rv.Kind() == reflect.Interface && rv.NumMethod() == 0 {
+		if t, ok := registeredType(className(value)); ok {
+			item := reflect.New(t).Elem()
+			if err := u.value(value, item); err != nil {
+				return err
+			}
+			rv.Set(item)
+			return nil
+		}
+		rv.Set(reflect.ValueOf(value))
+		return nil
+	}
+	switch rv.Kind() {
+	case reflect.Bool:
+		b, ok := value.(bool)
+		if !ok {
+			return mismatch(value, rv)
+		}
+		rv.SetBool(b)
+	case reflect.String:
+		s, ok := value.(string)
+		if !ok {
+			return mismatch(value, rv)
+		}
+		rv.SetString(s)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64:
+		return setNumber(value, rv)
+	case reflect.Slice:
+		if rv.Type().Elem().Kind() == reflect.Uint8 {
+			if b, ok := value.([]byte); ok {
+				rv.SetBytes(b)
+				return nil
+			}
+		}
+		items, ok := value.([]interface{})
+		if !ok {
+			return mismatch(value, rv)
+		}
+		ref, ok := u.shared(value, rv)
+		if ok {
+			return nil
+		}
+		result := reflect.MakeSlice(rv.Type(), len(items), len(items))
+		if ref.id != nil {
+			u.built[ref] = result
+		}
+		for i, item := range items {
+			if err := u.value(item, result.Index(i)); err != nil {
+				return fmt.Errorf("[%d]: %s", i, err)
+			}
+		}
+		rv.Set(result)
+	case reflect.Map:
+		members, ok := objectMembers(value)
+		if !ok || rv.Type().Key().Kind() != reflect.String {
+			return mismatch(value, rv)
+		}
+		ref, ok := u.shared(value, rv)
+		if ok {
+			return nil
+		}
+		result := reflect.MakeMapWithSize(rv.Type(), len(members))
+		if ref.id != nil {
+			u.built[ref] = result
+		}
+		for key, member := range members {
+			item := reflect.New(rv.Type().Elem()).Elem()
+			if err := u.value(member, item); err != nil {
+				return fmt.Errorf("%s: %s", key, err)
+			}
+			result.SetMapIndex(reflect.ValueOf(key).Convert(rv.Type().Key()), item)
+		}
+		rv.Set(result)
+	case reflect.Struct:
+		if t, ok := value.(time.Time); ok && rv.Type() == reflect.TypeFor[time.Time]() {
+			rv.Set(reflect.ValueOf(t))
+			return nil
+		}
+		members, ok := objectMembers(value)
+		if !ok {
+			return mismatch(value, rv)
+		}
+		if id, ok := Identity(value); ok {
+			ref := built{id, rv.Type()}
+			if u.building[ref] {
+				return fmt.Errorf("cycle through %s", rv.Type())
+			}
+			u.building[ref] = true
+			defer delete(u.building, ref)
+			// Pointers to the object are to this struct.
+			if rv.CanAddr() {
+				u.built[built{id, reflect.PointerTo(rv.Type())}] = rv.Addr()
+			}
+		}
+		for _, f := range structFields(rv.Type()) {
+			member, ok := members[f.Name]
+			if !ok {
+				continue
+			}
+			if err := u.value(member, rv.Field(f.Index)); err != nil {
+				return fmt.Errorf("%s: %s", f.Name, err)
+			}
+		}
+	default:
+		return mismatch(value, rv)
+	}
+	return nil
+}
+
+// setNumber stores an AMF Number or Integer in any Go numeric kind, failing
+// rather than truncating or overflowing.
+func setNumber(value interface{}, rv reflect.Value) error {
+	var f float64
+	switch n := value.(type) {
+	case int:
+		f = float64(n)
+	case float64:
+		f = n
+	default:
+		return mismatch(value, rv)
+	}
+	switch rv.Kind() {
+	case reflect.Float32, reflect.Float64:
+		if rv.OverflowFloat(f) {
+			return fmt.Errorf("%v overflows %s", value, rv.Type())
+		}
+		rv.SetFloat(f)
+		return nil
+	}
+	if f != math.Trunc(f) {
+		return fmt.Errorf("%v is not an integer for %s", value, rv.Type())
+	}
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if f < math.MinInt64 || f >= math.MaxInt64 || rv.OverflowInt(int64(f)) {
+			return fmt.Errorf("%v overflows %s", value, rv.Type())
+		}
+		rv.SetInt(int64(f))
+	default:
+		if f < 0 || f >= math.MaxUint64 || rv.OverflowUint(uint64(f)) {
+			return fmt.Errorf("%v overflows %s", value, rv.Type())
+		}
+		rv.SetUint(uint64(f))
+	}
+	return nil
+}
+
+func mismatch(value interface{}, rv reflect.Value) error {
+	return fmt.Errorf("cannot unmarshal %T into %s", value, rv.Type())
+}